@@ -2,41 +2,131 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/netip"
 	"os"
 	"os/exec"
 	"os/signal"
+	"reflect"
+	"sort"
 	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/costela/wesher/cluster"
+	"github.com/costela/wesher/codec"
 	"github.com/costela/wesher/common"
 	"github.com/costela/wesher/etchosts"
 	"github.com/costela/wesher/wg"
 	"github.com/hashicorp/go-sockaddr"
+	"github.com/hashicorp/memberlist"
 	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
 )
 
+// deferredPeersRetryInterval is the time after which peers deferred by probing are probed again, and failed interface
+// setups are retried.
+const deferredPeersRetryInterval = 30 * time.Second
+
+// gossipCompressionAuto is the --gossip-compression mode negotiating compression with all cluster members.
+const gossipCompressionAuto = "auto"
+
+// onExitPreserve is the --on-exit policy keeping the wireguard interface on clean termination.
+const onExitPreserve = "preserve"
+
 type AgentCmd struct {
-	ClusterKey       key          `env:"WESHER_CLUSTER_KEY" help:"shared key for cluster membership; must be 32 bytes base64 encoded; will be generated if not provided"`
-	Join             []string     `env:"WESHER_JOIN" help:"comma separated list of hostnames or IP addresses to existing cluster members; if not provided, will attempt resuming any known state or otherwise wait for further members."`
-	Init             bool         `env:"WESHER_INIT" help:"whether to explicitly (re)initialize the cluster; any known state from previous runs will be forgotten"`
-	BindAddr         string       `env:"WESHER_BIND_ADDR" help:"IP address to bind to for cluster membership traffic (cannot be used with --bind-iface)"`
-	BindIface        string       `env:"WESHER_BIND_IFACE" help:"Interface to bind to for cluster membership traffic (cannot be used with --bind-addr)"`
-	ClusterPort      int          `env:"WESHER_CLUSTER_PORT" help:"port used for membership gossip traffic (both TCP and UDP); must be the same across cluster" default:"7946"`
-	WireguardPort    int          `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
-	MTU              int          `env:"WESHER_MTU" hlp:"mtu for wireguard interface" default:"1420"`
-	OverlayNet       netip.Prefix `env:"WESHER_OVERLAY_NET" help:"the network in which to allocate addresses for the overlay mesh network (CIDR format); smaller networks increase the chance of IP collision" default:"10.0.0.0/8"`
-	Interface        string       `env:"WESHER_INTERFACE" help:"name of the wireguard interface to create and manage" default:"wgoverlay"`
-	NoEtcHosts       bool         `env:"WESHER_NO_ETC_HOSTS" help:"disable writing of entries to /etc/hosts"`
-	NodeUpdateScript string       `env:"WESHER_NODE_UPDATE_SCRIPT" help:"path to script which is executed everytime the service receives an update for a node"`
-	WireguardAddress string       `env:"WESHER_WIREGUARD_ADDRESS" help:"fixed address for the wireguard interface"`
+	Config                    kong.ConfigFlag `help:"JSON file with option values keyed by option name with dashes replaced by underscores (e.g. {\"wireguard_port\": 51820}); command line flags take precedence; on SIGHUP, mtu is re-read from it and applied to the running interface"`
+	AWSParamPrefix            awsParamPrefix  `name:"aws-param-prefix" help:"path in the AWS Parameter Store (e.g. /wesher/prod/) below which parameters named like options (e.g. overlay-net) provide their values; command line flags take precedence; on SIGHUP, mtu is re-read and applied to the running interface"`
+	AWSProfile                string          `name:"aws-profile" help:"AWS shared configuration profile whose credentials are used for --aws-param-prefix; by default, the standard AWS credential chain is used (AWS_* environment variables, default profile, EC2 instance role, etc.)"`
+	ClusterKey                key             `env:"WESHER_CLUSTER_KEY" help:"shared key for cluster membership; must be 32 bytes base64 encoded; will be generated if not provided"`
+	Join                      []string        `env:"WESHER_JOIN" help:"comma separated list of hostnames or IP addresses to existing cluster members; if not provided, will attempt resuming any known state or otherwise wait for further members."`
+	Init                      bool            `env:"WESHER_INIT" help:"whether to explicitly (re)initialize the cluster; any known state from previous runs will be forgotten"`
+	BindAddr                  string          `env:"WESHER_BIND_ADDR" help:"IP address to bind to for cluster membership traffic (cannot be used with --bind-iface)"`
+	BindIface                 string          `env:"WESHER_BIND_IFACE" help:"Interface to bind to for cluster membership traffic (cannot be used with --bind-addr)"`
+	OnExit                    string          `env:"WESHER_ON_EXIT" enum:"delete,preserve" help:"what to do when terminating cleanly (delete/preserve): delete leaves the cluster and removes the wireguard interface; preserve keeps the interface and does not leave the cluster, and the interface is adopted on the next start, so restarts (e.g. upgrades) do not disrupt traffic; crashes always leave the interface in place" default:"delete"`
+	FlapWindow                time.Duration   `env:"WESHER_FLAP_WINDOW" help:"nodes rejoining within this time after leaving the cluster are considered flapping" default:"30s"`
+	FlapSuppressPenalty       time.Duration   `env:"WESHER_FLAP_SUPPRESS_PENALTY" help:"time for which the node update script is not run after a node flapped" default:"60s"`
+	HandshakeTimeout          time.Duration   `env:"WESHER_HANDSHAKE_TIMEOUT" help:"maximum age of the latest handshake with a peer for it to be considered reachable, unless the peer advertises its own timeout" default:"3m"`
+	IsolationWatchdog         time.Duration   `env:"WESHER_ISOLATION_WATCHDOG" help:"if no peer had a handshake for this long despite peers being configured, recover by escalating steps: reconfigure the interface, recreate it, then rejoin the cluster; checked on every reachability check; 0 disables the watchdog" default:"0"`
+	HealthThreshold           float64         `env:"WESHER_HEALTH_THRESHOLD" help:"minimum fraction of peers with a recent handshake below which a warning is logged at each reachability check" default:"0.5"`
+	AdvertiseHandshakeTimeout time.Duration   `env:"WESHER_ADVERTISE_HANDSHAKE_TIMEOUT" help:"handshake timeout other nodes should use for this node instead of their own --handshake-timeout (e.g. longer for mobile nodes); 0 means no preference" default:"0"`
+	GossipBindAddr            string          `env:"WESHER_GOSSIP_BIND_ADDR" help:"IP:PORT to bind to for cluster membership traffic only (e.g. on a management interface); the address selected by --bind-addr/--bind-iface is then only advertised for wireguard traffic"`
+	AccountingURL             string          `env:"WESHER_ACCOUNTING_URL" help:"URL to periodically POST per-peer traffic (in bytes) since the previous report to, as JSON"`
+	AccountingInterval        time.Duration   `env:"WESHER_ACCOUNTING_INTERVAL" help:"interval in which per-peer traffic is reported to the accounting URL" default:"5m"`
+	AccountingLabels          []string        `env:"WESHER_ACCOUNTING_LABELS" help:"comma separated list of labels identifying peers in accounting reports (pubkey/name); traffic of peers sharing the same labels is summed, an empty list reports the total traffic only" default:"pubkey,name"`
+	AccountingAggregateAbove  int             `env:"WESHER_ACCOUNTING_AGGREGATE_ABOVE" help:"report only the total traffic of all peers in accounting reports while there are more than this many peers; 0 to disable" default:"0"`
+	AdvertiseFQDN             string          `name:"advertise-fqdn" env:"WESHER_ADVERTISE_FQDN" help:"DNS name resolving to this node, used by peers as wireguard endpoint instead of its address (e.g. for nodes behind CGNAT); peers re-resolve it every minute and prefer addresses of their bind address family"`
+	AdvertisePrefer           []string        `env:"WESHER_ADVERTISE_PREFER" help:"comma separated list of networks (CIDR format, or \"public\" for any non-private address) in order of preference, used to select the local address to bind to and advertise when not explicitly set"`
+	BroadcastClusterConfig    bool            `env:"WESHER_BROADCAST_CLUSTER_CONFIG" help:"broadcast this node's allowed IPs policy, handshake timeout and endpoint stability window as cluster config, adopted by all nodes not setting them explicitly"`
+	ClusterConfigPriority     int             `env:"WESHER_CLUSTER_CONFIG_PRIORITY" help:"priority of the broadcast cluster config; if several nodes broadcast one, the highest priority wins, ties are won by the lowest node name" default:"0"`
+	ClusterPort               int             `env:"WESHER_CLUSTER_PORT" help:"port used for membership gossip traffic (both TCP and UDP); must differ from the wireguard port and be the same across cluster" default:"7946"`
+	ClusterSizeHint           int             `env:"WESHER_CLUSTER_SIZE_HINT" help:"expected number of cluster nodes; larger clusters buffer more membership events and gossip to more nodes per round to converge faster" default:"10"`
+	ProxyProtocol             bool            `env:"WESHER_PROXY_PROTOCOL" help:"prefix outgoing gossip TCP connections with a PROXY protocol v2 header conveying their real source address, for proxies and load balancers in between"`
+	ProxyProtocolAccept       bool            `env:"WESHER_PROXY_PROTOCOL_ACCEPT" help:"read PROXY protocol v2 headers on incoming gossip TCP connections, if present, using the conveyed source address"`
+	WireguardPort             int             `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	MTU                       int             `env:"WESHER_MTU" hlp:"mtu for wireguard interface" default:"1420"`
+	WgMTUOverhead             string          `name:"wg-mtu-overhead" env:"WESHER_WG_MTU_OVERHEAD" help:"if set, overrides --mtu with the underlay interface's MTU minus this encapsulation overhead in bytes; \"auto\" uses the wireguard overhead for the bind address' family (60 for IPv4, 80 for IPv6)"`
+	OverlayNet                netip.Prefix    `env:"WESHER_OVERLAY_NET" help:"the network in which to allocate addresses for the overlay mesh network (CIDR format); smaller networks increase the chance of IP collision" default:"10.0.0.0/8"`
+	AddressFrom               string          `env:"WESHER_ADDRESS_FROM" enum:"name,pubkey" help:"what the overlay address is derived from (name/pubkey); pubkey ties the address to the wireguard key instead of the hostname" default:"name"`
+	NormalizeName             bool            `env:"WESHER_NORMALIZE_NAME" help:"normalize this node's name (lowercase, without trailing dot and whitespace) before using it as cluster identity and hashing it to the overlay address, so cosmetic hostname changes do not change either"`
+	OverlayAddrFormat         string          `env:"WESHER_OVERLAY_ADDR_FORMAT" help:"Go template constructing the overlay address from {{.Prefix}}, {{.Name}}, {{.HashHex}}, {{.HashBytes}} and {{.HostBits}}, instead of mapping the name's hash into the overlay network; the result must be inside the overlay network"`
+	InfrastructureNodes       []string        `env:"WESHER_INFRASTRUCTURE_NODES" help:"comma separated list of node names assigned sequential addresses from the start of the overlay network (e.g. gateways at .1, .2), in order; must be the same across the cluster"`
+	InfrastructureRange       int             `env:"WESHER_INFRASTRUCTURE_RANGE" help:"number of addresses at the start of the overlay network never assigned by hashing, kept for infrastructure nodes; 0 keeps just enough for --infrastructure-nodes; must be the same across the cluster" default:"0"`
+	HashSeed                  uint64          `env:"WESHER_HASH_SEED" help:"seed mixed into the hashing of node names to overlay addresses; changing it deterministically changes this node's address" default:"0"`
+	Interface                 string          `env:"WESHER_INTERFACE" help:"name of the wireguard interface to create and manage" default:"wgoverlay"`
+	InterfaceAlias            string          `env:"WESHER_INTERFACE_ALIAS" help:"human-readable alias to set on the wireguard interface (shown by \"ip link\"); \"identity\" sets a stable alias with a pseudo-MAC derived from the node name, for tools tracking interfaces by hardware address; like --interface-group, it also marks the interface as created by wesher"`
+	InterfaceUpTimeout        time.Duration   `env:"WESHER_INTERFACE_UP_TIMEOUT" help:"time to wait for a newly created wireguard interface to become available (e.g. while the kernel module loads)" default:"10s"`
+	InterfaceGroup            uint32          `env:"WESHER_INTERFACE_GROUP" help:"link group to put the wireguard interface in (see \"ip link show group\"); 0 means no group" default:"0"`
+	InterfaceEventsURL        string          `env:"WESHER_INTERFACE_EVENTS_URL" help:"URL to POST a JSON event to, every time the wireguard interface is recreated or its administrative or operational state changes"`
+	PIDFile                   string          `name:"pid-file" env:"WESHER_PID_FILE" help:"file to write the agent's PID to, used by \"cluster leave\" (default: /run/wesher/INTERFACE.pid)"`
+	LogDedupWindow            time.Duration   `env:"WESHER_LOG_DEDUP_WINDOW" help:"collapse identical log lines about interface configuration repeated within this time into one, reporting the number of repetitions; 0 disables deduplication" default:"0"`
+	NoStateFile               bool            `env:"WESHER_NO_STATE_FILE" help:"disable persisting the cluster state (known nodes and cluster key) under /var/lib/wesher, e.g. on read-only filesystems; any previously saved state is ignored"`
+	KeyFile                   string          `env:"WESHER_KEY_FILE" help:"file to persist the wireguard private key in, keeping the public key stable across restarts; created on first start; \"/dev/null\" generates a new key on every start (default: /var/lib/wesher/INTERFACE.key, or /dev/null with --no-state-file)"`
+	NoEtcHosts                bool            `env:"WESHER_NO_ETC_HOSTS" help:"disable writing of entries to /etc/hosts"`
+	NodeUpdateScript          string          `env:"WESHER_NODE_UPDATE_SCRIPT" help:"path to script which is executed everytime the service receives an update for a node"`
+	WgPostPeerAdd             string          `name:"wg-post-peer-add" env:"WESHER_WG_POST_PEER_ADD" help:"shell command executed once for each peer newly added to the wireguard interface, with WESHER_PEER_PUBKEY, WESHER_PEER_OVERLAY_ADDR and WESHER_PEER_ENDPOINT set in its environment"`
+	WgPostPeerAddTimeout      time.Duration   `name:"wg-post-peer-add-timeout" env:"WESHER_WG_POST_PEER_ADD_TIMEOUT" help:"time after which a --wg-post-peer-add command still running is killed" default:"30s"`
+	WireguardAddress          string          `env:"WESHER_WIREGUARD_ADDRESS" help:"fixed address for the wireguard interface; empty, \"0.0.0.0\" or \"::\" assign an address automatically"`
+	ConfigEventsURL           string          `env:"WESHER_CONFIG_EVENTS_URL" help:"URL to POST a JSON event to, every time a new configuration is applied to the wireguard interface"`
+	DrainGracePeriod          time.Duration   `env:"WESHER_DRAIN_GRACE_PERIOD" help:"time to keep the interface up after starting to drain (on SIGUSR1), before leaving the cluster" default:"30s"`
+	GossipCompression         string          `env:"WESHER_GOSSIP_COMPRESSION" enum:"none,snappy,zstd,auto" help:"compression used for gossiped node metadata (none/snappy/zstd/auto); compressed metadata can only be read by nodes supporting it, auto uses zstd only while all members support it" default:"none"`
+	NetlinkRetries            uint64          `env:"WESHER_NETLINK_RETRIES" help:"number of retries for transiently failing interface setup calls" default:"3"`
+	NetlinkRetryInterval      time.Duration   `env:"WESHER_NETLINK_RETRY_INTERVAL" help:"time to wait between retries of interface setup calls" default:"100ms"`
+	NodeDescription           string          `env:"WESHER_NODE_DESCRIPTION" help:"free-text description of this node (e.g. role or owner), shared with other cluster members for informational purposes"`
+	PeerProbeTimeout          time.Duration   `env:"WESHER_PEER_PROBE_TIMEOUT" help:"if set, probe peer endpoints for this long before configuring them, deferring peers which appear unreachable; 0 disables probing" default:"0"`
+	ReachabilityCheckInterval time.Duration   `env:"WESHER_REACHABILITY_CHECK_INTERVAL" help:"interval in which routes to all peers are verified and repaired if missing, and peer keys are checked against the cluster; 0 disables the check" default:"60s"`
+	OnOverlayAddrMismatch     string          `env:"WESHER_ON_OVERLAY_ADDR_MISMATCH" enum:"log,exit" help:"what to do if the reachability check finds the overlay address advertised to the cluster to differ from the one assigned to the interface (log/exit)" default:"log"`
+	Reserve                   []wg.AddrRange  `env:"WESHER_RESERVE" help:"comma separated list of address ranges (FROM-TO) inside the overlay network which are never assigned automatically, e.g. for static assignment"`
+	Strict                    bool            `env:"WESHER_STRICT" help:"fail on startup instead of only warning about problematic setups, e.g. local routes overlapping the overlay network"`
+	ReservationsFile          string          `env:"WESHER_RESERVATIONS_FILE" help:"file to export the overlay network and the addresses assigned to all nodes (including this one) to, e.g. for external IPAM/DHCP systems; rewritten on membership changes"`
+	ReservationsFormat        string          `env:"WESHER_RESERVATIONS_FORMAT" enum:"json,csv" help:"format of the reservations file (json/csv)" default:"json"`
+	DiscoveryBackend          string          `env:"WESHER_DISCOVERY_BACKEND" enum:",consul,etcd" help:"service discovery system to register all nodes' overlay addresses in as membership changes (consul/etcd); registration is best-effort" default:""`
+	DiscoveryURL              string          `name:"discovery-url" env:"WESHER_DISCOVERY_URL" help:"HTTP API URL of the service discovery system (e.g. http://127.0.0.1:8500 for consul, http://127.0.0.1:2379 for etcd)"`
+	DiscoveryPrefix           string          `env:"WESHER_DISCOVERY_PREFIX" help:"consul service name, or etcd key prefix, nodes are registered under" default:"wesher"`
+	LocalServiceIP            []netip.Addr    `name:"local-service-ip" env:"WESHER_LOCAL_SERVICE_IP" help:"comma separated list of additional addresses served by this node, which peers route to it along with its overlay address; must be inside the overlay network or a service range"`
+	ReserveRange              []netip.Prefix  `env:"WESHER_RESERVE_RANGE" help:"comma separated list of subnets (CIDR format) of the overlay network which are never assigned automatically, e.g. to keep a block free for future static assignment"`
+	StaticPeersFile           string          `env:"WESHER_STATIC_PEERS_FILE" help:"JSON file with peers to configure in addition to cluster members (see 'peers import'); re-read on cluster changes once modified, and on SIGHUP"`
+	TakeOver                  bool            `env:"WESHER_TAKE_OVER" help:"take over an existing interface with peers not configured by wesher (e.g. by wg-quick), replacing them; by default, such interfaces are left untouched"`
+	SelfRoute                 string          `env:"WESHER_SELF_ROUTE" enum:"interface,loopback,none" help:"how traffic to the local overlay address is delivered (interface/loopback/none): via the kernel's local route for the wireguard interface, additionally via loopback so it keeps working while the interface is down, or without local route" default:"interface"`
+	RouteOrder                string          `env:"WESHER_ROUTE_ORDER" enum:"link-first,routes-first" help:"order of interface reconfiguration steps (link-first/routes-first); routes-first installs peer routes before updating the interface address and MTU, if the interface is already up" default:"link-first"`
+	RouteTable                int             `env:"WESHER_ROUTE_TABLE" help:"routing table in which to install peer routes; if set, a rule steering traffic from the overlay address to this table is added; 0 means the main table" default:"0"`
+
+	// options shaping the peer configuration, shared with the diff commands
+	peerConfigFlags
 
 	// for easier local testing; will break etchosts entry
 	UseIPAsName bool `name:"ip-as-name" default:"false" hidden:""`
+	// for regression testing; records all wireguard client calls for replay with wg.ReplayClient
+	RecordWgSession string `name:"record-wg-session" hidden:""`
+
+	bindAddrDetected  bool
+	gossipBindAddr    netip.AddrPort
+	overlayAddrFormat *template.Template
 }
 
 func (a *AgentCmd) Validate() error {
@@ -48,50 +138,364 @@ func (a *AgentCmd) Validate() error {
 		return fmt.Errorf("unsupported overlay network size; net mask must be multiple of 8, got %d", a.OverlayNet.Bits())
 	}
 
+	if err := a.peerConfigFlags.validate(); err != nil {
+		return err
+	}
+
+	if a.OverlayAddrFormat != "" {
+		format, err := wg.ParseAddrFormat(a.OverlayAddrFormat)
+		if err != nil {
+			return fmt.Errorf("unsupported overlay address format: %w", err)
+		}
+		a.overlayAddrFormat = format
+	}
+
+	if a.InfrastructureRange != 0 && a.InfrastructureRange <= len(a.InfrastructureNodes) {
+		return fmt.Errorf("infrastructure range of %d addresses cannot hold %d infrastructure nodes after the network address", a.InfrastructureRange, len(a.InfrastructureNodes))
+	}
+
+	for _, prefix := range a.ReserveRange {
+		if !a.OverlayNet.Contains(prefix.Addr()) || prefix.Bits() < a.OverlayNet.Bits() {
+			return fmt.Errorf("reserved range %s is not part of the overlay network %s", prefix, a.OverlayNet)
+		}
+	}
+
+	for _, prefix := range a.ServiceRange {
+		if prefix.Overlaps(a.OverlayNet) {
+			return fmt.Errorf("service range %s overlaps the overlay network %s", prefix, a.OverlayNet)
+		}
+	}
+
+	wgAddr, _ := netip.ParseAddr(a.WireguardAddress)
+	for i, addr := range a.LocalServiceIP {
+		if !a.serviceAddrAllowed(addr) {
+			return fmt.Errorf("service address %s is neither part of the overlay network %s nor of a service range", addr, a.OverlayNet)
+		}
+		if addr == wgAddr {
+			return fmt.Errorf("service address %s is the node's own overlay address", addr)
+		}
+		for _, other := range a.LocalServiceIP[:i] {
+			if addr == other {
+				return fmt.Errorf("service address %s is given more than once", addr)
+			}
+		}
+	}
+
+	for _, pref := range a.AdvertisePrefer {
+		if _, err := netip.ParsePrefix(pref); err != nil && pref != "public" {
+			return fmt.Errorf("unsupported advertise preference %q; must be a CIDR or \"public\"", pref)
+		}
+	}
+
+	if a.IsolationWatchdog > 0 && a.ReachabilityCheckInterval <= 0 {
+		return fmt.Errorf("--isolation-watchdog requires a positive --reachability-check-interval")
+	}
+
+	if a.DiscoveryBackend != "" && a.DiscoveryURL == "" {
+		return fmt.Errorf("--discovery-backend requires --discovery-url")
+	}
+
+	if a.GossipBindAddr != "" {
+		addrPort, err := netip.ParseAddrPort(a.GossipBindAddr)
+		if err != nil {
+			return fmt.Errorf("unsupported gossip bind address %q: %w", a.GossipBindAddr, err)
+		}
+		a.gossipBindAddr = addrPort
+	}
+
+	if a.AccountingURL != "" && a.AccountingInterval <= 0 {
+		return fmt.Errorf("unsupported accounting interval %s; must be positive", a.AccountingInterval)
+	}
+	if err := wg.ValidateUsageLabels(a.AccountingLabels); err != nil {
+		return err
+	}
+
+	if a.WgMTUOverhead != "" {
+		if _, err := mtuOverhead(a.WgMTUOverhead, netip.Addr{}); err != nil {
+			return err
+		}
+	}
+
+	if a.WgPostPeerAdd != "" && a.WgPostPeerAddTimeout <= 0 {
+		return fmt.Errorf("unsupported wg-post-peer-add timeout %s; must be positive", a.WgPostPeerAddTimeout)
+	}
+
+	if a.ClusterSizeHint < 1 {
+		return fmt.Errorf("unsupported cluster size hint %d; must be positive", a.ClusterSizeHint)
+	}
+
+	if gossipPort := a.gossipPort(); gossipPort == a.WireguardPort {
+		return fmt.Errorf("gossip port %d must differ from wireguard port %d, since both use UDP", gossipPort, a.WireguardPort)
+	}
+
 	if a.BindAddr != "" && a.BindIface != "" {
 		return fmt.Errorf("setting both bind address and bind interface is not supported")
-	} else if a.BindIface != "" {
+	} else if a.BindAddr == "" {
+		bindAddr, err := a.detectBindAddr()
+		if err != nil {
+			return err
+		}
+		a.BindAddr = bindAddr
+		a.bindAddrDetected = true
+	}
+
+	return nil
+}
+
+func (a *AgentCmd) serviceAddrAllowed(addr netip.Addr) bool {
+	if a.OverlayNet.Contains(addr) {
+		return true
+	}
+	for _, prefix := range a.ServiceRange {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordWgSession makes wgstate record all calls to the kernel wireguard client to path, only readable by the owner.
+// The returned recording must be closed on shutdown.
+func recordWgSession(wgstate *wg.State, path string) (io.Closer, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("instantiating wireguard client: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("creating session file: %w", err)
+	}
+	recording := wg.NewRecordingClient(client, f)
+	wgstate.SetClient(recording)
+	return recording, nil
+}
+
+// dedupLogger returns a logger writing like std, but collapsing repeated identical entries within window.
+func dedupLogger(std *logrus.Logger, window time.Duration) *logrus.Logger {
+	logger := logrus.New()
+	logger.Out = std.Out
+	logger.Hooks = std.Hooks
+	logger.Level = std.GetLevel()
+	logger.ReportCaller = std.ReportCaller
+	logger.ExitFunc = std.ExitFunc
+	logger.Formatter = &common.DedupFormatter{Formatter: std.Formatter, Window: window, Out: std.Out}
+	return logger
+}
+
+// gossipPort returns the port used for cluster membership traffic.
+func (a *AgentCmd) gossipPort() int {
+	if a.gossipBindAddr.IsValid() {
+		return int(a.gossipBindAddr.Port())
+	}
+	return a.ClusterPort
+}
+
+// detectBindAddr computes the address to bind to, based on the provided bind interface or the available public IPs.
+func (a *AgentCmd) detectBindAddr() (string, error) {
+	if a.BindIface != "" {
 		// Compute the actual bind address based on the provided interface
 		var iface, err = net.InterfaceByName(a.BindIface)
 		if err != nil {
-			return fmt.Errorf("getting interface by name %s: %w", a.BindIface, err)
+			return "", fmt.Errorf("getting interface by name %s: %w", a.BindIface, err)
 		}
 		addrs, err := iface.Addrs()
 		if err != nil {
-			return fmt.Errorf("getting addresses for interface %s: %w", a.BindIface, err)
+			return "", fmt.Errorf("getting addresses for interface %s: %w", a.BindIface, err)
+		}
+		if len(a.AdvertisePrefer) > 0 {
+			if addr, ok := preferredAddr(addrs, a.AdvertisePrefer); ok {
+				return addr.String(), nil
+			}
 		}
 		if len(addrs) > 0 {
 			if addr, ok := addrs[0].(*net.IPNet); ok {
-				a.BindAddr = addr.IP.String()
+				return addr.IP.String(), nil
 			}
 		}
-	} else if a.BindAddr == "" && a.BindIface == "" {
-		// FIXME: this is a workaround for memberlist refusing to listen on public IPs if BindAddr==0.0.0.0
-		detectedBindAddr, err := sockaddr.GetPublicIP()
+		return "", nil
+	}
+
+	if len(a.AdvertisePrefer) > 0 {
+		addrs, err := net.InterfaceAddrs()
 		if err != nil {
-			return err
+			return "", fmt.Errorf("getting local addresses: %w", err)
 		}
-		// if we cannot find a public IP, let memberlist do its thing
-		if detectedBindAddr != "" {
-			a.BindAddr = detectedBindAddr
-		} else {
-			a.BindAddr = "0.0.0.0"
+		if addr, ok := preferredAddr(addrs, a.AdvertisePrefer); ok {
+			return addr.String(), nil
 		}
 	}
 
-	return nil
+	// FIXME: this is a workaround for memberlist refusing to listen on public IPs if BindAddr==0.0.0.0
+	detectedBindAddr, err := sockaddr.GetPublicIP()
+	if err != nil {
+		return "", err
+	}
+	// if we cannot find a public IP, let memberlist do its thing
+	if detectedBindAddr == "" {
+		return "0.0.0.0", nil
+	}
+	return detectedBindAddr, nil
 }
 
-func (a *AgentCmd) Run() error {
+// preferredAddr returns the address matching the first possible preference.
+// Preferences are either prefixes or "public", matching any globally routable non-private address.
+func preferredAddr(addrs []net.Addr, prefs []string) (netip.Addr, bool) {
+	for _, pref := range prefs {
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if !ok {
+				continue
+			}
+			addr = addr.Unmap()
+			if pref == "public" {
+				if addr.IsGlobalUnicast() && !addr.IsPrivate() {
+					return addr, true
+				}
+			} else if prefix, err := netip.ParsePrefix(pref); err == nil && prefix.Contains(addr) {
+				return addr, true
+			}
+		}
+	}
+	return netip.Addr{}, false
+}
+
+func (a *AgentCmd) Run(ktx *kong.Context) error {
+	if a.LogDedupWindow > 0 {
+		wg.Logger = dedupLogger(logrus.StandardLogger(), a.LogDedupWindow)
+	}
+	if err := a.checkOverlayOverlap(); err != nil {
+		logrus.WithError(err).Fatal("could not verify overlay network")
+	}
+
 	// Create the wireguard and cluster configuration
-	cluster, err := cluster.New(a.Interface, a.Init, a.ClusterKey.bytes, a.BindAddr, a.ClusterPort, a.UseIPAsName)
+	gossipAddr, gossipPort := a.BindAddr, a.gossipPort()
+	if a.gossipBindAddr.IsValid() {
+		gossipAddr = a.gossipBindAddr.Addr().String()
+	}
+	logrus.Infof("using port %d for gossip and port %d for wireguard", gossipPort, a.WireguardPort)
+	// without state file, no state is loaded either, as if (re)initializing the cluster
+	cluster, err := cluster.New(a.Interface, a.Init || a.NoStateFile, a.ClusterKey.bytes, gossipAddr, gossipPort, a.UseIPAsName, a.NormalizeName, cluster.ProxyProtocol{
+		Send:   a.ProxyProtocol,
+		Accept: a.ProxyProtocolAccept,
+	}, a.ClusterSizeHint)
 	if err != nil {
 		logrus.WithError(err).Fatal("could not create cluster")
 	}
-	wgstate, localNode, err := wg.New(a.Interface, a.WireguardPort, a.MTU, a.OverlayNet, cluster.LocalName, a.WireguardAddress)
+	cluster.NoStateFile = a.NoStateFile
+	if a.NoStateFile && len(a.ClusterKey.bytes) == 0 {
+		logrus.Warn("no cluster key provided and state persistence disabled; the generated key will be lost on restart")
+	}
+	if a.WgMTUOverhead != "" {
+		mtu, err := a.overlayMTU()
+		if err != nil {
+			logrus.WithError(err).Fatal("could not compute MTU")
+		}
+		logrus.Infof("using MTU %d", mtu)
+		a.MTU = mtu
+	}
+	reserved := a.Reserve
+	for _, prefix := range a.ReserveRange {
+		reserved = append(reserved, wg.PrefixRange(prefix))
+	}
+	wgstate, localNode, err := wg.New(a.Interface, a.WireguardPort, a.MTU, a.OverlayNet, cluster.LocalName, a.WireguardAddress, keyFilePath(a.KeyFile, a.Interface, a.NoStateFile), wg.AddrOptions{
+		Reserved:            reserved,
+		HashSeed:            a.HashSeed,
+		From:                a.AddressFrom,
+		Format:              a.overlayAddrFormat,
+		Infrastructure:      a.InfrastructureNodes,
+		InfrastructureRange: a.InfrastructureRange,
+	})
 	if err != nil {
 		logrus.WithError(err).Fatal("could not instantiate wireguard controller")
 	}
+	if a.RecordWgSession != "" {
+		recording, err := recordWgSession(wgstate, a.RecordWgSession)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not record wireguard session")
+		}
+		defer recording.Close()
+	}
+	if a.OnExit == onExitPreserve {
+		adopted, err := wgstate.AdoptDevice()
+		if err != nil {
+			logrus.WithError(err).Fatal("could not adopt existing wireguard interface")
+		}
+		if adopted {
+			logrus.Infof("adopted existing interface %s", a.Interface)
+			localNode.PubKey = wgstate.PubKey.String()
+			localNode.OverlayAddr = wgstate.OverlayAddr
+		}
+	}
+	wgstate.RouteTable = a.RouteTable
+	wgstate.RouteOrder = a.RouteOrder
+	a.peerConfigFlags.apply(wgstate)
+	localNode.PSKGroups = wgstate.PSKGroupNames()
+	wgstate.Alias = a.InterfaceAlias
+	wgstate.Group = a.InterfaceGroup
+	wgstate.TakeOver = a.TakeOver
+	wgstate.InterfaceUpTimeout = a.InterfaceUpTimeout
+	wgstate.NetlinkRetries = a.NetlinkRetries
+	wgstate.NetlinkRetryInterval = a.NetlinkRetryInterval
+	wgstate.PeerProbeTimeout = a.PeerProbeTimeout
+	wgstate.HandshakeTimeout = a.HandshakeTimeout
+	wgstate.SelfRoute = a.SelfRoute
+	if bindAddr, err := netip.ParseAddr(a.BindAddr); err == nil {
+		wgstate.BindAddr = bindAddr
+	}
+	if a.ConfigEventsURL != "" {
+		wgstate.OnConfigure = configEventPoster(a.ConfigEventsURL)
+	}
+	if a.WgPostPeerAdd != "" {
+		// do not hold up configuring the interface while the command runs
+		wgstate.OnPeerAdd = func(node common.Node, endpoint *net.UDPAddr) { go a.runPostPeerAdd(node, endpoint) }
+	}
+	localNode.Description = a.NodeDescription
+	localNode.FQDN = a.AdvertiseFQDN
+	localNode.HandshakeTimeout = a.AdvertiseHandshakeTimeout
+	localNode.ServiceAddrs = a.LocalServiceIP
+	for _, addr := range a.LocalServiceIP {
+		if addr == localNode.OverlayAddr {
+			logrus.Fatalf("service address %s is the node's own overlay address", addr)
+		}
+	}
+	localConfig := common.ClusterConfig{
+		Priority:                a.ClusterConfigPriority,
+		OverlayNet:              a.OverlayNet,
+		AllowedIPsPolicy:        wgstate.AllowedIPsPolicy,
+		HandshakeTimeout:        a.HandshakeTimeout,
+		EndpointStabilityWindow: a.EndpointStabilityWindow,
+	}
+	if a.BroadcastClusterConfig {
+		localNode.ClusterConfig = &localConfig
+	}
+	clusterConfig := newClusterConfigAdopter(ktx, localConfig, wgstate)
+	if a.gossipBindAddr.IsValid() {
+		// wireguard traffic uses the bind address, which differs from the gossip address peers see
+		if endpoint, err := netip.ParseAddr(a.BindAddr); err == nil && !endpoint.IsUnspecified() {
+			localNode.Endpoint = endpoint
+		}
+	}
+	gossipCodec := codec.None
+	if a.GossipCompression != gossipCompressionAuto {
+		if gossipCodec, err = codec.ByName(a.GossipCompression); err != nil {
+			logrus.WithError(err).Fatal("could not set up gossip compression")
+		}
+	}
+	localNode.SetCodec(gossipCodec)
+	localNode.Version = common.FormatVersion(version, wg.Implementation(a.Interface))
+	if _, err := localNode.EncodeMeta(memberlist.MetaMaxSize); err != nil {
+		logrus.Warn("cannot report the wesher version within the node metadata size limit")
+		localNode.Version = ""
+	}
+	// metadata exceeding the limit is not gossiped at all, which would silently remove this node from the cluster
+	if _, err := localNode.EncodeMeta(memberlist.MetaMaxSize); err != nil {
+		logrus.WithError(err).Fatal("node metadata too large; shorten --node-description, --advertise-fqdn or --psk-group names, reduce --local-service-ip or disable --broadcast-cluster-config")
+	}
 
 	// Prepare the /etc/hosts writer
 	hostsFile := &etchosts.EtcHosts{
@@ -99,6 +503,24 @@ func (a *AgentCmd) Run() error {
 		Logger: logrus.StandardLogger(),
 	}
 
+	var reservations *reservationsWriter
+	if a.ReservationsFile != "" {
+		reservations = &reservationsWriter{
+			path:   a.ReservationsFile,
+			format: a.ReservationsFormat,
+			prefix: a.OverlayNet.String(),
+		}
+	}
+
+	var discovery *discoveryRegistrar
+	if a.DiscoveryBackend != "" {
+		backend, err := newDiscoveryBackend(a.DiscoveryBackend, a.DiscoveryURL, a.DiscoveryPrefix)
+		if err != nil {
+			logrus.WithError(err).Fatal("could not set up service discovery")
+		}
+		discovery = newDiscoveryRegistrar(backend)
+	}
+
 	// Join the cluster
 	cluster.Update(localNode)
 
@@ -116,6 +538,69 @@ func (a *AgentCmd) Run() error {
 	ctx, cancelSignals := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
 	defer cancelSignals()
 
+	var reachabilityCheck <-chan time.Time
+	var watchdog *isolationWatchdog
+	if a.IsolationWatchdog > 0 {
+		watchdog = newIsolationWatchdog(a.IsolationWatchdog)
+	}
+	if a.ReachabilityCheckInterval > 0 {
+		ticker := time.NewTicker(a.ReachabilityCheckInterval)
+		defer ticker.Stop()
+		reachabilityCheck = ticker.C
+	}
+
+	// draining is triggered by SIGUSR1 and terminates after the grace period
+	drainc := make(chan os.Signal, 1)
+	signal.Notify(drainc, syscall.SIGUSR1)
+	// leaving immediately is triggered by "cluster leave"
+	leavec := make(chan os.Signal, 1)
+	signal.Notify(leavec, leaveSignal)
+	// reloading the MTU is triggered by SIGHUP
+	reloadc := make(chan os.Signal, 1)
+	signal.Notify(reloadc, syscall.SIGHUP)
+	pidFile := pidFilePath(a.PIDFile, a.Interface)
+	writePIDFile(pidFile)
+	var drained <-chan time.Time
+
+	var accounting <-chan time.Time
+	var postUsage func(interface{})
+	usage := &wg.UsageTracker{}
+	peerNames := make(map[string]string)
+	if a.AccountingURL != "" {
+		ticker := time.NewTicker(a.AccountingInterval)
+		defer ticker.Stop()
+		accounting = ticker.C
+		postUsage = jsonPoster(a.AccountingURL, "accounting report")
+	}
+
+	if a.InterfaceEventsURL != "" {
+		go a.watchInterface(ctx, jsonPoster(a.InterfaceEventsURL, "interface event"))
+	}
+
+	flaps := newFlapDamper(a.FlapWindow, a.FlapSuppressPenalty)
+	var notifyAfterFlap <-chan time.Time
+
+	// peers deferred by probing and held back endpoint changes are retried with the last known nodes
+	var peers []common.Node
+	var retryDeferred <-chan time.Time
+	// overlay addresses of the cluster members (without static peers), which reachability is reported for
+	var members []netip.Addr
+	// the cluster members of the last update without reachability reports, see withoutReports
+	var lastNodes []common.Node
+	// the cluster members of the last update, which static peers re-read on SIGHUP are added to
+	var clusterNodes []common.Node
+	// the cluster members of the last non-empty update, which the isolation watchdog rejoins through
+	var knownNodes []common.Node
+	var staticPeers *staticPeerSource
+	if a.StaticPeersFile != "" {
+		staticPeers = &staticPeerSource{path: a.StaticPeersFile}
+	}
+
+	endpointc := make(chan netip.Addr)
+	if a.bindAddrDetected {
+		go a.watchEndpoint(ctx, endpointc)
+	}
+
 	// Main loop
 	logrus.Debug("waiting for cluster events")
 	for {
@@ -123,50 +608,353 @@ func (a *AgentCmd) Run() error {
 		case rawNodes := <-nodec:
 			nodes := make([]common.Node, 0, len(rawNodes))
 			hosts := make(map[string][]string, len(rawNodes))
+			logrus.Debugf("%d superseded cluster updates skipped so far", cluster.DroppedUpdates())
 			logrus.Info("cluster members:\n")
 			for _, node := range rawNodes {
 				if err := node.DecodeMeta(); err != nil {
-					logrus.Warnf("\t addr: %s, could not decode metadata", node.Addr)
+					logrus.Warnf("\t addr: %s, could not decode metadata (node may use an unsupported --gossip-compression): %s", node.Addr, err)
 					continue
 				}
-				logrus.Infof("\taddr: %s, overlay: %s, pubkey: %s", node.Addr, node.OverlayAddr, node.PubKey)
+				logrus.Infof("\taddr: %s, overlay: %s, pubkey: %s, description: %q, version: %q", node.Addr, node.OverlayAddr, node.PubKey, node.Description, node.Version)
 				nodes = append(nodes, node)
 				hosts[node.OverlayAddr.String()] = []string{node.Name}
 			}
-			if err := wgstate.SetUpInterface(nodes); err != nil {
-				logrus.WithError(err).Error("could not up interface")
-				wgstate.DownInterface() // nolint: errcheck // opportunistic
+			// reachability reports change whenever peers come and go; reconfiguring for them alone would make every
+			// report trigger another round of reconfigurations across the cluster
+			configured := withoutReports(nodes)
+			if reflect.DeepEqual(configured, lastNodes) {
+				logrus.Debug("only reachability reports changed, skipping reconfiguration")
+				continue
+			}
+			lastNodes = configured
+			members = make([]netip.Addr, 0, len(nodes))
+			for _, node := range nodes {
+				members = append(members, node.OverlayAddr)
+			}
+			if negotiated := a.negotiateGossipCodec(gossipCodec, nodes); negotiated != gossipCodec {
+				if negotiated.ID() == codec.IDNone {
+					logrus.Info("not all members support compressed metadata, disabling gossip compression")
+				} else {
+					logrus.Info("all members support compressed metadata, enabling gossip compression")
+				}
+				gossipCodec = negotiated
+				localNode.SetCodec(gossipCodec)
+				cluster.Update(localNode)
+			}
+			// static peers are appended to a copy, so they can be replaced on SIGHUP
+			clusterNodes = nodes[:len(nodes):len(nodes)]
+			if len(clusterNodes) > 0 {
+				knownNodes = clusterNodes
 			}
+			if staticPeers != nil {
+				for _, node := range staticPeers.Nodes() {
+					logrus.Infof("\tstatic peer: %s, overlay: %s, pubkey: %s", node.Addr, node.OverlayAddr, node.PubKey)
+					nodes = append(nodes, node)
+					hosts[node.OverlayAddr.String()] = []string{node.Name}
+				}
+			}
+			peerNames = make(map[string]string, len(nodes))
+			for _, node := range nodes {
+				peerNames[node.PubKey] = node.Name
+			}
+			if changed, err := wgstate.ResolveConflicts(nodes); err != nil {
+				logrus.WithError(err).Error("could not resolve overlay address conflict")
+			} else if changed {
+				localNode.OverlayAddr = wgstate.OverlayAddr
+				cluster.Update(localNode)
+			}
+			clusterConfig.apply(common.SelectClusterConfig(append([]common.Node{*localNode}, nodes...)))
+			peers = nodes
+			retryDeferred = a.setUpInterface(wgstate, peers)
 			if !a.NoEtcHosts {
 				if err := hostsFile.WriteEntries(hosts); err != nil {
 					logrus.WithError(err).Error("could not write hosts entries")
 				}
 			}
-			if len(a.NodeUpdateScript) > 0 {
-				updateScript, _ := exec.LookPath(a.NodeUpdateScript)
-				cmd := &exec.Cmd{
-					Path:   updateScript,
-					Args:   []string{updateScript, a.Interface},
-					Stdout: os.Stdout,
-					Stderr: os.Stderr,
+			if reservations != nil {
+				if err := reservations.Write(append([]common.Node{*localNode}, nodes...)); err != nil {
+					logrus.WithError(err).Error("could not write reservations file")
 				}
-				if err := cmd.Run(); err != nil {
-					logrus.Errorf("error while executing node-update-script %s: %s", a.NodeUpdateScript, err)
+			}
+			if discovery != nil {
+				discovery.Sync(append([]common.Node{*localNode}, nodes...))
+			}
+			if suppressUntil := flaps.update(nodes, time.Now()); !suppressUntil.IsZero() {
+				// notify once the penalty is over, unless more flapping extends it
+				notifyAfterFlap = time.After(time.Until(suppressUntil))
+			} else {
+				notifyAfterFlap = nil
+				a.runNodeUpdateScript()
+			}
+		case <-notifyAfterFlap:
+			notifyAfterFlap = nil
+			a.runNodeUpdateScript()
+		case <-retryDeferred:
+			retryDeferred = a.setUpInterface(wgstate, peers)
+		case <-accounting:
+			device, err := wgstate.GetConfig()
+			if err != nil {
+				logrus.WithError(err).Error("could not get peer traffic")
+				continue
+			}
+			peerUsage := usage.Deltas(device.Peers)
+			for i := range peerUsage {
+				peerUsage[i].Name = peerNames[peerUsage[i].PubKey]
+			}
+			if a.AccountingAggregateAbove > 0 && len(peerUsage) > a.AccountingAggregateAbove {
+				postUsage(wg.GroupUsage(peerUsage, nil))
+			} else {
+				postUsage(wg.GroupUsage(peerUsage, a.AccountingLabels))
+			}
+		case <-reachabilityCheck:
+			a.checkOverlayAddr(cluster, wgstate)
+			if err := wgstate.RepairRoutes(); err != nil {
+				logrus.WithError(err).Error("could not verify peer routes")
+			}
+			if mismatches, err := wgstate.KeyMismatches(peers); err != nil {
+				logrus.WithError(err).Error("could not verify peer keys")
+			} else {
+				for _, m := range mismatches {
+					logrus.Warnf("key mismatch for %s: cluster announces %s, but %s is configured for %s", m.Node, m.ClusterKey, m.OverlayAddr, m.DeviceKey)
 				}
 			}
-		case <-ctx.Done():
-			cancelSignals()
-			logrus.Info("terminating...")
-			cluster.Leave()
-			if !a.NoEtcHosts {
-				if err := hostsFile.WriteEntries(map[string][]string{}); err != nil {
-					logrus.WithError(err).Error("could not remove stale hosts entries")
+			if health, err := wgstate.PeerHealth(peers); err != nil {
+				logrus.WithError(err).Error("could not check peer handshakes")
+			} else {
+				logPeerHealth(peers, health)
+			}
+			if summary, err := wgstate.Health(); err != nil {
+				logrus.WithError(err).Error("could not compute health score")
+			} else {
+				if summary.Score < a.HealthThreshold {
+					logrus.Warnf("health score %.2f below threshold %.2f: %d of %d peers had a recent handshake", summary.Score, a.HealthThreshold, summary.HealthyPeers, summary.TotalPeers)
 				}
+				if watchdog != nil {
+					switch watchdog.check(summary, time.Now()) {
+					case recoveryReconfigure:
+						retryDeferred = a.setUpInterface(wgstate, peers)
+					case recoveryRecreate:
+						if err := wgstate.DownInterface(); err != nil {
+							logrus.WithError(err).Error("could not delete interface for recreation")
+						}
+						retryDeferred = a.setUpInterface(wgstate, peers)
+					case recoveryRejoin:
+						if addrs := rejoinAddrs(a.Join, knownNodes); len(addrs) == 0 {
+							logrus.Warn("cannot rejoin cluster: no join addresses configured and no members known")
+						} else if err := cluster.Join(addrs); err != nil {
+							logrus.WithError(err).Error("could not rejoin cluster")
+						}
+					}
+				}
+			}
+			if reachable, err := wgstate.ReachableNodes(peers); err != nil {
+				logrus.WithError(err).Error("could not check peer reachability")
+			} else if announceReachable(localNode, members, reachable) {
+				cluster.Update(localNode)
 			}
-			if err := wgstate.DownInterface(); err != nil {
-				logrus.WithError(err).Error("could not down interface")
+		case endpoint := <-endpointc:
+			logrus.Infof("local address changed, announcing new endpoint %s", endpoint)
+			localNode.Endpoint = endpoint
+			cluster.Update(localNode)
+		case <-reloadc:
+			if err := a.reload(wgstate); err != nil {
+				logrus.WithError(err).Error("could not reload configuration")
+			}
+			if staticPeers != nil && staticPeers.refresh(true) && clusterNodes != nil {
+				logrus.Info("static peers changed, reconfiguring peers")
+				peers = append(clusterNodes, staticPeers.nodes...)
+				hosts := make(map[string][]string, len(peers))
+				for _, node := range peers {
+					peerNames[node.PubKey] = node.Name
+					hosts[node.OverlayAddr.String()] = []string{node.Name}
+				}
+				retryDeferred = a.setUpInterface(wgstate, peers)
+				if !a.NoEtcHosts {
+					if err := hostsFile.WriteEntries(hosts); err != nil {
+						logrus.WithError(err).Error("could not write hosts entries")
+					}
+				}
 			}
-			os.Exit(0)
+		case <-drainc:
+			logrus.Infof("draining, leaving cluster in %s", a.DrainGracePeriod)
+			localNode.Draining = true
+			cluster.Update(localNode)
+			drained = time.After(a.DrainGracePeriod)
+		case <-drained:
+			cancelSignals()
+			a.terminate(cluster, hostsFile, wgstate, false)
+			return nil
+		case <-leavec:
+			cancelSignals()
+			a.terminate(cluster, hostsFile, wgstate, false)
+			return nil
+		case <-ctx.Done():
+			cancelSignals()
+			a.terminate(cluster, hostsFile, wgstate, a.OnExit == onExitPreserve)
+			return nil
+		}
+	}
+}
+
+// runNodeUpdateScript notifies the node update script, if any, of a cluster change.
+func (a *AgentCmd) runNodeUpdateScript() {
+	if len(a.NodeUpdateScript) == 0 {
+		return
+	}
+	updateScript, _ := exec.LookPath(a.NodeUpdateScript)
+	cmd := &exec.Cmd{
+		Path:   updateScript,
+		Args:   []string{updateScript, a.Interface},
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	if err := cmd.Run(); err != nil {
+		logrus.Errorf("error while executing node-update-script %s: %s", a.NodeUpdateScript, err)
+	}
+}
+
+// runPostPeerAdd executes the post-peer-add command for a peer newly added to the interface, killing it if it runs
+// longer than the configured timeout.
+func (a *AgentCmd) runPostPeerAdd(node common.Node, endpoint *net.UDPAddr) {
+	var endpointStr string
+	if endpoint != nil {
+		endpointStr = endpoint.String()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), a.WgPostPeerAddTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", a.WgPostPeerAdd)
+	cmd.Env = append(os.Environ(),
+		"WESHER_PEER_PUBKEY="+node.PubKey,
+		"WESHER_PEER_OVERLAY_ADDR="+node.OverlayAddr.String(),
+		"WESHER_PEER_ENDPOINT="+endpointStr,
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); ctx.Err() == context.DeadlineExceeded {
+		logrus.WithField("peer", node.Name).Errorf("wg-post-peer-add command killed after %s", a.WgPostPeerAddTimeout)
+	} else if err != nil {
+		logrus.WithError(err).WithField("peer", node.Name).Error("error while executing wg-post-peer-add command")
+	}
+}
+
+// setUpInterface configures the interface for nodes, returning a channel firing when peers deferred by probing should
+// be retried, if any.
+func (a *AgentCmd) setUpInterface(wgstate *wg.State, nodes []common.Node) <-chan time.Time {
+	if err := wgstate.SetUpInterface(nodes); errors.Is(err, wg.ErrKernelModuleMissing) {
+		logrus.WithError(err).Fatal("could not up interface")
+	} else if errors.Is(err, wg.ErrForeignPeers) {
+		logrus.WithError(err).Error("refusing to configure interface; use --take-over to replace foreign peers")
+		return nil
+	} else if err != nil {
+		// cluster updates only reporting reachability do not reconfigure the interface, so retry on our own
+		logrus.WithError(err).Errorf("could not up interface, retrying in %s", deferredPeersRetryInterval)
+		wgstate.DownInterface() // nolint: errcheck // opportunistic
+		return time.After(deferredPeersRetryInterval)
+	}
+	var retry <-chan time.Time
+	if deferred := wgstate.DeferredPeers(); deferred > 0 {
+		logrus.Infof("%d unreachable peers deferred, retrying in %s", deferred, deferredPeersRetryInterval)
+		retry = time.After(deferredPeersRetryInterval)
+	}
+	// endpoint changes held back for stability are applied once stable, unless an earlier update applies them
+	if next := wgstate.NextEndpointUpdate(); !next.IsZero() && (retry == nil || time.Until(next) < deferredPeersRetryInterval) {
+		retry = time.After(time.Until(next))
+	}
+	return retry
+}
+
+// checkOverlayAddr logs an error, or terminates with --on-overlay-addr-mismatch=exit, if peers route to another overlay
+// address than the one the local interface uses.
+func (a *AgentCmd) checkOverlayAddr(c *cluster.Cluster, wgstate *wg.State) {
+	advertised, err := c.Advertised()
+	if err != nil {
+		logrus.WithError(err).Error("could not decode the advertised node metadata")
+		return
+	}
+	err = wgstate.CheckOverlayAddr(advertised.OverlayAddr)
+	switch {
+	case errors.Is(err, wg.ErrOverlayAddrMismatch) && a.OnOverlayAddrMismatch == "exit":
+		logrus.WithError(err).Fatal("peers cannot reach this node")
+	case errors.Is(err, wg.ErrOverlayAddrMismatch):
+		logrus.WithError(err).Error("peers cannot reach this node")
+	case err != nil:
+		logrus.WithError(err).Error("could not verify overlay address")
+	}
+}
+
+// logPeerHealth warns about peers without a recent handshake, distinguishing peers which never had one (usually a wrong
+// key or endpoint) from peers which had one in the past (usually a network problem).
+func logPeerHealth(nodes []common.Node, health map[string]wg.PeerHealth) {
+	for _, node := range nodes {
+		switch health[node.PubKey] {
+		case wg.PeerNeverHandshaked:
+			logrus.Warnf("no handshake with %s since it was configured; check its key and endpoint", node.Name)
+		case wg.PeerStale:
+			logrus.Warnf("no recent handshake with %s; check the network path to it", node.Name)
+		}
+	}
+}
+
+// negotiateGossipCodec returns the codec to compress the local metadata with in --gossip-compression=auto mode, given
+// the current cluster members. In other modes, current is kept, warning about members unable to decode it.
+func (a *AgentCmd) negotiateGossipCodec(current codec.Codec, nodes []common.Node) codec.Codec {
+	if a.GossipCompression == gossipCompressionAuto {
+		return common.NegotiateCodec(codec.Zstd, nodes)
+	}
+	if current.ID() == codec.IDNone {
+		return current
+	}
+	for _, node := range nodes {
+		if !node.HasCapability(common.CapCompression) {
+			logrus.Warnf("node %s may not support --gossip-compression=%s and fail to decode this node's metadata; consider --gossip-compression=auto", node.Name, a.GossipCompression)
 		}
 	}
+	return current
+}
+
+// announceReachable sets the reachable peers out of members reported by localNode, returning whether the report
+// changed. Since node metadata is limited in size, nothing is reported in clusters too large for the metadata to fit.
+func announceReachable(localNode *common.Node, members []netip.Addr, reachable []netip.Addr) bool {
+	previous := localNode.Reachable
+	localNode.Reachable = common.NewReachability(members, reachable)
+	if _, err := localNode.EncodeMeta(memberlist.MetaMaxSize); err != nil {
+		logrus.Warnf("cannot report reachability of %d peers within the node metadata size limit", len(members))
+		localNode.Reachable = common.Reachability{}
+	}
+	return !previous.Equal(localNode.Reachable)
+}
+
+// withoutReports returns a copy of nodes sorted by name, without the reachability reports and the raw metadata holding
+// them, which do not affect the local configuration.
+func withoutReports(nodes []common.Node) []common.Node {
+	stripped := make([]common.Node, len(nodes))
+	for i, node := range nodes {
+		node.Meta = nil
+		node.Reachable = common.Reachability{}
+		stripped[i] = node
+	}
+	sort.Slice(stripped, func(i, j int) bool { return stripped[i].Name < stripped[j].Name })
+	return stripped
+}
+
+// terminate leaves the cluster and cleans up all local changes, including removing the wireguard interface and
+// /etc/hosts entries. With preserveInterface, it only stops participating in the cluster without leaving it instead,
+// keeping the interface for the next start to adopt.
+func (a *AgentCmd) terminate(c *cluster.Cluster, hostsFile *etchosts.EtcHosts, wgstate *wg.State, preserveInterface bool) {
+	defer os.Remove(pidFilePath(a.PIDFile, a.Interface)) // nolint: errcheck // opportunistic
+	if preserveInterface {
+		logrus.Infof("terminating, keeping interface %s for restart...", a.Interface)
+		c.Shutdown()
+		return
+	}
+	logrus.Info("terminating...")
+	c.Leave()
+	if !a.NoEtcHosts {
+		if err := hostsFile.WriteEntries(map[string][]string{}); err != nil {
+			logrus.WithError(err).Error("could not remove stale hosts entries")
+		}
+	}
+	if err := wgstate.DownInterface(); err != nil {
+		logrus.WithError(err).Error("could not down interface")
+	}
 }
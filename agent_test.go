@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withoutReports(t *testing.T) {
+	a, b := common.Node{Name: "a", Meta: []byte("a")}, common.Node{Name: "b", Meta: []byte("b")}
+	a.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+	b.OverlayAddr = netip.MustParseAddr("10.0.0.2")
+	before := withoutReports([]common.Node{a, b})
+
+	a.Reachable = common.NewReachability([]netip.Addr{b.OverlayAddr}, []netip.Addr{b.OverlayAddr})
+	a.Meta = []byte("a with reachability")
+	assert.True(t, reflect.DeepEqual(before, withoutReports([]common.Node{b, a})), "reports and member order must not matter")
+
+	b.Endpoint = netip.MustParseAddr("192.0.2.2")
+	assert.False(t, reflect.DeepEqual(before, withoutReports([]common.Node{a, b})), "configuration changes must be detected")
+}
+
+func Test_AgentCmd_runPostPeerAdd(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	node := common.Node{Name: "node1"}
+	node.PubKey = "pubkey"
+	node.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+
+	a := &AgentCmd{WgPostPeerAdd: `echo "$WESHER_PEER_PUBKEY $WESHER_PEER_OVERLAY_ADDR $WESHER_PEER_ENDPOINT" > ` + out, WgPostPeerAddTimeout: time.Second}
+	a.runPostPeerAdd(node, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820})
+	written, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "pubkey 10.0.0.1 192.0.2.1:51820\n", string(written))
+
+	a = &AgentCmd{WgPostPeerAdd: "sleep 10", WgPostPeerAddTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	a.runPostPeerAdd(node, nil)
+	assert.Less(t, time.Since(start), 5*time.Second, "commands must be killed after the timeout")
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/alecthomas/kong"
+	"github.com/costela/wesher/awsparams"
+	"github.com/sirupsen/logrus"
+)
+
+// awsParamPrefix is a flag reading the values of all other options from the parameters below a prefix in the AWS
+// Parameter Store, named like the options (e.g. /wesher/prod/overlay-net). Options given on the command line take
+// precedence.
+type awsParamPrefix string
+
+// BeforeResolve adds the parameters as resolver, like kong.ConfigFlag does for configuration files.
+func (p awsParamPrefix) BeforeResolve(ctx *kong.Context, trace *kong.Path) error {
+	prefix := string(ctx.FlagValue(trace.Flag).(awsParamPrefix)) // nolint: forcetypeassert
+	var profile string
+	for _, flag := range ctx.Flags() {
+		if flag.Name == "aws-profile" {
+			profile, _ = ctx.FlagValue(flag).(string)
+		}
+	}
+	params, err := readAWSParams(prefix, profile)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("read %d parameters below %s from the AWS Parameter Store", len(params), prefix)
+	ctx.AddResolver(kong.ResolverFunc(func(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (interface{}, error) {
+		if value, ok := params[flag.Name]; ok {
+			return value, nil
+		}
+		return nil, nil
+	}))
+	return nil
+}
+
+func readAWSParams(prefix string, profile string) (map[string]string, error) {
+	client, err := awsparams.New(profile)
+	if err != nil {
+		return nil, err
+	}
+	return client.Parameters(prefix)
+}
+
+// reloadAWSParams overrides mtu with the mtu parameter below --aws-param-prefix, if set.
+func (a *AgentCmd) reloadAWSParams(mtu int) (int, error) {
+	params, err := readAWSParams(string(a.AWSParamPrefix), a.AWSProfile)
+	if err != nil {
+		return 0, err
+	}
+	raw, ok := params["mtu"]
+	if !ok {
+		return mtu, nil
+	}
+	if mtu, err = strconv.Atoi(raw); err != nil {
+		return 0, fmt.Errorf("unsupported value %q of parameter mtu", raw)
+	}
+	return mtu, nil
+}
@@ -0,0 +1,64 @@
+// Package awsparams reads parameters from the AWS Systems Manager Parameter Store.
+package awsparams
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// timeout bounds loading the configuration and reading all parameters.
+const timeout = 30 * time.Second
+
+// Client reads parameters from the Parameter Store of a single region.
+type Client struct {
+	api ssm.GetParametersByPathAPIClient
+}
+
+// New returns a client using the credentials and region found by the default AWS SDK chain (environment variables,
+// shared configuration files, EC2 instance role, etc.), with the given profile of the shared configuration files if
+// set. Without a configured region, the EC2 instance's region is used.
+func New(profile string) (*Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	opts := []func(*config.LoadOptions) error{config.WithEC2IMDSRegion()}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured")
+	}
+	return &Client{api: ssm.NewFromConfig(cfg)}, nil
+}
+
+// Parameters returns the values of all parameters directly below prefix, keyed by their name relative to prefix.
+// SecureString parameters are decrypted.
+func (c *Client) Parameters(prefix string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	prefix = "/" + strings.Trim(prefix, "/")
+	params := map[string]string{}
+	pages := ssm.NewGetParametersByPathPaginator(c.api, &ssm.GetParametersByPathInput{
+		Path:           aws.String(prefix),
+		WithDecryption: aws.Bool(true),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("getting parameters below %s: %w", prefix, err)
+		}
+		for _, p := range page.Parameters {
+			params[strings.TrimPrefix(aws.ToString(p.Name), prefix+"/")] = aws.ToString(p.Value)
+		}
+	}
+	return params, nil
+}
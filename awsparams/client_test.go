@@ -0,0 +1,53 @@
+package awsparams
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSSM serves GetParametersByPath pages keyed by their NextToken, the first one by "".
+type fakeSSM struct {
+	t     *testing.T
+	pages map[string]*ssm.GetParametersByPathOutput
+	err   error
+}
+
+func (f *fakeSSM) GetParametersByPath(_ context.Context, in *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	assert.Equal(f.t, "/wesher/prod", aws.ToString(in.Path))
+	assert.True(f.t, aws.ToBool(in.WithDecryption))
+	return f.pages[aws.ToString(in.NextToken)], nil
+}
+
+func Test_Client_Parameters(t *testing.T) {
+	api := &fakeSSM{t: t, pages: map[string]*ssm.GetParametersByPathOutput{
+		"": {
+			Parameters: []types.Parameter{{Name: aws.String("/wesher/prod/overlay-net"), Value: aws.String("10.10.0.0/16")}},
+			NextToken:  aws.String("page2"),
+		},
+		"page2": {
+			Parameters: []types.Parameter{{Name: aws.String("/wesher/prod/cluster-key"), Value: aws.String("secret")}},
+		},
+	}}
+
+	c := &Client{api: api}
+	params, err := c.Parameters("/wesher/prod/")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"overlay-net": "10.10.0.0/16", "cluster-key": "secret"}, params)
+}
+
+func Test_Client_Parameters_error(t *testing.T) {
+	c := &Client{api: &fakeSSM{t: t, err: errors.New("AccessDeniedException: not allowed")}}
+	_, err := c.Parameters("/wesher")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "AccessDeniedException: not allowed")
+}
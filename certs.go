@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+type ExportCertsCmd struct {
+	Out       string        `help:"directory to write cert.pem and key.pem to" default:"/etc/wesher/certs" type:"path"`
+	Interface string        `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	Validity  time.Duration `help:"validity of the generated certificate" default:"8760h"`
+}
+
+// Run derives an ECDSA key from the wireguard private key of the running interface and writes it along with a
+// self-signed certificate for the interface's overlay address.
+func (e *ExportCertsCmd) Run() error {
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("instantiating wireguard client: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(e.Interface)
+	if err != nil {
+		return fmt.Errorf("getting device %s: %w", e.Interface, err)
+	}
+	link, err := netlink.LinkByName(e.Interface)
+	if err != nil {
+		return fmt.Errorf("getting link for %s: %w", e.Interface, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("getting addresses for %s: %w", e.Interface, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no overlay address set on %s", e.Interface)
+	}
+
+	key := deriveECDSAKey(device.PrivateKey)
+	cert, err := selfSignedCert(key, device.PublicKey, addrs[0].IP, e.Validity)
+	if err != nil {
+		return err
+	}
+	return writeCerts(e.Out, key, cert)
+}
+
+// writeCerts writes the PEM-encoded certificate and private key to cert.pem and key.pem in dir.
+func writeCerts(dir string, key *ecdsa.PrivateKey, cert []byte) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("marshaling private key: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0o644); err != nil {
+		return fmt.Errorf("writing certificate: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+
+	return nil
+}
+
+// deriveECDSAKey deterministically derives a P-256 key from a wireguard (curve25519) private key.
+// The scalar is obtained by hashing the wireguard key with a domain separation prefix and mapping it into [1, N-1].
+func deriveECDSAKey(wgKey wgtypes.Key) *ecdsa.PrivateKey {
+	curve := elliptic.P256()
+	h := sha256.Sum256(append([]byte("wesher export-certs\x00"), wgKey[:]...))
+
+	one := big.NewInt(1)
+	d := new(big.Int).SetBytes(h[:])
+	d.Mod(d, new(big.Int).Sub(curve.Params().N, one))
+	d.Add(d, one)
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+
+	return key
+}
+
+func selfSignedCert(key *ecdsa.PrivateKey, pubKey wgtypes.Key, overlayIP net.IP, validity time.Duration) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: pubKey.String()},
+		NotBefore:    now,
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{overlayIP},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("creating certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_deriveECDSAKey(t *testing.T) {
+	wgKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	otherKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	key := deriveECDSAKey(wgKey)
+	assert.True(t, key.Curve.IsOnCurve(key.X, key.Y))
+	assert.True(t, key.Equal(deriveECDSAKey(wgKey)), "the derived key must be stable for the same wireguard key")
+	assert.False(t, key.Equal(deriveECDSAKey(otherKey)))
+}
+
+func Test_writeCerts_load(t *testing.T) {
+	wgKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	overlayIP := net.ParseIP("10.0.0.1")
+
+	key := deriveECDSAKey(wgKey)
+	der, err := selfSignedCert(key, wgKey.PublicKey(), overlayIP, time.Hour)
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "certs")
+	require.NoError(t, writeCerts(dir, key, der))
+
+	info, err := os.Stat(filepath.Join(dir, "key.pem"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, wgKey.PublicKey().String(), cert.Subject.CommonName)
+	require.Len(t, cert.IPAddresses, 1)
+	assert.True(t, overlayIP.Equal(cert.IPAddresses[0]))
+	assert.NoError(t, cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature), "the certificate must be self-signed")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+}
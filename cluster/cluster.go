@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
+	"log"
 	"os"
 	"time"
 
@@ -23,13 +24,17 @@ type Cluster struct {
 	mlConfig  *memberlist.Config
 	localNode *common.Node
 	LocalName string
-	state     *state
-	events    chan memberlist.NodeEvent
+	// NoStateFile disables persisting the cluster state, e.g. on read-only filesystems. Without it, restarted nodes
+	// can only rejoin via explicitly provided addresses and cluster key.
+	NoStateFile bool
+	state       *state
+	events      chan memberlist.NodeEvent
+	updates     *common.NodeQueue
 }
 
 // New is used to create a new Cluster instance
 // The returned instance is ready to be updated with the local node settings then joined
-func New(name string, init bool, clusterKey []byte, bindAddr string, bindPort int, useIPAsName bool) (*Cluster, error) {
+func New(name string, init bool, clusterKey []byte, bindAddr string, bindPort int, useIPAsName bool, normalizeName bool, proxyProtocol ProxyProtocol, sizeHint int) (*Cluster, error) {
 	state := &state{}
 	if !init {
 		loadState(state, name)
@@ -41,6 +46,7 @@ func New(name string, init bool, clusterKey []byte, bindAddr string, bindPort in
 	}
 
 	mlConfig := memberlist.DefaultWANConfig()
+	scaleGossip(mlConfig, sizeHint)
 	mlConfig.LogOutput = logrus.StandardLogger().WriterLevel(logrus.DebugLevel)
 	mlConfig.SecretKey = clusterKey
 	mlConfig.BindAddr = bindAddr
@@ -48,6 +54,20 @@ func New(name string, init bool, clusterKey []byte, bindAddr string, bindPort in
 	mlConfig.AdvertisePort = bindPort
 	if useIPAsName && bindAddr != "0.0.0.0" {
 		mlConfig.Name = bindAddr
+	} else if normalizeName {
+		mlConfig.Name = common.NormalizeName(mlConfig.Name)
+	}
+
+	if proxyProtocol.Send || proxyProtocol.Accept {
+		nt, err := memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+			BindAddrs: []string{bindAddr},
+			BindPort:  bindPort,
+			Logger:    log.New(mlConfig.LogOutput, "", log.LstdFlags),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating gossip transport: %w", err)
+		}
+		mlConfig.Transport = newProxyTransport(nt, proxyProtocol)
 	}
 
 	ml, err := memberlist.Create(mlConfig)
@@ -62,8 +82,9 @@ func New(name string, init bool, clusterKey []byte, bindAddr string, bindPort in
 		LocalName: ml.LocalNode().Name,
 		// The big channel buffer is a work-around for https://github.com/hashicorp/memberlist/issues/23
 		// More than this many simultaneous events will deadlock cluster.members()
-		events: make(chan memberlist.NodeEvent, 100),
-		state:  state,
+		events:  make(chan memberlist.NodeEvent, eventBuffer(sizeHint)),
+		state:   state,
+		updates: common.NewNodeQueue(),
 	}
 
 	return &cluster, nil
@@ -97,11 +118,27 @@ func (c *Cluster) Join(addrs []string) error {
 
 // Leave saves the current state before leaving, then leaves the cluster
 func (c *Cluster) Leave() {
-	c.state.save(c.name) // nolint: errcheck // opportunistic
+	c.saveState()
 	c.ml.Leave(10 * time.Second)
 	c.ml.Shutdown() // nolint: errcheck
 }
 
+// Shutdown saves the current state, then stops participating in the cluster without leaving it.
+// Other nodes will consider this node failed after a while, unless it comes back in the meantime, e.g. after a restart.
+func (c *Cluster) Shutdown() {
+	c.saveState()
+	c.ml.Shutdown() // nolint: errcheck
+}
+
+func (c *Cluster) saveState() {
+	if c.NoStateFile {
+		return
+	}
+	local := c.ml.LocalNode()
+	c.state.LocalNode = &common.Node{Name: local.Name, Addr: local.Addr, Meta: local.Meta}
+	c.state.save(c.name) // nolint: errcheck // opportunistic
+}
+
 // Update gossips the local node configuration, propagating any change
 func (c *Cluster) Update(localNode *common.Node) {
 	c.localNode = localNode
@@ -113,11 +150,21 @@ func (c *Cluster) Update(localNode *common.Node) {
 	c.ml.UpdateNode(1 * time.Second) // nolint: errcheck // we currently do not update after creation
 }
 
+// Advertised returns the local node as currently gossiped to other members. It differs from the node passed to Update
+// if memberlist has not picked up the latest changes.
+func (c *Cluster) Advertised() (common.Node, error) {
+	n := c.ml.LocalNode()
+	node := common.Node{Name: n.Name, Addr: n.Addr, Meta: n.Meta}
+	return node, node.DecodeMeta()
+}
+
 // Members provides a channel notifying of cluster changes
 // Everytime a change happens inside the cluster (except for local changes),
 // the updated list of cluster nodes is pushed to the channel.
+// Gossip is never blocked by slow receivers: lists not received before the next change are dropped in favor of the
+// newer one.
 func (c *Cluster) Members() <-chan []common.Node {
-	changes := make(chan []common.Node)
+	changes := c.updates
 
 	go func() {
 		for event := range c.events {
@@ -146,12 +193,17 @@ func (c *Cluster) Members() <-chan []common.Node {
 				})
 			}
 			c.state.Nodes = nodes
-			changes <- nodes
-			c.state.save(c.name) // nolint: errcheck // opportunistic
+			changes.Push(nodes)
+			c.saveState()
 		}
 	}()
 
-	return changes
+	return changes.C()
+}
+
+// DroppedUpdates returns the number of member lists dropped because they were superseded before being received.
+func (c *Cluster) DroppedUpdates() uint64 {
+	return c.updates.Dropped()
 }
 
 func computeClusterKey(state *state, clusterKey []byte) ([]byte, error) {
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"net"
+	"time"
+
+	"github.com/costela/wesher/proxy"
+	"github.com/hashicorp/memberlist"
+	"github.com/sirupsen/logrus"
+)
+
+// proxyHeaderTimeout is the maximum time to wait for the PROXY protocol header of incoming gossip connections.
+const proxyHeaderTimeout = 10 * time.Second
+
+// ProxyProtocol selects the use of PROXY protocol headers on gossip TCP connections.
+type ProxyProtocol struct {
+	// Send prefixes outgoing connections with a header conveying their real source address.
+	Send bool
+	// Accept reads the header of incoming connections, if present, using the conveyed address as remote address.
+	Accept bool
+}
+
+// proxyTransport wraps the gossip TCP connections of a memberlist.NetTransport in the PROXY protocol.
+type proxyTransport struct {
+	*memberlist.NetTransport
+	proxyProtocol ProxyProtocol
+	streams       chan net.Conn
+}
+
+func newProxyTransport(nt *memberlist.NetTransport, proxyProtocol ProxyProtocol) *proxyTransport {
+	t := &proxyTransport{
+		NetTransport:  nt,
+		proxyProtocol: proxyProtocol,
+		streams:       make(chan net.Conn),
+	}
+	go t.acceptStreams()
+	return t
+}
+
+// DialTimeout implements memberlist.Transport.
+func (t *proxyTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.NetTransport.DialTimeout(addr, timeout)
+	if err != nil || !t.proxyProtocol.Send {
+		return conn, err
+	}
+	if err := proxy.WriteHeader(conn, conn.LocalAddr(), conn.RemoteAddr()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DialAddressTimeout implements memberlist.NodeAwareTransport.
+func (t *proxyTransport) DialAddressTimeout(addr memberlist.Address, timeout time.Duration) (net.Conn, error) {
+	return t.DialTimeout(addr.Addr, timeout)
+}
+
+// StreamCh implements memberlist.Transport.
+func (t *proxyTransport) StreamCh() <-chan net.Conn {
+	return t.streams
+}
+
+func (t *proxyTransport) acceptStreams() {
+	for conn := range t.NetTransport.StreamCh() {
+		if !t.proxyProtocol.Accept {
+			t.streams <- conn
+			continue
+		}
+		go func(conn net.Conn) {
+			proxied, err := proxy.Accept(conn, proxyHeaderTimeout)
+			if err != nil {
+				logrus.WithError(err).Warnf("dropping gossip connection from %s", conn.RemoteAddr())
+				conn.Close()
+				return
+			}
+			t.streams <- proxied
+		}(conn)
+	}
+}
@@ -0,0 +1,47 @@
+package cluster
+
+import (
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestProxyTransport(t *testing.T, proxyProtocol ProxyProtocol) *proxyTransport {
+	nt, err := memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+		BindAddrs: []string{"127.0.0.1"},
+		Logger:    log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { nt.Shutdown() }) // nolint: errcheck // test cleanup
+	return newProxyTransport(nt, proxyProtocol)
+}
+
+func Test_proxyTransport(t *testing.T) {
+	sender := newTestProxyTransport(t, ProxyProtocol{Send: true})
+	receiver := newTestProxyTransport(t, ProxyProtocol{Accept: true})
+
+	conn, err := sender.DialTimeout(net.JoinHostPort("127.0.0.1", strconv.Itoa(receiver.GetAutoBindPort())), time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("gossip"))
+	require.NoError(t, err)
+
+	select {
+	case stream := <-receiver.StreamCh():
+		defer stream.Close()
+		assert.Equal(t, conn.LocalAddr().String(), stream.RemoteAddr().String())
+		buf := make([]byte, 6)
+		_, err := io.ReadFull(stream, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "gossip", string(buf), "the header must be stripped")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no stream received")
+	}
+}
@@ -0,0 +1,28 @@
+package cluster
+
+import "github.com/hashicorp/memberlist"
+
+// DefaultSizeHint is the cluster size memberlist's default gossip parameters are used for.
+const DefaultSizeHint = 10
+
+// minEventBuffer is the membership event buffer used for small clusters.
+const minEventBuffer = 100
+
+// scaleGossip raises memberlist's GossipNodes and RetransmitMult by one for each order of magnitude the expected
+// cluster size exceeds DefaultSizeHint by. Both grow logarithmically, like the number of gossip rounds memberlist
+// needs to reach all nodes.
+func scaleGossip(config *memberlist.Config, sizeHint int) {
+	for n := sizeHint; n >= DefaultSizeHint*10; n /= 10 {
+		config.GossipNodes++
+		config.RetransmitMult++
+	}
+}
+
+// eventBuffer returns the capacity of the membership event channel for the expected cluster size, so that the join
+// events of all nodes during bootstrap can be buffered without blocking gossip.
+func eventBuffer(sizeHint int) int {
+	if sizeHint > minEventBuffer {
+		return sizeHint
+	}
+	return minEventBuffer
+}
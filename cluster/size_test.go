@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_scaleGossip(t *testing.T) {
+	defaults := memberlist.DefaultWANConfig()
+	for _, tt := range []struct {
+		sizeHint int
+		increase int
+	}{
+		{0, 0},
+		{DefaultSizeHint, 0},
+		{99, 0},
+		{100, 1},
+		{1000, 2},
+		{5000, 2},
+		{10000, 3},
+	} {
+		t.Run(fmt.Sprint(tt.sizeHint), func(t *testing.T) {
+			config := memberlist.DefaultWANConfig()
+			scaleGossip(config, tt.sizeHint)
+			assert.Equal(t, defaults.GossipNodes+tt.increase, config.GossipNodes)
+			assert.Equal(t, defaults.RetransmitMult+tt.increase, config.RetransmitMult)
+		})
+	}
+}
+
+func Test_eventBuffer(t *testing.T) {
+	assert.Equal(t, minEventBuffer, eventBuffer(DefaultSizeHint))
+	assert.Equal(t, 2000, eventBuffer(2000))
+}
+
+// BenchmarkBootstrapEvents measures how long gossip is blocked delivering the join events of a 1000 node cluster
+// bootstrap to a slower consumer, which happens whenever the event buffer is full.
+func BenchmarkBootstrapEvents(b *testing.B) {
+	const nodes = 1000
+	for _, sizeHint := range []int{DefaultSizeHint, nodes} {
+		b.Run(fmt.Sprintf("hint=%d", sizeHint), func(b *testing.B) {
+			b.ReportAllocs()
+			members := make([]*memberlist.Node, nodes)
+			for i := range members {
+				members[i] = &memberlist.Node{Name: fmt.Sprint(i)}
+			}
+			for i := 0; i < b.N; i++ {
+				events := make(chan memberlist.NodeEvent, eventBuffer(sizeHint))
+				delegate := &memberlist.ChannelEventDelegate{Ch: events}
+				done := make(chan struct{})
+				go func() {
+					for range events {
+						time.Sleep(time.Microsecond) // e.g. reconfiguring wireguard
+					}
+					close(done)
+				}()
+				for _, member := range members {
+					delegate.NotifyJoin(member)
+				}
+				b.StopTimer()
+				close(events)
+				<-done
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"syscall"
 
 	"github.com/costela/wesher/common"
 	"github.com/sirupsen/logrus"
@@ -15,12 +16,17 @@ import (
 type state struct {
 	ClusterKey []byte
 	Nodes      []common.Node
+	// LocalNode is the local node as last gossiped, for commands inspecting the running agent; its metadata is not
+	// decoded
+	LocalNode *common.Node `json:",omitempty"`
 }
 
 var statePathTemplate = "/var/lib/wesher/%s.json"
 
 const deprecatedStatePath = "/var/lib/wesher/state.json"
 
+// save writes the state to a temporary file, which then atomically replaces the state file, so that a crash never
+// leaves a partially written state behind. Readers are additionally excluded while writing via lockState.
 func (s *state) save(clusterName string) error {
 	statePath := fmt.Sprintf(statePathTemplate, clusterName)
 	if err := os.MkdirAll(path.Dir(statePath), 0700); err != nil {
@@ -32,11 +38,59 @@ func (s *state) save(clusterName string) error {
 		return err
 	}
 
-	return ioutil.WriteFile(statePath, stateOut, 0600)
+	unlock, err := lockState(statePath, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tmp, err := os.CreateTemp(path.Dir(statePath), "wesher-state-*.json.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck // fails after successful renames
+	if _, err := tmp.Write(stateOut); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), statePath)
+}
+
+// lockState acquires a flock(2) lock of the given type on the lock file accompanying the state file. The state file
+// itself cannot be locked, since it is replaced on every write. Only exclusive locks create the lock file, so readers
+// never write to the state directory. The returned function releases the lock.
+func lockState(statePath string, how int) (func(), error) {
+	flag := os.O_RDONLY
+	if how == syscall.LOCK_EX {
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(statePath+".lock", flag, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening state lock: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking state: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN) // nolint: errcheck // released on close anyway
+		f.Close()
+	}, nil
 }
 
 func loadState(cs *state, clusterName string) {
 	statePath := fmt.Sprintf(statePathTemplate, clusterName)
+	// reading without lock is still safe thanks to atomic writes, e.g. if no state was saved yet
+	if unlock, err := lockState(statePath, syscall.LOCK_SH); err == nil {
+		defer unlock()
+	}
 	content, err := ioutil.ReadFile(statePath)
 	if err != nil {
 		// try the deprecated pre 0.3 state path, it will later
@@ -61,3 +115,23 @@ func loadState(cs *state, clusterName string) {
 		*cs = *csTmp
 	}
 }
+
+// KnownNodes returns the cluster nodes as last seen by an agent for the given cluster, read from its state file.
+// The nodes' metadata is not decoded.
+func KnownNodes(clusterName string) []common.Node {
+	s := &state{}
+	loadState(s, clusterName)
+	return s.Nodes
+}
+
+// KnownLocalNode returns the local node as last gossiped by an agent for the given cluster, read from its state file.
+// It returns false if the state holds no local node, e.g. because it was saved by an older version.
+// The node's metadata is not decoded.
+func KnownLocalNode(clusterName string) (common.Node, bool) {
+	s := &state{}
+	loadState(s, clusterName)
+	if s.LocalNode == nil {
+		return common.Node{}, false
+	}
+	return *s.LocalNode, true
+}
@@ -1,11 +1,17 @@
 package cluster
 
 import (
+	"fmt"
 	"net"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_state_save_soad(t *testing.T) {
@@ -20,6 +26,7 @@ func Test_state_save_soad(t *testing.T) {
 		state: &state{
 			ClusterKey: []byte(key),
 			Nodes:      []common.Node{node},
+			LocalNode:  &common.Node{Name: "local", Addr: net.ParseIP("10.0.0.1"), Meta: []byte("meta")},
 		},
 	}
 
@@ -30,6 +37,60 @@ func Test_state_save_soad(t *testing.T) {
 	loadState(loaded, "test")
 
 	if !reflect.DeepEqual(cluster.state, loaded) {
-		t.Errorf("cluster state save then reload mistmatch: %s / %s", cluster.state, loaded)
+		t.Errorf("cluster state save then reload mistmatch: %v / %v", cluster.state, loaded)
 	}
+	if local, ok := KnownLocalNode("test"); !ok || local.Name != "local" {
+		t.Errorf("local node not loaded: %v", local)
+	}
+}
+
+func Test_state_save_atomic(t *testing.T) {
+	dir := t.TempDir()
+	defaultTemplate := statePathTemplate
+	t.Cleanup(func() { statePathTemplate = defaultTemplate })
+	statePathTemplate = dir + "/%s.json"
+	small := &state{ClusterKey: []byte("small")}
+	large := &state{ClusterKey: []byte("large")}
+	for i := 0; i < 100; i++ {
+		large.Nodes = append(large.Nodes, common.Node{Name: fmt.Sprintf("node%d", i), Addr: net.ParseIP("10.0.0.2")})
+	}
+	require.NoError(t, small.save("test"))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			s := small
+			if i%2 == 0 {
+				s = large
+			}
+			assert.NoError(t, s.save("test"))
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		loaded := &state{}
+		loadState(loaded, "test")
+		assert.Contains(t, []string{"small", "large"}, string(loaded.ClusterKey), "must never read a partial state")
+	}
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasSuffix(entry.Name(), ".tmp"), "temporary file %s left behind", entry.Name())
+	}
+}
+
+func Test_loadState_readOnly(t *testing.T) {
+	dir := t.TempDir()
+	defaultTemplate := statePathTemplate
+	t.Cleanup(func() { statePathTemplate = defaultTemplate })
+	statePathTemplate = dir + "/%s.json"
+
+	loadState(&state{}, "test")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "loading must not create any files")
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/sirupsen/logrus"
+)
+
+// clusterConfigAdopter applies the settings broadcast as cluster config to the local wireguard state.
+// Settings explicitly set locally (by flag or environment variable) take precedence over broadcast ones.
+type clusterConfigAdopter struct {
+	explicit   map[string]bool
+	overlayNet netip.Prefix
+	// local holds the local settings, used for settings not broadcast
+	local   common.ClusterConfig
+	wgstate *wg.State
+}
+
+func newClusterConfigAdopter(ktx *kong.Context, local common.ClusterConfig, wgstate *wg.State) *clusterConfigAdopter {
+	explicit := make(map[string]bool)
+	for _, name := range []string{"allowed-ips-policy", "handshake-timeout", "endpoint-stability-window"} {
+		explicit[name] = flagSet(ktx, name)
+	}
+	return &clusterConfigAdopter{explicit: explicit, overlayNet: local.OverlayNet, local: local, wgstate: wgstate}
+}
+
+// apply sets the wireguard state's settings from cfg, as broadcast by source, falling back to local settings.
+func (c *clusterConfigAdopter) apply(cfg *common.ClusterConfig, source string) {
+	wgstate := c.wgstate
+	if cfg == nil {
+		cfg = &common.ClusterConfig{}
+	} else if cfg.OverlayNet.IsValid() && cfg.OverlayNet != c.overlayNet {
+		logrus.Warnf("overlay network %s differs from %s in cluster config of %s", c.overlayNet, cfg.OverlayNet, source)
+	}
+
+	policy := c.local.AllowedIPsPolicy
+	if cfg.AllowedIPsPolicy != "" && !c.explicit["allowed-ips-policy"] {
+		policy = cfg.AllowedIPsPolicy
+	}
+	if policy != wgstate.AllowedIPsPolicy {
+		logAdoption("allowed IPs policy", policy, policy == cfg.AllowedIPsPolicy, source)
+		wgstate.AllowedIPsPolicy = policy
+	}
+
+	wgstate.HandshakeTimeout = c.adoptDuration("handshake timeout", "handshake-timeout", c.local.HandshakeTimeout, cfg.HandshakeTimeout, wgstate.HandshakeTimeout, source)
+	wgstate.EndpointStabilityWindow = c.adoptDuration("endpoint stability window", "endpoint-stability-window", c.local.EndpointStabilityWindow, cfg.EndpointStabilityWindow, wgstate.EndpointStabilityWindow, source)
+}
+
+func (c *clusterConfigAdopter) adoptDuration(desc, flag string, local, broadcast, current time.Duration, source string) time.Duration {
+	value := local
+	if broadcast != 0 && !c.explicit[flag] {
+		value = broadcast
+	}
+	if value != current {
+		logAdoption(desc, value.String(), value == broadcast, source)
+	}
+	return value
+}
+
+func logAdoption(desc, value string, broadcast bool, source string) {
+	if broadcast {
+		logrus.Infof("adopting %s %s from cluster config of %s", desc, value, source)
+	} else {
+		logrus.Infof("using local %s %s", desc, value)
+	}
+}
+
+// flagSet returns whether the named flag was explicitly set, either on the command line or through its environment
+// variable.
+func flagSet(ktx *kong.Context, name string) bool {
+	for _, p := range ktx.Path {
+		if p.Flag != nil && p.Flag.Name == name {
+			return true
+		}
+	}
+	for _, f := range ktx.Flags() {
+		if f.Name == name && f.Tag.Env != "" {
+			return os.Getenv(f.Tag.Env) != ""
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_clusterConfigAdopter_apply(t *testing.T) {
+	local := common.ClusterConfig{
+		OverlayNet:              netip.MustParsePrefix("10.0.0.0/8"),
+		AllowedIPsPolicy:        wg.AllowedIPsOverlayOnly,
+		HandshakeTimeout:        3 * time.Minute,
+		EndpointStabilityWindow: time.Minute,
+	}
+	broadcast := common.ClusterConfig{
+		OverlayNet:              netip.MustParsePrefix("10.0.0.0/8"),
+		AllowedIPsPolicy:        wg.AllowedIPsPrivateRanges,
+		HandshakeTimeout:        5 * time.Minute,
+		EndpointStabilityWindow: 2 * time.Minute,
+	}
+
+	tests := []struct {
+		name       string
+		explicit   map[string]bool
+		cfg        *common.ClusterConfig
+		wantPolicy string
+		wantHS     time.Duration
+		wantESW    time.Duration
+	}{
+		{
+			name:       "no cluster config",
+			cfg:        nil,
+			wantPolicy: wg.AllowedIPsOverlayOnly,
+			wantHS:     3 * time.Minute,
+			wantESW:    time.Minute,
+		},
+		{
+			name:       "broadcast settings adopted",
+			cfg:        &broadcast,
+			wantPolicy: wg.AllowedIPsPrivateRanges,
+			wantHS:     5 * time.Minute,
+			wantESW:    2 * time.Minute,
+		},
+		{
+			name:       "explicit local settings take precedence",
+			explicit:   map[string]bool{"allowed-ips-policy": true, "handshake-timeout": true, "endpoint-stability-window": true},
+			cfg:        &broadcast,
+			wantPolicy: wg.AllowedIPsOverlayOnly,
+			wantHS:     3 * time.Minute,
+			wantESW:    time.Minute,
+		},
+		{
+			name:       "partially explicit",
+			explicit:   map[string]bool{"handshake-timeout": true},
+			cfg:        &broadcast,
+			wantPolicy: wg.AllowedIPsPrivateRanges,
+			wantHS:     3 * time.Minute,
+			wantESW:    2 * time.Minute,
+		},
+		{
+			name:       "unset broadcast settings fall back to local",
+			cfg:        &common.ClusterConfig{HandshakeTimeout: 4 * time.Minute},
+			wantPolicy: wg.AllowedIPsOverlayOnly,
+			wantHS:     4 * time.Minute,
+			wantESW:    time.Minute,
+		},
+		{
+			name:       "differing overlay network is only warned about",
+			cfg:        &common.ClusterConfig{OverlayNet: netip.MustParsePrefix("192.168.0.0/16"), AllowedIPsPolicy: wg.AllowedIPsFullTunnel},
+			wantPolicy: wg.AllowedIPsFullTunnel,
+			wantHS:     3 * time.Minute,
+			wantESW:    time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			explicit := tt.explicit
+			if explicit == nil {
+				explicit = map[string]bool{}
+			}
+			wgstate := &wg.State{}
+			c := &clusterConfigAdopter{explicit: explicit, overlayNet: local.OverlayNet, local: local, wgstate: wgstate}
+
+			c.apply(tt.cfg, "node1")
+
+			assert.Equal(t, tt.wantPolicy, wgstate.AllowedIPsPolicy)
+			assert.Equal(t, tt.wantHS, wgstate.HandshakeTimeout)
+			assert.Equal(t, tt.wantESW, wgstate.EndpointStabilityWindow)
+		})
+	}
+}
+
+func Test_clusterConfigAdopter_apply_revert(t *testing.T) {
+	local := common.ClusterConfig{AllowedIPsPolicy: wg.AllowedIPsOverlayOnly, HandshakeTimeout: 3 * time.Minute}
+	wgstate := &wg.State{}
+	c := &clusterConfigAdopter{explicit: map[string]bool{}, local: local, wgstate: wgstate}
+
+	c.apply(&common.ClusterConfig{AllowedIPsPolicy: wg.AllowedIPsFullTunnel, HandshakeTimeout: time.Minute}, "node1")
+	assert.Equal(t, wg.AllowedIPsFullTunnel, wgstate.AllowedIPsPolicy)
+	assert.Equal(t, time.Minute, wgstate.HandshakeTimeout)
+
+	// once the broadcasting node goes away, local settings are restored
+	c.apply(nil, "")
+	assert.Equal(t, wg.AllowedIPsOverlayOnly, wgstate.AllowedIPsPolicy)
+	assert.Equal(t, 3*time.Minute, wgstate.HandshakeTimeout)
+}
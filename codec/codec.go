@@ -0,0 +1,107 @@
+// Package codec implements the compression schemes supported for gossiped node metadata.
+package codec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxDecodedLen is the maximum size of decompressed payloads, to limit the impact of malicious peers.
+const MaxDecodedLen = 64 * 1024
+
+// ID identifies a codec in the header of encoded payloads.
+type ID byte
+
+// Known codec identifiers. The values are part of the wire format and must never change.
+const (
+	IDNone   ID = 0
+	IDSnappy ID = 1
+	IDZstd   ID = 2
+)
+
+// Codec compresses and decompresses payloads.
+type Codec interface {
+	ID() ID
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+// ByName returns the codec registered under the provided name.
+func ByName(name string) (Codec, error) {
+	switch name {
+	case "", "none":
+		return None, nil
+	case "snappy":
+		return Snappy, nil
+	case "zstd":
+		return Zstd, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// ByID returns the codec identified by id.
+func ByID(id ID) (Codec, error) {
+	switch id {
+	case IDNone:
+		return None, nil
+	case IDSnappy:
+		return Snappy, nil
+	case IDZstd:
+		return Zstd, nil
+	}
+	return nil, fmt.Errorf("unknown codec id %d", id)
+}
+
+// None passes payloads through unmodified.
+var None Codec = noneCodec{}
+
+type noneCodec struct{}
+
+func (noneCodec) ID() ID                               { return IDNone }
+func (noneCodec) Compress(in []byte) ([]byte, error)   { return in, nil }
+func (noneCodec) Decompress(in []byte) ([]byte, error) { return in, nil }
+
+// Snappy compresses payloads using the snappy block format.
+var Snappy Codec = snappyCodec{}
+
+type snappyCodec struct{}
+
+func (snappyCodec) ID() ID { return IDSnappy }
+
+func (snappyCodec) Compress(in []byte) ([]byte, error) {
+	return snappy.Encode(nil, in), nil
+}
+
+func (snappyCodec) Decompress(in []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(in)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxDecodedLen {
+		return nil, errors.New("decoded payload too large")
+	}
+	return snappy.Decode(nil, in)
+}
+
+// Zstd compresses payloads using zstandard.
+var Zstd Codec = zstdCodec{}
+
+type zstdCodec struct{}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecodedLen))
+)
+
+func (zstdCodec) ID() ID { return IDZstd }
+
+func (zstdCodec) Compress(in []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(in, nil), nil
+}
+
+func (zstdCodec) Decompress(in []byte) ([]byte, error) {
+	return zstdDecoder.DecodeAll(in, nil)
+}
@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Codec_roundtrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("some metadata "), 50)
+	for _, name := range []string{"none", "snappy", "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			c, err := ByName(name)
+			require.NoError(t, err)
+
+			compressed, err := c.Compress(payload)
+			require.NoError(t, err)
+			if name != "none" {
+				assert.Less(t, len(compressed), len(payload))
+			}
+
+			byID, err := ByID(c.ID())
+			require.NoError(t, err)
+			decompressed, err := byID.Decompress(compressed)
+			require.NoError(t, err)
+			assert.Equal(t, payload, decompressed)
+		})
+	}
+}
+
+func Test_Codec_unknown(t *testing.T) {
+	_, err := ByName("foo")
+	assert.Error(t, err)
+	_, err = ByID(42)
+	assert.Error(t, err)
+}
+
+func Test_Codec_decoded_limit(t *testing.T) {
+	payload := make([]byte, MaxDecodedLen+1)
+	for _, c := range []Codec{Snappy, Zstd} {
+		compressed, err := c.Compress(payload)
+		require.NoError(t, err)
+		_, err = c.Decompress(compressed)
+		assert.Error(t, err)
+	}
+}
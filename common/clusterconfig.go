@@ -0,0 +1,101 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+// ClusterConfig holds settings a node broadcasts for all cluster members to adopt, so they can be changed in a single
+// place. Zero values are not adopted.
+type ClusterConfig struct {
+	// Priority decides between conflicting broadcasts: the highest priority wins, ties are won by the lowest node name.
+	Priority int
+	// OverlayNet is not adopted, since it cannot change at runtime; nodes only warn if theirs differs.
+	OverlayNet              netip.Prefix
+	AllowedIPsPolicy        string
+	HandshakeTimeout        time.Duration
+	EndpointStabilityWindow time.Duration
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, for a compact representation in the size-limited node metadata.
+func (c *ClusterConfig) MarshalBinary() ([]byte, error) {
+	prefix, err := c.OverlayNet.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 4*binary.MaxVarintLen64+len(prefix)+len(c.AllowedIPsPolicy))
+	tmp := make([]byte, binary.MaxVarintLen64)
+	appendVarint := func(v int64) {
+		buf = append(buf, tmp[:binary.PutVarint(tmp, v)]...)
+	}
+	appendBytes := func(b []byte) {
+		buf = append(buf, tmp[:binary.PutUvarint(tmp, uint64(len(b)))]...)
+		buf = append(buf, b...)
+	}
+	appendVarint(int64(c.Priority))
+	appendBytes(prefix)
+	appendBytes([]byte(c.AllowedIPsPolicy))
+	appendVarint(int64(c.HandshakeTimeout))
+	appendVarint(int64(c.EndpointStabilityWindow))
+	return buf, nil
+}
+
+var errInvalidClusterConfig = errors.New("invalid cluster config encoding")
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (c *ClusterConfig) UnmarshalBinary(data []byte) error {
+	varint := func() int64 {
+		v, n := binary.Varint(data)
+		if n <= 0 {
+			data = nil
+			return 0
+		}
+		data = data[n:]
+		return v
+	}
+	bytes := func() []byte {
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			data = nil
+			return nil
+		}
+		b := data[n : n+int(l)]
+		data = data[n+int(l):]
+		return b
+	}
+
+	cfg := ClusterConfig{Priority: int(varint())}
+	if err := cfg.OverlayNet.UnmarshalBinary(bytes()); err != nil {
+		return err
+	}
+	cfg.AllowedIPsPolicy = string(bytes())
+	cfg.HandshakeTimeout = time.Duration(varint())
+	if data == nil {
+		return errInvalidClusterConfig
+	}
+	cfg.EndpointStabilityWindow = time.Duration(varint())
+	if data == nil {
+		return errInvalidClusterConfig
+	}
+	*c = cfg
+	return nil
+}
+
+// SelectClusterConfig returns the cluster config broadcast by the given nodes which takes precedence, along with the
+// name of the node broadcasting it; the config is nil if no node broadcasts one.
+func SelectClusterConfig(nodes []Node) (*ClusterConfig, string) {
+	var selected *ClusterConfig
+	var source string
+	for i := range nodes {
+		cfg := nodes[i].ClusterConfig
+		if cfg == nil {
+			continue
+		}
+		if selected == nil || cfg.Priority > selected.Priority || (cfg.Priority == selected.Priority && nodes[i].Name < source) {
+			selected, source = cfg, nodes[i].Name
+		}
+	}
+	return selected, source
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectClusterConfig(t *testing.T) {
+	nodes := []Node{{Name: "a"}, {Name: "c"}, {Name: "b"}, {Name: "d"}}
+	nodes[1].ClusterConfig = &ClusterConfig{Priority: 10, AllowedIPsPolicy: "overlay-only"}
+	nodes[2].ClusterConfig = &ClusterConfig{Priority: 10, AllowedIPsPolicy: "full-tunnel"}
+	nodes[3].ClusterConfig = &ClusterConfig{Priority: 1}
+
+	cfg, source := SelectClusterConfig(nodes)
+	assert.Equal(t, "b", source, "ties must be won by the lowest name")
+	assert.Equal(t, "full-tunnel", cfg.AllowedIPsPolicy)
+
+	cfg, source = SelectClusterConfig(nodes[:1])
+	assert.Nil(t, cfg)
+	assert.Empty(t, source)
+}
+
+func TestClusterConfig_Binary(t *testing.T) {
+	cfg := &ClusterConfig{
+		Priority:                -3,
+		OverlayNet:              netip.MustParsePrefix("10.0.0.0/8"),
+		AllowedIPsPolicy:        "overlay-only",
+		HandshakeTimeout:        time.Minute,
+		EndpointStabilityWindow: time.Second,
+	}
+	encoded, err := cfg.MarshalBinary()
+	require.NoError(t, err)
+
+	decoded := &ClusterConfig{}
+	require.NoError(t, decoded.UnmarshalBinary(encoded))
+	assert.Equal(t, cfg, decoded)
+
+	assert.Error(t, decoded.UnmarshalBinary(encoded[:len(encoded)-1]))
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DedupFormatter wraps a logrus.Formatter, collapsing identical entries (same level, message and fields) logged within
+// Window of a previous one, so storms of repeated events do not flood the log. The number of suppressed entries is
+// added as "repeated" field to the next identical entry logged after the window, or written to Out once the window
+// expires without one.
+type DedupFormatter struct {
+	logrus.Formatter
+	Window time.Duration
+	// Out receives the last suppressed entry of each expired window; if nil, counts not reported by a later entry are
+	// dropped.
+	Out io.Writer
+
+	mu   sync.Mutex
+	seen map[string]*dedupState
+}
+
+type dedupState struct {
+	logged     time.Time
+	suppressed int
+	last       *logrus.Entry
+	flush      *time.Timer
+}
+
+// Format implements logrus.Formatter; suppressed entries are formatted to nothing.
+func (f *DedupFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	key := fmt.Sprintf("%s|%s|%v", entry.Level, entry.Message, entry.Data)
+
+	f.mu.Lock()
+	if f.seen == nil {
+		f.seen = make(map[string]*dedupState)
+	}
+	state, ok := f.seen[key]
+	if ok && entry.Time.Sub(state.logged) < f.Window {
+		state.suppressed++
+		state.last = withRepeated(entry, 0)
+		if state.flush == nil {
+			state.flush = time.AfterFunc(time.Until(state.logged.Add(f.Window)), func() { f.flush(key, state) })
+		}
+		f.mu.Unlock()
+		return nil, nil
+	}
+	suppressed := 0
+	if ok {
+		suppressed = state.suppressed
+		if state.flush != nil {
+			state.flush.Stop()
+		}
+	}
+	f.seen[key] = &dedupState{logged: entry.Time}
+	for k, s := range f.seen {
+		if s.suppressed == 0 && entry.Time.Sub(s.logged) >= f.Window {
+			delete(f.seen, k)
+		}
+	}
+	f.mu.Unlock()
+
+	if suppressed > 0 {
+		entry = withRepeated(entry, suppressed)
+	}
+	return f.Formatter.Format(entry)
+}
+
+// flush forgets the entry with the given key once its window expired, writing the number of entries suppressed within
+// it to Out. It does nothing if the entry was logged again in the meantime, since that reports the count itself.
+func (f *DedupFormatter) flush(key string, state *dedupState) {
+	f.mu.Lock()
+	if f.seen[key] != state {
+		f.mu.Unlock()
+		return
+	}
+	delete(f.seen, key)
+	f.mu.Unlock()
+
+	if f.Out == nil {
+		return
+	}
+	out, err := f.Formatter.Format(withRepeated(state.last, state.suppressed))
+	if err != nil {
+		return
+	}
+	f.Out.Write(out) // nolint: errcheck // nowhere to report it
+}
+
+// withRepeated returns a copy of entry, with its number of repetitions as "repeated" field if non-zero.
+func withRepeated(entry *logrus.Entry, repeated int) *logrus.Entry {
+	dup := *entry
+	dup.Data = make(logrus.Fields, len(entry.Data)+1)
+	for k, v := range entry.Data {
+		dup.Data[k] = v
+	}
+	if repeated > 0 {
+		dup.Data["repeated"] = repeated
+	}
+	return &dup
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupFormatter(t *testing.T) {
+	f := &DedupFormatter{Formatter: &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}, Window: time.Minute}
+	now := time.Now()
+	format := func(msg string, at time.Time) string {
+		out, err := f.Format(&logrus.Entry{Message: msg, Level: logrus.InfoLevel, Time: at, Data: logrus.Fields{}})
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	assert.Equal(t, "level=info msg=a\n", format("a", now))
+	assert.Empty(t, format("a", now.Add(time.Second)))
+	assert.Empty(t, format("a", now.Add(2*time.Second)))
+	assert.Equal(t, "level=info msg=b\n", format("b", now.Add(2*time.Second)), "other messages must not be suppressed")
+	assert.Equal(t, "level=info msg=a repeated=2\n", format("a", now.Add(time.Minute)))
+	assert.Equal(t, "level=info msg=a\n", format("a", now.Add(3*time.Minute)))
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDedupFormatter_flush(t *testing.T) {
+	out := &syncBuffer{}
+	f := &DedupFormatter{Formatter: &logrus.TextFormatter{DisableColors: true, DisableTimestamp: true}, Window: 50 * time.Millisecond, Out: out}
+	format := func(msg string) string {
+		out, err := f.Format(&logrus.Entry{Message: msg, Level: logrus.InfoLevel, Time: time.Now(), Data: logrus.Fields{}})
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	assert.Equal(t, "level=info msg=a\n", format("a"))
+	assert.Empty(t, format("a"))
+	assert.Empty(t, format("a"))
+	assert.Equal(t, "level=info msg=b\n", format("b"))
+
+	assert.Eventually(t, func() bool { return out.String() == "level=info msg=a repeated=2\n" }, time.Second, 10*time.Millisecond,
+		"suppressed count must be reported once the window expires")
+	f.mu.Lock()
+	assert.NotContains(t, f.seen, "info|a|map[]", "flushed entries must be forgotten")
+	f.mu.Unlock()
+	assert.Equal(t, "level=info msg=a\n", format("a"), "entries after a flush must not repeat the count")
+}
@@ -0,0 +1,18 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NormalizeName returns the canonical form of a node name, so cosmetic variations of the same hostname (casing, a
+// trailing dot or surrounding whitespace) map to the same node identity and overlay address.
+func NormalizeName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, name)
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeName(t *testing.T) {
+	for _, name := range []string{"web01", "Web01", "WEB01.", " web01\n"} {
+		assert.Equal(t, "web01", NormalizeName(name), name)
+	}
+	assert.Equal(t, "web01.example.com", NormalizeName("Web01.Example.com."))
+}
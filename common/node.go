@@ -6,12 +6,71 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"time"
+
+	"github.com/costela/wesher/codec"
 )
 
+// metaHeaderMagic starts compressed metadata, followed by the codec.ID.
+// A gob stream never starts with a zero byte, so this can be distinguished from uncompressed metadata.
+const metaHeaderMagic = 0x00
+
+// Capabilities are features a node supports, advertised so peers running different versions only use common features.
+// Nodes not advertising any capabilities predate capability negotiation.
+const (
+	// CapPSK marks support for per-peer preshared keys.
+	CapPSK uint64 = 1 << iota
+	// CapIPv6Overlay marks support for routing IPv6 through the overlay.
+	CapIPv6Overlay
+	// CapReachability marks nodes reporting the peers they can reach, see nodeMeta.Reachable.
+	CapReachability
+	// CapCompression marks support for decoding metadata compressed with any codec.Codec.
+	CapCompression
+)
+
+// SupportedCapabilities are the capabilities of the running version.
+const SupportedCapabilities = CapPSK | CapIPv6Overlay | CapReachability | CapCompression
+
 // nodeMeta holds metadata sent over the cluster
 type nodeMeta struct {
 	OverlayAddr netip.Addr
 	PubKey      string
+	// Description is a purely informational free-text description of the node
+	Description string
+	// Endpoint is the address peers should use for wireguard traffic; if not set, the gossip address is used
+	Endpoint netip.Addr
+	// FQDN is a DNS name resolving to the address peers should use for wireguard traffic; it takes precedence over
+	// Endpoint, e.g. for nodes frequently changing addresses
+	FQDN string
+	// Draining marks nodes about to leave the cluster, which should no longer be used to route other traffic
+	Draining bool
+	// PSKGroups holds the names of the preshared key groups the node is a member of; the keys are never gossiped
+	PSKGroups []string
+	// Capabilities is a bitmask of the Cap* features supported by the node
+	Capabilities uint64
+	// Reachable reports the cluster members the node had a recent handshake with at its last reachability check
+	Reachable Reachability
+	// HandshakeTimeout is the maximum age of the latest handshake with the node for peers to consider it reachable;
+	// if zero, peers use their own default
+	HandshakeTimeout time.Duration
+	// ServiceAddrs holds additional addresses the node serves, which peers route to it along with its OverlayAddr
+	ServiceAddrs []netip.Addr
+	// Version describes the wesher version and wireguard implementation the node runs, see FormatVersion; it is purely
+	// informational
+	Version string
+	// ClusterConfig holds settings broadcast by the node for all cluster members to adopt, if any
+	ClusterConfig *ClusterConfig
+}
+
+// HasCapability returns whether the node advertises all capabilities in cap.
+func (n *Node) HasCapability(cap uint64) bool {
+	return n.Capabilities&cap == cap
+}
+
+// FormatVersion returns the version string advertised by nodes running the given wesher version on top of the given
+// wireguard implementation.
+func FormatVersion(wesherVersion string, wireguard string) string {
+	return fmt.Sprintf("%s (wireguard: %s)", wesherVersion, wireguard)
 }
 
 // Node holds the memberlist node structure
@@ -20,6 +79,25 @@ type Node struct {
 	Addr net.IP
 	Meta []byte
 	nodeMeta
+
+	codec codec.Codec
+}
+
+// SetCodec sets the codec used to compress the encoded metadata. Uncompressed metadata (the default) can be decoded by
+// all wesher versions, while compressed metadata can only be decoded by versions supporting the codec.
+func (n *Node) SetCodec(c codec.Codec) {
+	n.codec = c
+}
+
+// NegotiateCodec returns preferred if all nodes can decode metadata compressed with it, or codec.None otherwise, so
+// mixed clusters keep exchanging metadata all members understand.
+func NegotiateCodec(preferred codec.Codec, nodes []Node) codec.Codec {
+	for _, node := range nodes {
+		if !node.HasCapability(CapCompression) {
+			return codec.None
+		}
+	}
+	return preferred
 }
 
 func (n *Node) String() string {
@@ -32,18 +110,36 @@ func (n *Node) EncodeMeta(limit int) ([]byte, error) {
 	if err := gob.NewEncoder(buf).Encode(n.nodeMeta); err != nil {
 		return nil, fmt.Errorf("encoding local state: %w", err)
 	}
-	if buf.Len() > limit {
+	encoded := buf.Bytes()
+	if n.codec != nil && n.codec.ID() != codec.IDNone {
+		compressed, err := n.codec.Compress(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("compressing local state: %w", err)
+		}
+		encoded = append([]byte{metaHeaderMagic, byte(n.codec.ID())}, compressed...)
+	}
+	if len(encoded) > limit {
 		return nil, fmt.Errorf("could not fit node metadata into %d bytes", limit)
 	}
-	return buf.Bytes(), nil
+	return encoded, nil
 }
 
 // DecodeMeta decodes the node Meta field into its individual metadata fields.
 func (n *Node) DecodeMeta() error {
 	// TODO: we blindly trust the info we get from the peers; We should be more defensive to limit the damage a leaked
 	// PSK can cause.
+	meta := n.Meta
+	if len(meta) >= 2 && meta[0] == metaHeaderMagic {
+		c, err := codec.ByID(codec.ID(meta[1]))
+		if err != nil {
+			return fmt.Errorf("decoding node meta: %w", err)
+		}
+		if meta, err = c.Decompress(meta[2:]); err != nil {
+			return fmt.Errorf("decompressing node meta: %w", err)
+		}
+	}
 	nm := nodeMeta{}
-	if err := gob.NewDecoder(bytes.NewReader(n.Meta)).Decode(&nm); err != nil {
+	if err := gob.NewDecoder(bytes.NewReader(meta)).Decode(&nm); err != nil {
 		return fmt.Errorf("decoding node meta: %w", err)
 	}
 	n.nodeMeta = nm
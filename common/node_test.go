@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/costela/wesher/codec"
 	"github.com/stretchr/testify/require"
 )
 
@@ -16,8 +17,10 @@ func Test_Node_Encode_Decode(t *testing.T) {
 	for _, ip := range []netip.Addr{ipv4, ipv6} {
 		node := Node{
 			nodeMeta: nodeMeta{
-				OverlayAddr: ip,
-				PubKey:      pubKey,
+				OverlayAddr:  ip,
+				PubKey:       pubKey,
+				Description:  "some description",
+				Capabilities: CapPSK | CapIPv6Overlay,
 			},
 		}
 		encoded, _ := node.EncodeMeta(1024)
@@ -27,7 +30,57 @@ func Test_Node_Encode_Decode(t *testing.T) {
 		require.NoError(t, err)
 
 		if !reflect.DeepEqual(node.nodeMeta, new.nodeMeta) {
-			t.Errorf("node encoding then decoding mismatch: %s / %s", node.nodeMeta, new.nodeMeta)
+			t.Errorf("node encoding then decoding mismatch: %v / %v", node.nodeMeta, new.nodeMeta)
 		}
 	}
 }
+
+func Test_Node_Encode_Decode_compressed(t *testing.T) {
+	for _, c := range []codec.Codec{codec.None, codec.Snappy, codec.Zstd} {
+		node := Node{
+			nodeMeta: nodeMeta{
+				OverlayAddr: netip.MustParseAddr("10.0.0.1"),
+				PubKey:      "abcdefghijklmnopkqstuvwxyzABCDEF",
+				Description: "some description",
+			},
+		}
+		node.SetCodec(c)
+		encoded, err := node.EncodeMeta(1024)
+		require.NoError(t, err)
+
+		// decoding must not depend on the local codec
+		new := Node{Meta: encoded}
+		require.NoError(t, new.DecodeMeta())
+		require.Equal(t, node.nodeMeta, new.nodeMeta)
+	}
+}
+
+func Test_Node_HasCapability(t *testing.T) {
+	node := Node{nodeMeta: nodeMeta{Capabilities: CapIPv6Overlay}}
+
+	require.True(t, node.HasCapability(CapIPv6Overlay))
+	require.False(t, node.HasCapability(CapPSK))
+	require.False(t, node.HasCapability(CapPSK|CapIPv6Overlay))
+	require.False(t, (&Node{}).HasCapability(CapIPv6Overlay))
+}
+
+func Test_FormatVersion(t *testing.T) {
+	require.Equal(t, "v0.4.2 (wireguard: kernel 6.1.0)", FormatVersion("v0.4.2", "kernel 6.1.0"))
+
+	// the version must survive gossip along with the remaining metadata
+	node := Node{nodeMeta: nodeMeta{PubKey: "abcdefghijklmnopkqstuvwxyzABCDEF", Version: FormatVersion("dev", "userspace")}}
+	encoded, err := node.EncodeMeta(1024)
+	require.NoError(t, err)
+	new := Node{Meta: encoded}
+	require.NoError(t, new.DecodeMeta())
+	require.Equal(t, "dev (wireguard: userspace)", new.Version)
+}
+
+func Test_NegotiateCodec(t *testing.T) {
+	capable := Node{nodeMeta: nodeMeta{Capabilities: SupportedCapabilities}}
+	legacy := Node{nodeMeta: nodeMeta{Capabilities: CapPSK}}
+
+	require.Equal(t, codec.Zstd, NegotiateCodec(codec.Zstd, nil))
+	require.Equal(t, codec.Zstd, NegotiateCodec(codec.Zstd, []Node{capable, capable}))
+	require.Equal(t, codec.None, NegotiateCodec(codec.Zstd, []Node{capable, legacy}), "nodes unable to decode compressed metadata must prevent compression")
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"sync/atomic"
+)
+
+// NodeQueue passes node lists from a producer to a consumer without blocking the producer.
+// It holds at most one pending list: pushing while a list is pending replaces it, since only the latest state is
+// relevant.
+type NodeQueue struct {
+	updates chan []Node
+	dropped uint64
+}
+
+// NewNodeQueue creates an empty NodeQueue.
+func NewNodeQueue() *NodeQueue {
+	return &NodeQueue{updates: make(chan []Node, 1)}
+}
+
+// Push enqueues nodes, replacing any pending list not yet received.
+// It is safe to call from a single producer concurrently with receiving from C.
+func (q *NodeQueue) Push(nodes []Node) {
+	for {
+		select {
+		case q.updates <- nodes:
+			return
+		default:
+		}
+		select {
+		case <-q.updates:
+			atomic.AddUint64(&q.dropped, 1)
+		default:
+		}
+	}
+}
+
+// C returns the channel the latest node lists are received from.
+func (q *NodeQueue) C() <-chan []Node {
+	return q.updates
+}
+
+// Dropped returns the number of lists replaced before being received.
+func (q *NodeQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
@@ -0,0 +1,24 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NodeQueue_latest_wins(t *testing.T) {
+	q := NewNodeQueue()
+
+	q.Push([]Node{{Name: "first"}})
+	q.Push([]Node{{Name: "second"}})
+	q.Push([]Node{{Name: "third"}})
+
+	assert.Equal(t, []Node{{Name: "third"}}, <-q.C())
+	assert.Equal(t, uint64(2), q.Dropped())
+
+	select {
+	case nodes := <-q.C():
+		t.Errorf("unexpected pending update: %v", nodes)
+	default:
+	}
+}
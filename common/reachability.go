@@ -0,0 +1,101 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"net/netip"
+	"sort"
+)
+
+// Reachability reports which cluster members a node reaches, as one bit per member in the order of their overlay
+// addresses. This keeps reports of large clusters within the limited node metadata, but the bits can only be read
+// with the same member list they were created for; a checksum of that list is included to tell.
+type Reachability struct {
+	// Members is a checksum of the overlay addresses of the members the report was created for
+	Members uint32
+	// Bits holds the bit of the n-th member (in address order) in the (n%8)-th lowest bit of its (n/8)-th byte
+	Bits []byte
+}
+
+// NewReachability reports the reachable addresses out of members, which must be the overlay addresses of all other
+// cluster members. Reachable addresses not in members are ignored.
+func NewReachability(members []netip.Addr, reachable []netip.Addr) Reachability {
+	members = sortedAddrs(members)
+	isReachable := make(map[netip.Addr]bool, len(reachable))
+	for _, addr := range reachable {
+		isReachable[addr] = true
+	}
+
+	r := Reachability{Members: addrsChecksum(members), Bits: make([]byte, (len(members)+7)/8)}
+	for i, addr := range members {
+		if isReachable[addr] {
+			r.Bits[i/8] |= 1 << (i % 8)
+		}
+	}
+	return r
+}
+
+// Reachable returns the reported addresses out of members, the overlay addresses of all cluster members besides the
+// reporting node as known locally. If members differ from those the report was created for, e.g. while a membership
+// change propagates, it returns false.
+func (r Reachability) Reachable(members []netip.Addr) ([]netip.Addr, bool) {
+	members = sortedAddrs(members)
+	if r.Members != addrsChecksum(members) || len(r.Bits) != (len(members)+7)/8 {
+		return nil, false
+	}
+
+	var reachable []netip.Addr
+	for i, addr := range members {
+		if r.Bits[i/8]&(1<<(i%8)) != 0 {
+			reachable = append(reachable, addr)
+		}
+	}
+	return reachable, true
+}
+
+// GobEncode implements gob.GobEncoder, saving the field descriptions otherwise sent along in every metadata update.
+func (r Reachability) GobEncode() ([]byte, error) {
+	buf := make([]byte, 4, 4+len(r.Bits))
+	binary.BigEndian.PutUint32(buf, r.Members)
+	return append(buf, r.Bits...), nil
+}
+
+// GobDecode implements gob.GobDecoder.
+func (r *Reachability) GobDecode(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("invalid reachability encoding")
+	}
+	r.Members = binary.BigEndian.Uint32(data)
+	r.Bits = append([]byte(nil), data[4:]...)
+	return nil
+}
+
+// Equal returns whether r and other report the same.
+func (r Reachability) Equal(other Reachability) bool {
+	return r.Members == other.Members && bytes.Equal(r.Bits, other.Bits)
+}
+
+// sortedAddrs returns a sorted copy of addrs without duplicates.
+func sortedAddrs(addrs []netip.Addr) []netip.Addr {
+	sorted := make([]netip.Addr, 0, len(addrs))
+	seen := make(map[netip.Addr]bool, len(addrs))
+	for _, addr := range addrs {
+		if !seen[addr] {
+			seen[addr] = true
+			sorted = append(sorted, addr)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Less(sorted[j]) })
+	return sorted
+}
+
+func addrsChecksum(sorted []netip.Addr) uint32 {
+	h := fnv.New32a()
+	for _, addr := range sorted {
+		b := addr.As16()
+		h.Write(b[:]) // nolint: errcheck // never fails
+	}
+	return h.Sum32()
+}
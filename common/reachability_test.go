@@ -0,0 +1,56 @@
+package common
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Reachability(t *testing.T) {
+	a, b, c := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"), netip.MustParseAddr("10.0.0.3")
+
+	r := NewReachability([]netip.Addr{c, a, b}, []netip.Addr{c, a, netip.MustParseAddr("192.0.2.1")})
+
+	// the member order does not matter
+	reachable, ok := r.Reachable([]netip.Addr{a, b, c})
+	require.True(t, ok)
+	assert.Equal(t, []netip.Addr{a, c}, reachable)
+
+	_, ok = r.Reachable([]netip.Addr{a, b})
+	assert.False(t, ok, "reports must not be read with a different member list")
+	_, ok = Reachability{}.Reachable(nil)
+	assert.False(t, ok, "missing reports must not be mistaken for reports of empty clusters")
+}
+
+// Reachability reports must fit into memberlist's metadata limit along with the remaining metadata, for clusters with
+// overlay addresses spread over the default overlay network.
+func Test_Reachability_MetaMaxSize(t *testing.T) {
+	const peers = 300
+	rnd := rand.New(rand.NewSource(1))
+	members := make([]netip.Addr, peers)
+	for i := range members {
+		var a [4]byte
+		binary.BigEndian.PutUint32(a[:], 10<<24|rnd.Uint32()&0xffffff)
+		members[i] = netip.AddrFrom4(a)
+	}
+	node := Node{nodeMeta: nodeMeta{
+		OverlayAddr:  netip.MustParseAddr("10.1.2.3"),
+		PubKey:       "QUJDREVGR0hJSktMTU5PUFFSU1RVVldYWVphYmNkZWY=",
+		Capabilities: SupportedCapabilities,
+		Version:      FormatVersion("v0.4.2", "kernel 6.1.0"),
+		Reachable:    NewReachability(members, members[:peers/2]),
+	}}
+
+	encoded, err := node.EncodeMeta(memberlist.MetaMaxSize)
+	require.NoError(t, err)
+	decoded := Node{Meta: encoded}
+	require.NoError(t, decoded.DecodeMeta())
+	reachable, ok := decoded.Reachable.Reachable(members)
+	require.True(t, ok)
+	assert.ElementsMatch(t, members[:peers/2], reachable)
+}
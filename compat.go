@@ -0,0 +1,121 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed COMPATIBILITY.yaml
+var compatibilityYAML []byte
+
+// compatEntry describes the compatibility with peers running versions starting at Since.
+type compatEntry struct {
+	Since     string   `yaml:"since"`
+	Gossip    bool     `yaml:"gossip"`
+	KeyFile   bool     `yaml:"keyFile"`
+	StateFile bool     `yaml:"stateFile"`
+	Migration []string `yaml:"migration"`
+}
+
+type CompatCheckCmd struct {
+	PeerVersion string `help:"version (X.Y.Z) of the wesher nodes currently running" required:""`
+}
+
+// Run prints whether this version can be rolled out to a cluster running the given version.
+func (c *CompatCheckCmd) Run() error {
+	peerVersion, err := parseVersion(c.PeerVersion)
+	if err != nil {
+		return err
+	}
+	var entries []compatEntry
+	if err := yaml.Unmarshal(compatibilityYAML, &entries); err != nil {
+		return fmt.Errorf("decoding compatibility data: %w", err)
+	}
+
+	entry, err := findCompatEntry(entries, peerVersion)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return fmt.Errorf("no compatibility data for version %s", c.PeerVersion)
+	}
+
+	fmt.Printf("compatibility of %s with %s:\n", version, c.PeerVersion)
+	fmt.Printf("  gossip message format: %s\n", yesNo(entry.Gossip))
+	fmt.Printf("  key file format:       %s\n", yesNo(entry.KeyFile))
+	fmt.Printf("  state file format:     %s\n", yesNo(entry.StateFile))
+	if len(entry.Migration) > 0 {
+		fmt.Println("required migration steps:")
+		for _, step := range entry.Migration {
+			fmt.Printf("  - %s\n", step)
+		}
+	}
+	if !entry.Gossip || !entry.KeyFile || !entry.StateFile {
+		return fmt.Errorf("%s is not compatible with %s", version, c.PeerVersion)
+	}
+	return nil
+}
+
+// findCompatEntry returns the entry with the greatest Since not above v, or nil if there is none.
+func findCompatEntry(entries []compatEntry, v [3]int) (*compatEntry, error) {
+	type parsed struct {
+		since [3]int
+		entry *compatEntry
+	}
+	sorted := make([]parsed, len(entries))
+	for i := range entries {
+		since, err := parseVersion(entries[i].Since)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compatibility data: %w", err)
+		}
+		sorted[i] = parsed{since, &entries[i]}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return versionLess(sorted[i].since, sorted[j].since) })
+
+	var found *compatEntry
+	for _, p := range sorted {
+		if versionLess(v, p.since) {
+			break
+		}
+		found = p.entry
+	}
+	return found, nil
+}
+
+// parseVersion parses versions in the X.Y.Z format, with an optional "v" prefix.
+func parseVersion(s string) ([3]int, error) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("unsupported version %q; must be X.Y.Z", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("unsupported version %q; must be X.Y.Z", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func versionLess(a, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_parseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    [3]int
+		wantErr bool
+	}{
+		{"0.3.0", [3]int{0, 3, 0}, false},
+		{"v1.12.3", [3]int{1, 12, 3}, false},
+		{"1.2", [3]int{}, true},
+		{"1.2.3.4", [3]int{}, true},
+		{"1.x.3", [3]int{}, true},
+		{"1.-2.3", [3]int{}, true},
+		{"", [3]int{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := parseVersion(tt.version)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_findCompatEntry(t *testing.T) {
+	entries := []compatEntry{
+		{Since: "0.5.0", Gossip: true},
+		{Since: "0.3.0"},
+		{Since: "1.0.0", Gossip: true, KeyFile: true},
+	}
+	tests := []struct {
+		version   string
+		wantSince string // empty if no entry applies
+	}{
+		{"0.2.9", ""},
+		{"0.3.0", "0.3.0"},
+		{"0.4.10", "0.3.0"},
+		{"0.5.0", "0.5.0"},
+		{"0.10.0", "0.5.0"},
+		{"1.0.0", "1.0.0"},
+		{"2.0.0", "1.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			v, err := parseVersion(tt.version)
+			require.NoError(t, err)
+			got, err := findCompatEntry(entries, v)
+			require.NoError(t, err)
+			if tt.wantSince == "" {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, tt.wantSince, got.Since)
+		})
+	}
+
+	_, err := findCompatEntry([]compatEntry{{Since: "latest"}}, [3]int{1, 0, 0})
+	assert.Error(t, err, "invalid entries must be reported")
+}
+
+func Test_compatibilityYAML(t *testing.T) {
+	var entries []compatEntry
+	require.NoError(t, yaml.Unmarshal(compatibilityYAML, &entries))
+	require.NotEmpty(t, entries)
+	for _, entry := range entries {
+		_, err := parseVersion(entry.Since)
+		assert.NoError(t, err, entry.Since)
+	}
+}
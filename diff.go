@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/costela/wesher/cluster"
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/sirupsen/logrus"
+)
+
+type DiffCmd struct {
+	Interface       string `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	WireguardPort   int    `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	StaticPeersFile string `env:"WESHER_STATIC_PEERS_FILE" help:"static peers file used by the agent"`
+
+	// must be set to the values the agent runs with
+	peerConfigFlags
+}
+
+func (d *DiffCmd) Validate() error {
+	return d.peerConfigFlags.validate()
+}
+
+// Run compares the configuration the running agent would apply for the cluster members it last saw (as persisted in
+// its state file) with its live wireguard device and prints every discrepancy.
+// An error is returned if any discrepancy is found.
+func (d *DiffCmd) Run() error {
+	return d.diff((*wg.State).DiffConfig)
+}
+
+func (d *DiffCmd) diff(compare func(*wg.State, []common.Node) ([]wg.ConfigDiff, error)) error {
+	nodes, err := knownNodes(d.Interface, d.StaticPeersFile)
+	if err != nil {
+		return err
+	}
+
+	wgstate, err := wg.Open(d.Interface, d.WireguardPort)
+	if err != nil {
+		return err
+	}
+	d.peerConfigFlags.apply(wgstate)
+
+	diffs, err := compare(wgstate, nodes)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		names[node.PubKey] = node.Name
+	}
+	for _, diff := range diffs {
+		if name, ok := names[diff.PubKey]; ok {
+			fmt.Printf("%s (%s)\n", diff, name)
+		} else {
+			fmt.Println(diff)
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("found %d discrepancies", len(diffs))
+	}
+
+	return nil
+}
+
+// knownNodes returns the cluster members last seen by the agent running on iface, along with its static peers.
+func knownNodes(iface string, staticPeersFile string) ([]common.Node, error) {
+	var nodes []common.Node
+	for _, node := range cluster.KnownNodes(iface) {
+		if err := node.DecodeMeta(); err != nil {
+			logrus.Warnf("node %s: could not decode metadata: %s", node.Name, err)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if staticPeersFile != "" {
+		staticNodes, err := loadStaticPeers(staticPeersFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading static peers: %w", err)
+		}
+		nodes = append(nodes, staticNodes...)
+	}
+	return nodes, nil
+}
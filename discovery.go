@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/sirupsen/logrus"
+)
+
+// Supported --discovery-backend values.
+const (
+	discoveryConsul = "consul"
+	discoveryEtcd   = "etcd"
+)
+
+// discoveryEntry is the information registered for each node.
+type discoveryEntry struct {
+	Name        string `json:"name"`
+	OverlayAddr string `json:"overlay_addr"`
+	PubKey      string `json:"pubkey"`
+	Description string `json:"description,omitempty"`
+}
+
+// discoveryBackend registers nodes in an external service discovery system.
+type discoveryBackend interface {
+	register(entry discoveryEntry) error
+	deregister(name string) error
+}
+
+func newDiscoveryBackend(kind string, url string, prefix string) (discoveryBackend, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	url = strings.TrimSuffix(url, "/")
+	switch kind {
+	case discoveryConsul:
+		return &consulBackend{client: client, url: url, service: prefix}, nil
+	case discoveryEtcd:
+		return &etcdBackend{client: client, url: url, prefix: "/" + strings.Trim(prefix, "/") + "/"}, nil
+	}
+	return nil, fmt.Errorf("unknown discovery backend %q", kind)
+}
+
+// discoveryRegistrar keeps the nodes registered in a discovery backend in sync with the cluster members.
+// Registration is best-effort: failures are logged and retried on the next membership change.
+type discoveryRegistrar struct {
+	backend    discoveryBackend
+	registered map[string]discoveryEntry
+	queue      *common.NodeQueue
+}
+
+// newDiscoveryRegistrar starts a registrar syncing the node lists passed to Sync in the background.
+func newDiscoveryRegistrar(backend discoveryBackend) *discoveryRegistrar {
+	r := &discoveryRegistrar{
+		backend:    backend,
+		registered: map[string]discoveryEntry{},
+		queue:      common.NewNodeQueue(),
+	}
+	go func() {
+		for nodes := range r.queue.C() {
+			r.sync(nodes)
+		}
+	}()
+	return r
+}
+
+// Sync schedules the registration of nodes and the deregistration of all other previously registered nodes. It never
+// blocks; only the latest list is synced.
+func (r *discoveryRegistrar) Sync(nodes []common.Node) {
+	r.queue.Push(nodes)
+}
+
+func (r *discoveryRegistrar) sync(nodes []common.Node) {
+	current := make(map[string]discoveryEntry, len(nodes))
+	for _, node := range nodes {
+		if !node.OverlayAddr.IsValid() {
+			continue
+		}
+		current[node.Name] = discoveryEntry{
+			Name:        node.Name,
+			OverlayAddr: node.OverlayAddr.String(),
+			PubKey:      node.PubKey,
+			Description: node.Description,
+		}
+	}
+	for name, entry := range current {
+		if r.registered[name] == entry {
+			continue
+		}
+		if err := r.backend.register(entry); err != nil {
+			logrus.WithError(err).Warnf("could not register node %s for service discovery", name)
+			delete(r.registered, name)
+			continue
+		}
+		r.registered[name] = entry
+	}
+	for name := range r.registered {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if err := r.backend.deregister(name); err != nil {
+			logrus.WithError(err).Warnf("could not deregister node %s from service discovery", name)
+			continue
+		}
+		delete(r.registered, name)
+	}
+}
+
+// consulBackend registers nodes as services in the Consul catalog, using the catalog HTTP API.
+type consulBackend struct {
+	client  *http.Client
+	url     string
+	service string
+}
+
+func (b *consulBackend) serviceID(name string) string {
+	return b.service + "-" + name
+}
+
+func (b *consulBackend) register(entry discoveryEntry) error {
+	return b.put("/v1/catalog/register", map[string]interface{}{
+		"Node":           entry.Name,
+		"Address":        entry.OverlayAddr,
+		"SkipNodeUpdate": true,
+		"Service": map[string]interface{}{
+			"ID":      b.serviceID(entry.Name),
+			"Service": b.service,
+			"Address": entry.OverlayAddr,
+			"Meta": map[string]string{
+				"pubkey":      entry.PubKey,
+				"description": entry.Description,
+			},
+		},
+	})
+}
+
+// deregister removes the node's service, and the catalog node registered along with it unless other services were
+// registered on it since.
+func (b *consulBackend) deregister(name string) error {
+	if err := b.put("/v1/catalog/deregister", map[string]string{
+		"Node":      name,
+		"ServiceID": b.serviceID(name),
+	}); err != nil {
+		return err
+	}
+	services, err := b.nodeServices(name)
+	if err != nil {
+		return fmt.Errorf("listing remaining services: %w", err)
+	}
+	if services > 0 {
+		return nil
+	}
+	return b.put("/v1/catalog/deregister", map[string]string{
+		"Node": name,
+	})
+}
+
+// nodeServices returns the number of services registered on the named catalog node.
+func (b *consulBackend) nodeServices(name string) (int, error) {
+	resp, err := b.client.Get(b.url + "/v1/catalog/node/" + url.PathEscape(name))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, fmt.Errorf("GET %s returned %s", resp.Request.URL, resp.Status)
+	}
+	var node struct {
+		Services map[string]json.RawMessage
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return 0, err
+	}
+	return len(node.Services), nil
+}
+
+func (b *consulBackend) put(path string, payload interface{}) error {
+	return doJSON(b.client, http.MethodPut, b.url+path, payload)
+}
+
+// etcdBackend stores nodes as JSON values under a key prefix, using the etcd v3 JSON gateway.
+type etcdBackend struct {
+	client *http.Client
+	url    string
+	prefix string
+}
+
+func (b *etcdBackend) key(name string) string {
+	return base64.StdEncoding.EncodeToString([]byte(b.prefix + name))
+}
+
+func (b *etcdBackend) register(entry discoveryEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return doJSON(b.client, http.MethodPost, b.url+"/v3/kv/put", map[string]string{
+		"key":   b.key(entry.Name),
+		"value": base64.StdEncoding.EncodeToString(value),
+	})
+}
+
+func (b *etcdBackend) deregister(name string) error {
+	return doJSON(b.client, http.MethodPost, b.url+"/v3/kv/deleterange", map[string]string{
+		"key": b.key(name),
+	})
+}
+
+// doJSON sends payload as JSON body of a request, failing on unsuccessful responses.
+func doJSON(client *http.Client, method string, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s returned %s", method, url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDiscoveryBackend records registrations, failing for names in fail.
+type fakeDiscoveryBackend struct {
+	fail         map[string]bool
+	registered   []string
+	deregistered []string
+}
+
+func (b *fakeDiscoveryBackend) register(entry discoveryEntry) error {
+	if b.fail[entry.Name] {
+		return errors.New("failed")
+	}
+	b.registered = append(b.registered, entry.Name)
+	return nil
+}
+
+func (b *fakeDiscoveryBackend) deregister(name string) error {
+	if b.fail[name] {
+		return errors.New("failed")
+	}
+	b.deregistered = append(b.deregistered, name)
+	return nil
+}
+
+func Test_discoveryRegistrar_sync(t *testing.T) {
+	node := func(name, addr string) common.Node {
+		n := common.Node{Name: name}
+		if addr != "" {
+			n.OverlayAddr = netip.MustParseAddr(addr)
+		}
+		return n
+	}
+	backend := &fakeDiscoveryBackend{fail: map[string]bool{}}
+	r := &discoveryRegistrar{backend: backend, registered: map[string]discoveryEntry{}}
+
+	r.sync([]common.Node{node("a", "10.0.0.1"), node("b", "10.0.0.2"), node("c", "")})
+	assert.ElementsMatch(t, []string{"a", "b"}, backend.registered, "nodes without overlay address must be skipped")
+
+	backend.registered = nil
+	r.sync([]common.Node{node("a", "10.0.0.1"), node("b", "10.0.0.3")})
+	assert.Equal(t, []string{"b"}, backend.registered, "only changed nodes must be registered again")
+	assert.Empty(t, backend.deregistered)
+
+	backend.registered = nil
+	backend.fail["b"] = true
+	r.sync([]common.Node{node("a", "10.0.0.1")})
+	assert.Empty(t, backend.deregistered, "failed deregistrations must be kept")
+	delete(backend.fail, "b")
+	r.sync([]common.Node{node("a", "10.0.0.1")})
+	assert.Equal(t, []string{"b"}, backend.deregistered, "failed deregistrations must be retried")
+
+	backend.fail["d"] = true
+	r.sync([]common.Node{node("a", "10.0.0.1"), node("d", "10.0.0.4")})
+	assert.NotContains(t, r.registered, "d")
+	delete(backend.fail, "d")
+	r.sync([]common.Node{node("a", "10.0.0.1"), node("d", "10.0.0.4")})
+	assert.Contains(t, backend.registered, "d", "failed registrations must be retried")
+}
+
+// discoveryRequest is a request received by a fake discovery server.
+type discoveryRequest struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// fakeDiscoveryServer records requests, answering them with the response registered for their path, if any.
+func fakeDiscoveryServer(t *testing.T, responses map[string]string) (*httptest.Server, func() []discoveryRequest) {
+	var (
+		mu       sync.Mutex
+		requests []discoveryRequest
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := discoveryRequest{Method: r.Method, Path: r.URL.Path}
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if len(body) > 0 {
+			require.NoError(t, json.Unmarshal(body, &req.Body))
+		}
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+		io.WriteString(w, responses[r.URL.Path]) // nolint: errcheck // test server
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []discoveryRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func Test_consulBackend(t *testing.T) {
+	srv, requests := fakeDiscoveryServer(t, map[string]string{"/v1/catalog/node/node1": "null"})
+	backend, err := newDiscoveryBackend(discoveryConsul, srv.URL+"/", "wesher")
+	require.NoError(t, err)
+
+	require.NoError(t, backend.register(discoveryEntry{Name: "node1", OverlayAddr: "10.0.0.1", PubKey: "key", Description: "desc"}))
+	require.NoError(t, backend.deregister("node1"))
+
+	reqs := requests()
+	require.Len(t, reqs, 4)
+	assert.Equal(t, http.MethodPut, reqs[0].Method)
+	assert.Equal(t, "/v1/catalog/register", reqs[0].Path)
+	assert.Equal(t, "node1", reqs[0].Body["Node"])
+	assert.Equal(t, "10.0.0.1", reqs[0].Body["Address"])
+	assert.Equal(t, map[string]interface{}{
+		"ID":      "wesher-node1",
+		"Service": "wesher",
+		"Address": "10.0.0.1",
+		"Meta":    map[string]interface{}{"pubkey": "key", "description": "desc"},
+	}, reqs[0].Body["Service"])
+
+	assert.Equal(t, discoveryRequest{Method: http.MethodPut, Path: "/v1/catalog/deregister", Body: map[string]interface{}{"Node": "node1", "ServiceID": "wesher-node1"}}, reqs[1])
+	assert.Equal(t, "/v1/catalog/node/node1", reqs[2].Path)
+	assert.Equal(t, discoveryRequest{Method: http.MethodPut, Path: "/v1/catalog/deregister", Body: map[string]interface{}{"Node": "node1"}}, reqs[3], "the catalog node must be deregistered too")
+}
+
+func Test_consulBackend_deregister_keepsNodeWithServices(t *testing.T) {
+	srv, requests := fakeDiscoveryServer(t, map[string]string{"/v1/catalog/node/node1": `{"Node":{"Node":"node1"},"Services":{"other":{"ID":"other"}}}`})
+	backend, err := newDiscoveryBackend(discoveryConsul, srv.URL, "wesher")
+	require.NoError(t, err)
+
+	require.NoError(t, backend.deregister("node1"))
+	reqs := requests()
+	require.Len(t, reqs, 2, "nodes with other services must be kept")
+	assert.Equal(t, "wesher-node1", reqs[0].Body["ServiceID"])
+}
+
+func Test_etcdBackend(t *testing.T) {
+	srv, requests := fakeDiscoveryServer(t, nil)
+	backend, err := newDiscoveryBackend(discoveryEtcd, srv.URL, "/wesher/")
+	require.NoError(t, err)
+
+	entry := discoveryEntry{Name: "node1", OverlayAddr: "10.0.0.1", PubKey: "key"}
+	require.NoError(t, backend.register(entry))
+	require.NoError(t, backend.deregister("node1"))
+
+	key := base64.StdEncoding.EncodeToString([]byte("/wesher/node1"))
+	reqs := requests()
+	require.Len(t, reqs, 2)
+	assert.Equal(t, http.MethodPost, reqs[0].Method)
+	assert.Equal(t, "/v3/kv/put", reqs[0].Path)
+	assert.Equal(t, key, reqs[0].Body["key"])
+	value, err := base64.StdEncoding.DecodeString(reqs[0].Body["value"].(string))
+	require.NoError(t, err)
+	var registered discoveryEntry
+	require.NoError(t, json.Unmarshal(value, &registered))
+	assert.Equal(t, entry, registered)
+	assert.Equal(t, discoveryRequest{Method: http.MethodPost, Path: "/v3/kv/deleterange", Body: map[string]interface{}{"key": key}}, reqs[1])
+}
+
+func Test_doJSON_errorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	assert.Error(t, doJSON(srv.Client(), http.MethodPut, srv.URL, nil))
+}
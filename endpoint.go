@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// endpointSettleTime is the time to wait for further address changes before re-detecting the local endpoint.
+const endpointSettleTime = 2 * time.Second
+
+// watchEndpoint monitors local address changes and sends the newly detected bind address to endpointc whenever it
+// changes, so it can be announced to the cluster without waiting for other nodes to notice.
+func (a *AgentCmd) watchEndpoint(ctx context.Context, endpointc chan<- netip.Addr) {
+	updates := make(chan netlink.AddrUpdate)
+	if err := netlink.AddrSubscribe(updates, ctx.Done()); err != nil {
+		logrus.WithError(err).Error("could not watch local address changes")
+		return
+	}
+
+	current := a.BindAddr
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-updates:
+			if !ok {
+				logrus.Error("stopped watching local address changes")
+				return
+			}
+			settle = time.After(endpointSettleTime)
+		case <-settle:
+			detected, err := a.detectBindAddr()
+			if err != nil {
+				logrus.WithError(err).Warn("could not detect local address")
+				continue
+			}
+			if detected == current {
+				continue
+			}
+			addr, err := netip.ParseAddr(detected)
+			if err != nil || addr.IsUnspecified() {
+				continue
+			}
+			current = detected
+			select {
+			case endpointc <- addr:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
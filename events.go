@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/costela/wesher/wg"
+	"github.com/sirupsen/logrus"
+)
+
+// configEventPoster returns a callback POSTing wireguard configuration events as JSON to the provided URL.
+func configEventPoster(url string) func(wg.ConfigEvent) {
+	post := jsonPoster(url, "config event")
+	return func(event wg.ConfigEvent) { post(event) }
+}
+
+// jsonPoster returns a callback POSTing its argument as JSON to the provided URL.
+// Delivery is best-effort: payloads are sent in order from a single goroutine and dropped if the queue is full.
+func jsonPoster(url string, what string) func(interface{}) {
+	payloads := make(chan interface{}, 16)
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	go func() {
+		for payload := range payloads {
+			body, err := json.Marshal(payload)
+			if err != nil {
+				logrus.WithError(err).Errorf("could not encode %s", what)
+				continue
+			}
+			resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+			if err != nil {
+				logrus.WithError(err).Errorf("could not post %s to %s", what, url)
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				logrus.Errorf("posting %s to %s returned %s", what, url, resp.Status)
+			}
+		}
+	}()
+
+	return func(payload interface{}) {
+		select {
+		case payloads <- payload:
+		default:
+			logrus.Warnf("%s queue full, dropping %s", what, what)
+		}
+	}
+}
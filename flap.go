@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/sirupsen/logrus"
+)
+
+// flapDamper detects peers rejoining shortly after leaving the cluster. While peers flap, reconfiguration
+// notifications are suppressed for a penalty period, to avoid churn in whatever reacts to them.
+type flapDamper struct {
+	window  time.Duration
+	penalty time.Duration
+
+	present       map[string]bool
+	removed       map[string]time.Time
+	suppressUntil time.Time
+}
+
+func newFlapDamper(window, penalty time.Duration) *flapDamper {
+	return &flapDamper{
+		window:  window,
+		penalty: penalty,
+		present: make(map[string]bool),
+		removed: make(map[string]time.Time),
+	}
+}
+
+// update records the current cluster members and returns the time until which notifications are suppressed, which
+// is zero if they are not.
+func (d *flapDamper) update(nodes []common.Node, now time.Time) time.Time {
+	present := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.PubKey] = true
+		if d.present[node.PubKey] {
+			continue
+		}
+		if removed, ok := d.removed[node.PubKey]; ok && now.Sub(removed) < d.window {
+			logrus.Warnf("node %s flapping: rejoined %s after leaving, suppressing notifications for %s", node.Name, now.Sub(removed).Round(time.Second), d.penalty)
+			d.suppressUntil = now.Add(d.penalty)
+		}
+		delete(d.removed, node.PubKey)
+	}
+	for pubKey := range d.present {
+		if !present[pubKey] {
+			d.removed[pubKey] = now
+		}
+	}
+	for pubKey, removed := range d.removed {
+		if now.Sub(removed) >= d.window {
+			delete(d.removed, pubKey)
+		}
+	}
+	d.present = present
+
+	if now.Before(d.suppressUntil) {
+		return d.suppressUntil
+	}
+	return time.Time{}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_flapDamper_update(t *testing.T) {
+	node := func(name string) common.Node {
+		n := common.Node{Name: name}
+		n.PubKey = "key-" + name
+		return n
+	}
+	a, b := node("a"), node("b")
+	start := time.Now()
+
+	d := newFlapDamper(time.Minute, 5*time.Minute)
+	tests := []struct {
+		name  string
+		nodes []common.Node
+		at    time.Duration
+		want  time.Duration // zero if notifications are not suppressed
+	}{
+		{"initial members", []common.Node{a, b}, 0, 0},
+		{"node leaves", []common.Node{a}, 10 * time.Second, 0},
+		{"node rejoins within window", []common.Node{a, b}, 20 * time.Second, 20*time.Second + 5*time.Minute},
+		{"suppression lasts for the penalty", []common.Node{a, b}, time.Minute, 20*time.Second + 5*time.Minute},
+		{"node leaves again", []common.Node{a}, 6 * time.Minute, 0},
+		{"node rejoins after window", []common.Node{a, b}, 8 * time.Minute, 0},
+		{"new node joins", []common.Node{a, b, node("c")}, 9 * time.Minute, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := d.update(tt.nodes, start.Add(tt.at))
+			if tt.want == 0 {
+				assert.True(t, got.IsZero(), "notifications must not be suppressed, got %s", got)
+			} else {
+				assert.Equal(t, start.Add(tt.want), got)
+			}
+		})
+	}
+	assert.Empty(t, d.removed, "removals older than the window must be pruned")
+}
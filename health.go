@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/costela/wesher/wg"
+)
+
+type HealthCmd struct {
+	Interface        string        `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	WireguardPort    int           `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	HandshakeTimeout time.Duration `env:"WESHER_HANDSHAKE_TIMEOUT" help:"handshake timeout used by the agent" default:"3m"`
+	HealthThreshold  float64       `env:"WESHER_HEALTH_THRESHOLD" help:"minimum fraction of peers with a recent handshake for the node to be considered healthy" default:"0.5"`
+}
+
+// Run prints the health score of the local node as JSON, failing if it is below the threshold, so it can be used as a
+// go/no-go check by load balancers and monitoring systems.
+func (h *HealthCmd) Run() error {
+	wgstate, err := wg.Open(h.Interface, h.WireguardPort)
+	if err != nil {
+		return err
+	}
+	wgstate.HandshakeTimeout = h.HandshakeTimeout
+	summary, err := wgstate.Health()
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(summary); err != nil {
+		return err
+	}
+	if summary.Score < h.HealthThreshold {
+		return fmt.Errorf("health score %.2f below threshold %.2f", summary.Score, h.HealthThreshold)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// InterfaceEvent describes a change of the wireguard interface's state.
+type InterfaceEvent struct {
+	Time      time.Time
+	Interface string
+	// Present is false if the interface was deleted.
+	Present   bool
+	AdminUp   bool
+	OperState string
+	// Recreations counts how often the interface was recreated since the agent started.
+	Recreations uint64
+}
+
+// watchInterface monitors the wireguard interface and calls notify whenever it is recreated or its administrative or
+// operational state changes.
+// This allows telling interface blips apart from unreachable peers.
+func (a *AgentCmd) watchInterface(ctx context.Context, notify func(interface{})) {
+	updates := make(chan netlink.LinkUpdate)
+	if err := netlink.LinkSubscribe(updates, ctx.Done()); err != nil {
+		logrus.WithError(err).Error("could not watch interface changes")
+		return
+	}
+
+	var last InterfaceEvent
+	var lastIndex int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				logrus.Error("stopped watching interface changes")
+				return
+			}
+			attrs := update.Link.Attrs()
+			if attrs.Name != a.Interface {
+				continue
+			}
+			event := InterfaceEvent{
+				Interface:   a.Interface,
+				Present:     update.Header.Type != syscall.RTM_DELLINK,
+				AdminUp:     attrs.Flags&net.FlagUp != 0,
+				OperState:   attrs.OperState.String(),
+				Recreations: last.Recreations,
+			}
+			if event.Present {
+				if lastIndex != 0 && attrs.Index != lastIndex {
+					event.Recreations++
+				}
+				lastIndex = attrs.Index
+			}
+			if event == last {
+				continue
+			}
+			last = event
+			logrus.Infof("interface %s changed: present: %t, admin up: %t, operational state: %s, recreations: %d", event.Interface, event.Present, event.AdminUp, event.OperState, event.Recreations)
+			event.Time = time.Now()
+			notify(event)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+
+	"github.com/costela/wesher/common"
+	"gopkg.in/yaml.v3"
+)
+
+type K8sPolicyCmd struct {
+	Namespace       string `help:"namespace of the generated NetworkPolicy" default:"default"`
+	Name            string `help:"name of the generated NetworkPolicy" default:"wesher-peers"`
+	Output          string `help:"file to write the NetworkPolicy to; - writes to stdout" default:"-"`
+	Apply           bool   `help:"apply the NetworkPolicy to the current cluster with kubectl"`
+	Interface       string `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	WireguardPort   int    `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	StaticPeersFile string `env:"WESHER_STATIC_PEERS_FILE" help:"static peers file used by the agent"`
+}
+
+// networkPolicy is the subset of the Kubernetes networking.k8s.io/v1 NetworkPolicy resource used by K8sPolicyCmd.
+type networkPolicy struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+	Spec struct {
+		PodSelector struct{}        `yaml:"podSelector"`
+		PolicyTypes []string        `yaml:"policyTypes"`
+		Ingress     []ingressPolicy `yaml:"ingress"`
+	} `yaml:"spec"`
+}
+
+type ingressPolicy struct {
+	From  []policyPeer `yaml:"from"`
+	Ports []policyPort `yaml:"ports"`
+}
+
+type policyPeer struct {
+	IPBlock struct {
+		CIDR string `yaml:"cidr"`
+	} `yaml:"ipBlock"`
+}
+
+type policyPort struct {
+	Protocol string `yaml:"protocol"`
+	Port     int    `yaml:"port"`
+}
+
+// Run generates a NetworkPolicy allowing ingress from the overlay addresses of all peers last seen by the running
+// agent on the wireguard port, and writes or applies it.
+func (k *K8sPolicyCmd) Run() error {
+	nodes, err := knownNodes(k.Interface, k.StaticPeersFile)
+	if err != nil {
+		return err
+	}
+	content, err := encodeNetworkPolicy(k.networkPolicy(nodes))
+	if err != nil {
+		return err
+	}
+
+	if k.Output == "-" && !k.Apply {
+		_, err := os.Stdout.Write(content)
+		return err
+	}
+	if k.Output != "-" {
+		if err := os.WriteFile(k.Output, content, 0o644); err != nil {
+			return fmt.Errorf("writing NetworkPolicy: %w", err)
+		}
+	}
+	if k.Apply {
+		return kubectlApply(bytes.NewReader(content))
+	}
+	return nil
+}
+
+// networkPolicy returns the NetworkPolicy allowing ingress from the overlay addresses of nodes on the wireguard port.
+func (k *K8sPolicyCmd) networkPolicy(nodes []common.Node) networkPolicy {
+	var cidrs []string
+	for _, node := range nodes {
+		if node.OverlayAddr.IsValid() {
+			cidrs = append(cidrs, fmt.Sprintf("%s/%d", node.OverlayAddr, node.OverlayAddr.BitLen()))
+		}
+	}
+	sort.Strings(cidrs)
+
+	policy := networkPolicy{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"}
+	policy.Metadata.Name = k.Name
+	policy.Metadata.Namespace = k.Namespace
+	policy.Spec.PolicyTypes = []string{"Ingress"}
+	ingress := ingressPolicy{Ports: []policyPort{{Protocol: "UDP", Port: k.WireguardPort}}}
+	for _, cidr := range cidrs {
+		var peer policyPeer
+		peer.IPBlock.CIDR = cidr
+		ingress.From = append(ingress.From, peer)
+	}
+	if len(ingress.From) > 0 {
+		// an ingress rule without peers would allow all sources
+		policy.Spec.Ingress = []ingressPolicy{ingress}
+	}
+	return policy
+}
+
+func encodeNetworkPolicy(policy networkPolicy) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(policy); err != nil {
+		return nil, fmt.Errorf("encoding NetworkPolicy: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// kubectlApply applies the resources read from r to the cluster kubectl is configured for.
+func kubectlApply(r io.Reader) error {
+	cmd := exec.Command("kubectl", "apply", "-f", "-")
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("applying NetworkPolicy with kubectl: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_K8sPolicyCmd_networkPolicy(t *testing.T) {
+	node := func(addr string) common.Node {
+		var n common.Node
+		if addr != "" {
+			n.OverlayAddr = netip.MustParseAddr(addr)
+		}
+		return n
+	}
+	k := &K8sPolicyCmd{Namespace: "mesh", Name: "wesher-peers", WireguardPort: 51820}
+
+	tests := []struct {
+		name  string
+		nodes []common.Node
+		want  string
+	}{
+		{
+			name:  "peers",
+			nodes: []common.Node{node("10.0.0.2"), node("fd00::1"), node(""), node("10.0.0.1")},
+			want: `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: wesher-peers
+  namespace: mesh
+spec:
+  podSelector: {}
+  policyTypes:
+    - Ingress
+  ingress:
+    - from:
+        - ipBlock:
+            cidr: 10.0.0.1/32
+        - ipBlock:
+            cidr: 10.0.0.2/32
+        - ipBlock:
+            cidr: fd00::1/128
+      ports:
+        - protocol: UDP
+          port: 51820
+`,
+		},
+		{
+			name:  "no peers denies all ingress",
+			nodes: nil,
+			want: `apiVersion: networking.k8s.io/v1
+kind: NetworkPolicy
+metadata:
+  name: wesher-peers
+  namespace: mesh
+spec:
+  podSelector: {}
+  policyTypes:
+    - Ingress
+  ingress: []
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := encodeNetworkPolicy(k.networkPolicy(tt.nodes))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(content))
+		})
+	}
+}
@@ -3,8 +3,14 @@ package main
 import (
 	"encoding"
 	"encoding/base64"
+	"fmt"
+
+	"github.com/costela/wesher/wg"
 )
 
+// keyFileTemplate is the default location of the wireguard private key, by interface name.
+const keyFileTemplate = "/var/lib/wesher/%s.key"
+
 type key struct {
 	bytes []byte
 }
@@ -17,3 +23,16 @@ func (k *key) UnmarshalText(in []byte) error {
 	k.bytes = k.bytes[:n]
 	return err
 }
+
+// keyFilePath returns the file the wireguard private key is persisted in. Without explicit path, the key is kept next to
+// the cluster state, unless that is disabled.
+func keyFilePath(path string, iface string, noStateFile bool) string {
+	switch {
+	case path != "":
+		return path
+	case noStateFile:
+		return wg.EphemeralKeyFile
+	default:
+		return fmt.Sprintf(keyFileTemplate, iface)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pidFileTemplate is the default location of the agent's PID file, by interface name.
+const pidFileTemplate = "/run/wesher/%s.pid"
+
+// leaveSignal makes the agent leave the cluster and remove its interface immediately, regardless of --on-exit.
+const leaveSignal = syscall.SIGUSR2
+
+type ClusterCmd struct {
+	Leave ClusterLeaveCmd `cmd:"" help:"make the running agent leave the cluster, remove its interface and exit"`
+}
+
+type ClusterLeaveCmd struct {
+	Interface       string        `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	PIDFile         string        `name:"pid-file" env:"WESHER_PID_FILE" help:"PID file of the running agent (default: /run/wesher/INTERFACE.pid)"`
+	LeaveAckTimeout time.Duration `env:"WESHER_LEAVE_ACK_TIMEOUT" help:"time to wait for the agent to leave and exit" default:"30s"`
+}
+
+// Run signals the running agent to leave the cluster and waits for it to exit.
+func (c *ClusterLeaveCmd) Run() error {
+	pid, err := readPIDFile(pidFilePath(c.PIDFile, c.Interface))
+	if err != nil {
+		return err
+	}
+	if err := verifyAgentPID(pid); err != nil {
+		return err
+	}
+	if err := syscall.Kill(pid, leaveSignal); err != nil {
+		return fmt.Errorf("signaling agent (pid %d): %w", pid, err)
+	}
+
+	deadline := time.Now().Add(c.LeaveAckTimeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, 0); errors.Is(err, syscall.ESRCH) {
+			fmt.Printf("agent for %s left the cluster\n", c.Interface)
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("agent (pid %d) did not exit within %s", pid, c.LeaveAckTimeout)
+}
+
+func pidFilePath(path, iface string) string {
+	if path != "" {
+		return path
+	}
+	return fmt.Sprintf(pidFileTemplate, iface)
+}
+
+func readPIDFile(path string) (int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("reading PID file: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid PID file %s", path)
+	}
+	return pid, nil
+}
+
+// procDir is where process information is read from; it can be replaced in tests.
+var procDir = "/proc"
+
+// taskCommLen is the maximum length of process names reported by the kernel, excluding the terminating null byte.
+const taskCommLen = 15
+
+// verifyAgentPID checks that pid belongs to a running wesher process, so a PID left in a stale PID file and reused by
+// another process is never signaled. The process name must be "wesher" or the name of this executable.
+func verifyAgentPID(pid int) error {
+	comm, err := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("agent (pid %d) is not running; the PID file may be stale", pid)
+	} else if err != nil {
+		return fmt.Errorf("checking agent process: %w", err)
+	}
+	name := strings.TrimSpace(string(comm))
+	for _, want := range agentProcessNames() {
+		if name == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("process %d is %q, not a wesher agent; the PID file may be stale", pid, name)
+}
+
+// agentProcessNames returns the process names the agent may run as, truncated like the kernel does.
+func agentProcessNames() []string {
+	names := []string{"wesher"}
+	if exe, err := os.Executable(); err == nil {
+		names = append(names, filepath.Base(exe))
+	}
+	for i, name := range names {
+		if len(name) > taskCommLen {
+			names[i] = name[:taskCommLen]
+		}
+	}
+	return names
+}
+
+// writePIDFile records the agent's PID, so it can be found by commands controlling it. Failures are only logged, since
+// the agent works without it.
+func writePIDFile(path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		logrus.WithError(err).Warn("could not create PID file directory")
+		return
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		logrus.WithError(err).Warn("could not write PID file")
+	}
+}
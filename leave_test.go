@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readPIDFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{"plain", "1234", 1234, false},
+		{"trailing newline", "1234\n", 1234, false},
+		{"surrounding whitespace", " 1234 \n", 1234, false},
+		{"empty", "", 0, true},
+		{"zero", "0\n", 0, true},
+		{"negative", "-1\n", 0, true},
+		{"garbage", "wesher\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "wesher.pid")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+			got, err := readPIDFile(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := readPIDFile(filepath.Join(t.TempDir(), "missing.pid"))
+	assert.Error(t, err)
+}
+
+func Test_writePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "wesher.pid")
+	writePIDFile(path)
+	pid, err := readPIDFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+}
+
+func Test_verifyAgentPID(t *testing.T) {
+	defer func(orig string) { procDir = orig }(procDir)
+	procDir = t.TempDir()
+	for pid, comm := range map[int]string{1: "wesher\n", 2: "sshd\n", 3: agentProcessNames()[1] + "\n"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(procDir, strconv.Itoa(pid)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"), []byte(comm), 0644))
+	}
+
+	assert.NoError(t, verifyAgentPID(1))
+	assert.Error(t, verifyAgentPID(2), "other processes must not be signaled")
+	assert.NoError(t, verifyAgentPID(3), "renamed binaries must be recognized")
+	assert.Error(t, verifyAgentPID(4), "exited processes must not be signaled")
+}
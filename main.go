@@ -14,15 +14,30 @@ type cli struct {
 	LogLevel LogLevelFlag `env:"WESHER_LOG_LEVEL" help:"set the verbosity (debug/info/warn/error)" default:"warn"`
 	Version  VersionFlag  `help:"display current version and exit"`
 
-	Agent AgentCmd `cmd:"" default:"withargs" help:"start the wesher agent (default when no command specified)"`
+	Agent       AgentCmd       `cmd:"" default:"withargs" help:"start the wesher agent (default when no command specified)"`
+	ExportCerts ExportCertsCmd `cmd:"" help:"export a certificate and key derived from the running agent's wireguard key"`
+	Peers       PeersCmd       `cmd:"" help:"manage peers"`
+	Diff        DiffCmd        `cmd:"" help:"show differences between the configuration expected from cluster membership and the live wireguard device"`
+	Cluster     ClusterCmd     `cmd:"" help:"manage the local node's cluster membership"`
+	Matrix      MatrixCmd      `cmd:"" help:"show which cluster nodes reach each other over the overlay"`
+	Health      HealthCmd      `cmd:"" help:"print the share of peers with a recent handshake, failing if it is below a threshold"`
+	CompatCheck CompatCheckCmd `cmd:"" help:"check whether this version is compatible with nodes running an older version"`
+	K8sPolicy   K8sPolicyCmd   `cmd:"" name:"k8s-policy" help:"generate a Kubernetes NetworkPolicy allowing ingress from all known peers"`
+	Versions    VersionsCmd    `cmd:"" help:"show the wesher and wireguard versions run by all cluster nodes"`
 }
 
 func main() {
+	// follows the convention from https://no-color.org/: any non-empty value disables colors
+	if os.Getenv("WESHER_NO_COLOR") != "" {
+		logrus.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	}
+
 	cli := &cli{}
 	ktx := kong.Parse(cli,
 		kong.Name("wesher"),
 		kong.Description("mesh overlay network manager"),
 		kong.UsageOnError(),
+		kong.Configuration(kong.JSON),
 	)
 
 	err := ktx.Run(cli)
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/costela/wesher/cluster"
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+)
+
+type MatrixCmd struct {
+	Interface        string        `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	WireguardPort    int           `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	HandshakeTimeout time.Duration `env:"WESHER_HANDSHAKE_TIMEOUT" help:"handshake timeout used by the agent" default:"3m"`
+}
+
+// matrixRow is the reachability reported by a single node; reported is false for nodes not reporting reachability.
+type matrixRow struct {
+	name      string
+	addr      netip.Addr
+	reported  bool
+	reachable map[netip.Addr]bool
+}
+
+// Run prints the reachability between all cluster nodes, as reported by each node over gossip, along with any
+// asymmetric reachability found. The local node's row is taken from the live wireguard device.
+// An error is returned if any node does not reach another one.
+func (m *MatrixCmd) Run() error {
+	nodes, err := knownNodes(m.Interface, "")
+	if err != nil {
+		return err
+	}
+
+	wgstate, err := wg.Open(m.Interface, m.WireguardPort)
+	if err != nil {
+		return err
+	}
+	wgstate.HandshakeTimeout = m.HandshakeTimeout
+	localReachable, err := wgstate.ReachableNodes(nodes)
+	if err != nil {
+		return err
+	}
+	localAddr, err := interfaceAddr(m.Interface)
+	if err != nil {
+		return err
+	}
+	localName, err := localNodeName(m.Interface)
+	if err != nil {
+		return err
+	}
+
+	rows := []matrixRow{newMatrixRow(localName, localAddr, true, localReachable)}
+	for _, node := range nodes {
+		// reports refer to all other members, including the local node
+		members := []netip.Addr{localAddr}
+		for _, other := range nodes {
+			if other.Name != node.Name {
+				members = append(members, other.OverlayAddr)
+			}
+		}
+		reachable, ok := node.Reachable.Reachable(members)
+		rows = append(rows, newMatrixRow(node.Name, node.OverlayAddr, ok && node.HasCapability(common.CapReachability), reachable))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := []string{""}
+	for _, to := range rows {
+		header = append(header, to.name)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, from := range rows {
+		cells := []string{from.name}
+		for _, to := range rows {
+			cells = append(cells, from.cell(to))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	unreachable := 0
+	for i, a := range rows {
+		for _, b := range rows[i+1:] {
+			ab, ba := a.cell(b), b.cell(a)
+			if ab == "no" || ba == "no" {
+				unreachable++
+			}
+			if ab != ba && ab != "?" && ba != "?" {
+				fmt.Printf("asymmetric: %s -> %s: %s, %s -> %s: %s\n", a.name, b.name, ab, b.name, a.name, ba)
+			}
+		}
+	}
+	if unreachable > 0 {
+		return fmt.Errorf("found %d node pairs without mutual reachability", unreachable)
+	}
+	return nil
+}
+
+func newMatrixRow(name string, addr netip.Addr, reported bool, reachable []netip.Addr) matrixRow {
+	row := matrixRow{name: name, addr: addr, reported: reported, reachable: make(map[netip.Addr]bool, len(reachable))}
+	for _, a := range reachable {
+		row.reachable[a] = true
+	}
+	return row
+}
+
+// cell returns whether r reaches to: "yes", "no", "-" for itself or "?" if r does not report reachability.
+func (r matrixRow) cell(to matrixRow) string {
+	switch {
+	case r.addr == to.addr:
+		return "-"
+	case !r.reported:
+		return "?"
+	case r.reachable[to.addr]:
+		return "yes"
+	default:
+		return "no"
+	}
+}
+
+// localNodeName returns the cluster member name of the agent running on iface, which may differ from the hostname,
+// e.g. with --normalize-name.
+func localNodeName(iface string) (string, error) {
+	local, ok := cluster.KnownLocalNode(iface)
+	if !ok {
+		return "", fmt.Errorf("the state of the agent running on %s does not hold the local node yet; retry once it saved its state, e.g. after the next cluster change", iface)
+	}
+	return local.Name, nil
+}
+
+// interfaceAddr returns the first address of the given interface, i.e. the overlay address of the running agent.
+func interfaceAddr(iface string) (netip.Addr, error) {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("getting interface %s: %w", iface, err)
+	}
+	addrs, err := link.Addrs()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("getting addresses of %s: %w", iface, err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if a, ok := netip.AddrFromSlice(ipNet.IP); ok {
+				return a.Unmap(), nil
+			}
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no address found on %s", iface)
+}
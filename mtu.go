@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Wireguard encapsulation overhead: outer IP header, UDP header (8 bytes) and wireguard data header (16 bytes) plus
+// authentication tag (16 bytes).
+const (
+	wgOverheadIPv4 = 20 + 8 + 32
+	wgOverheadIPv6 = 40 + 8 + 32
+)
+
+// overlayMTU computes the MTU for the wireguard interface by subtracting the encapsulation overhead from the MTU of the
+// underlay interface, i.e. the one cluster traffic is bound to.
+func (a *AgentCmd) overlayMTU() (int, error) {
+	bindAddr, _ := netip.ParseAddr(a.BindAddr)
+
+	overhead, err := mtuOverhead(a.WgMTUOverhead, bindAddr)
+	if err != nil {
+		return 0, err
+	}
+	link, err := a.underlayLink(bindAddr)
+	if err != nil {
+		return 0, err
+	}
+	return link.Attrs().MTU - overhead, nil
+}
+
+// mtuOverhead parses the --wg-mtu-overhead setting. "auto" selects the wireguard overhead for the family of bindAddr,
+// defaulting to IPv4.
+func mtuOverhead(setting string, bindAddr netip.Addr) (int, error) {
+	if setting == "auto" {
+		if bindAddr.Is6() && !bindAddr.Is4In6() {
+			return wgOverheadIPv6, nil
+		}
+		return wgOverheadIPv4, nil
+	}
+	overhead, err := strconv.Atoi(setting)
+	if err != nil || overhead < 0 {
+		return 0, fmt.Errorf("unsupported wireguard MTU overhead %q; must be \"auto\" or a positive number", setting)
+	}
+	return overhead, nil
+}
+
+// underlayLink returns the bind interface if set, or else the interface holding bindAddr, or else the one routing
+// traffic towards it. If bindAddr is unspecified, the interface of the default route for its family is used.
+func (a *AgentCmd) underlayLink(bindAddr netip.Addr) (netlink.Link, error) {
+	if a.BindIface != "" {
+		return netlink.LinkByName(a.BindIface)
+	}
+	if bindAddr.IsValid() && !bindAddr.IsUnspecified() {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return nil, fmt.Errorf("listing interfaces: %w", err)
+		}
+		for _, iface := range ifaces {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(bindAddr.AsSlice()) {
+					return netlink.LinkByIndex(iface.Index)
+				}
+			}
+		}
+		routes, err := netlink.RouteGet(bindAddr.AsSlice())
+		if err != nil || len(routes) == 0 {
+			return nil, fmt.Errorf("could not find underlay interface towards %s: %v", bindAddr, err)
+		}
+		return netlink.LinkByIndex(routes[0].LinkIndex)
+	}
+
+	family := netlink.FAMILY_V4
+	if bindAddr.Is6() && !bindAddr.Is4In6() {
+		family = netlink.FAMILY_V6
+	}
+	routes, err := netlink.RouteListFiltered(family, &netlink.Route{}, netlink.RT_FILTER_DST)
+	if err != nil || len(routes) == 0 {
+		return nil, fmt.Errorf("could not find underlay interface of the default route: %v", err)
+	}
+	return netlink.LinkByIndex(routes[0].LinkIndex)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_mtuOverhead(t *testing.T) {
+	tests := []struct {
+		setting  string
+		bindAddr string
+		want     int
+		wantErr  bool
+	}{
+		{"auto", "", wgOverheadIPv4, false},
+		{"auto", "192.0.2.1", wgOverheadIPv4, false},
+		{"auto", "::ffff:192.0.2.1", wgOverheadIPv4, false},
+		{"auto", "2001:db8::1", wgOverheadIPv6, false},
+		{"auto", "::", wgOverheadIPv6, false},
+		{"100", "2001:db8::1", 100, false},
+		{"0", "", 0, false},
+		{"-1", "", 0, true},
+		{"large", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.setting+" "+tt.bindAddr, func(t *testing.T) {
+			bindAddr, _ := netip.ParseAddr(tt.bindAddr)
+			got, err := mtuOverhead(tt.setting, bindAddr)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_AgentCmd_overlayMTU(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %s", err)
+	}
+
+	tests := []struct {
+		name string
+		cmd  AgentCmd
+		want int
+	}{
+		{"bind interface", AgentCmd{BindIface: "lo", WgMTUOverhead: "auto"}, lo.MTU - wgOverheadIPv4},
+		{"bind address", AgentCmd{BindAddr: "127.0.0.1", WgMTUOverhead: "auto"}, lo.MTU - wgOverheadIPv4},
+		{"fixed overhead", AgentCmd{BindAddr: "127.0.0.1", WgMTUOverhead: "100"}, lo.MTU - 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cmd.overlayMTU()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// checkOverlayOverlap looks for local networks overlapping the overlay network, which would otherwise have their
+// traffic hijacked by the overlay. Overlaps are only warned about, unless running in strict mode.
+func (a *AgentCmd) checkOverlayOverlap() error {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("listing routes: %w", err)
+	}
+
+	var conflicts []string
+	for _, route := range routes {
+		if route.Dst == nil {
+			// default routes overlap everything
+			continue
+		}
+		if link, err := netlink.LinkByIndex(route.LinkIndex); err == nil && link.Attrs().Name == a.Interface {
+			continue
+		}
+		if overlapsPrefix(route.Dst, a.OverlayNet) {
+			conflicts = append(conflicts, route.String())
+		}
+	}
+
+	for _, conflict := range conflicts {
+		logrus.Warnf("overlay network %s overlaps local route %s", a.OverlayNet, conflict)
+	}
+	if a.Strict && len(conflicts) > 0 {
+		return fmt.Errorf("overlay network %s overlaps %d local routes", a.OverlayNet, len(conflicts))
+	}
+	return nil
+}
+
+func overlapsPrefix(ipNet *net.IPNet, prefix netip.Prefix) bool {
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones).Overlaps(prefix)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/costela/wesher/wg"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// maxPersistentKeepalive is the longest keepalive interval wireguard supports.
+const maxPersistentKeepalive = 65535 * time.Second
+
+// peerConfigFlags are the options determining the wireguard peer configuration generated for cluster members. They
+// are shared by the agent and the commands comparing its expected configuration with the live device, so both derive
+// the configuration from the same values.
+type peerConfigFlags struct {
+	EndpointStabilityWindow time.Duration     `env:"WESHER_ENDPOINT_STABILITY_WINDOW" help:"time a peer's changed endpoint must be stable before it is configured, so brief flaps do not reset sessions; 0 applies changes immediately" default:"0"`
+	PersistentKeepalive     time.Duration     `env:"WESHER_PERSISTENT_KEEPALIVE" help:"interval in which keepalive packets are sent to peers, to keep NAT and firewall state alive; 0 disables keepalives" default:"0"`
+	PersistentKeepaliveIPv4 time.Duration     `name:"persistent-keepalive-ipv4" env:"WESHER_PERSISTENT_KEEPALIVE_IPV4" help:"keepalive interval for peers with IPv4 endpoints, overriding --persistent-keepalive" default:"0"`
+	PersistentKeepaliveIPv6 time.Duration     `name:"persistent-keepalive-ipv6" env:"WESHER_PERSISTENT_KEEPALIVE_IPV6" help:"keepalive interval for peers with IPv6 endpoints, overriding --persistent-keepalive" default:"0"`
+	AllowedIPsPolicy        string            `name:"allowed-ips-policy" env:"WESHER_ALLOWED_IPS_POLICY" enum:"overlay-only,private-ranges,full-tunnel" help:"addresses allowed through the tunnel from each peer (overlay-only/private-ranges/full-tunnel)" default:"private-ranges"`
+	DNSResolver             string            `name:"dns-resolver" env:"WESHER_DNS_RESOLVER" help:"DNS server (IP:PORT) to resolve peer endpoint hostnames (e.g. advertised FQDNs) with, instead of the system resolver"`
+	PSKGroup                map[string]string `name:"psk-group" env:"WESHER_PSK_GROUP" help:"preshared key group this node is a member of, as NAME=KEY with a base64 encoded 32 byte key; peers sharing a group use its key in addition to their key pairs; may be repeated (separate with \";\" in the environment)"`
+	ServiceRange            []netip.Prefix    `env:"WESHER_SERVICE_RANGE" help:"comma separated list of networks (CIDR format) outside of the overlay network in which nodes may announce service addresses; must not overlap the overlay network and must be the same across the cluster"`
+
+	pskGroups map[string]wgtypes.Key
+}
+
+func (f *peerConfigFlags) validate() error {
+	f.pskGroups = make(map[string]wgtypes.Key, len(f.PSKGroup))
+	for name, k := range f.PSKGroup {
+		psk, err := wgtypes.ParseKey(k)
+		if err != nil {
+			return fmt.Errorf("unsupported key for PSK group %s: %w", name, err)
+		}
+		f.pskGroups[name] = psk
+	}
+
+	for flag, interval := range map[string]time.Duration{
+		"persistent-keepalive":      f.PersistentKeepalive,
+		"persistent-keepalive-ipv4": f.PersistentKeepaliveIPv4,
+		"persistent-keepalive-ipv6": f.PersistentKeepaliveIPv6,
+	} {
+		// wireguard configures keepalives in whole seconds, up to 65535
+		if interval != 0 && (interval < time.Second || interval > maxPersistentKeepalive) {
+			return fmt.Errorf("unsupported --%s %s; must be 0 or between 1s and %s", flag, interval, maxPersistentKeepalive)
+		}
+	}
+
+	if f.DNSResolver != "" {
+		if _, err := netip.ParseAddrPort(f.DNSResolver); err != nil {
+			return fmt.Errorf("unsupported DNS resolver %q; must be IP:PORT: %w", f.DNSResolver, err)
+		}
+	}
+
+	return nil
+}
+
+// apply sets up wgstate to generate peer configurations according to the flags.
+func (f *peerConfigFlags) apply(wgstate *wg.State) {
+	wgstate.AllowedIPsPolicy = f.AllowedIPsPolicy
+	wgstate.PSKGroups = f.pskGroups
+	wgstate.EndpointStabilityWindow = f.EndpointStabilityWindow
+	wgstate.PersistentKeepalive = f.PersistentKeepalive
+	wgstate.PersistentKeepaliveIPv4 = f.PersistentKeepaliveIPv4
+	wgstate.PersistentKeepaliveIPv6 = f.PersistentKeepaliveIPv6
+	wgstate.ServiceRanges = f.ServiceRange
+	if f.DNSResolver != "" {
+		wg.SetDNSResolver(f.DNSResolver)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_peerConfigFlags_validate_keepalive(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   peerConfigFlags
+		wantErr bool
+	}{
+		{"disabled", peerConfigFlags{}, false},
+		{"seconds", peerConfigFlags{PersistentKeepalive: 25 * time.Second}, false},
+		{"maximum", peerConfigFlags{PersistentKeepaliveIPv4: 65535 * time.Second}, false},
+		{"sub-second", peerConfigFlags{PersistentKeepalive: 500 * time.Millisecond}, true},
+		{"sub-second ipv4", peerConfigFlags{PersistentKeepaliveIPv4: time.Millisecond}, true},
+		{"negative", peerConfigFlags{PersistentKeepalive: -time.Second}, true},
+		{"too long ipv6", peerConfigFlags{PersistentKeepaliveIPv6: 65536 * time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.flags.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/sirupsen/logrus"
+)
+
+type PeersCmd struct {
+	Import  PeersImportCmd  `cmd:"" help:"import peers from a wg-quick configuration file as static peers"`
+	Diff    PeersDiffCmd    `cmd:"" help:"show differences between the peers known to the cluster and those configured in wireguard"`
+	List    PeersListCmd    `cmd:"" help:"list the peers configured in wireguard"`
+	Resolve PeersResolveCmd `cmd:"" help:"print the cluster member names and overlay addresses of public keys"`
+}
+
+type PeersImportCmd struct {
+	File            string `help:"wg-quick configuration file to import peers from" required:"" type:"existingfile"`
+	StaticPeersFile string `env:"WESHER_STATIC_PEERS_FILE" help:"static peers file used by the agent, to which imported peers are added" required:""`
+	WireguardPort   int    `env:"WESHER_WIREGUARD_PORT" help:"port used for wireguard traffic (UDP); must be the same across cluster" default:"51820"`
+	DNSResolver     string `name:"dns-resolver" env:"WESHER_DNS_RESOLVER" help:"DNS server (IP:PORT) to resolve endpoint hostnames with, instead of the system resolver"`
+}
+
+// Run converts the peers found in the wg-quick configuration into static peers, replacing existing static peers with
+// the same public key.
+func (p *PeersImportCmd) Run() error {
+	f, err := os.Open(p.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	quickPeers, err := wg.ParseQuickConfig(f)
+	if err != nil {
+		return fmt.Errorf("parsing %s: %w", p.File, err)
+	}
+
+	nodes, err := loadStaticPeers(p.StaticPeersFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	byPubKey := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		byPubKey[node.PubKey] = i
+	}
+
+	for _, qp := range quickPeers {
+		node, err := p.quickPeerToNode(qp)
+		if err != nil {
+			return fmt.Errorf("importing peer %s: %w", qp.PublicKey, err)
+		}
+		if i, ok := byPubKey[node.PubKey]; ok {
+			nodes[i] = node
+		} else {
+			nodes = append(nodes, node)
+		}
+		logrus.Infof("imported peer %s (%s) with overlay address %s", node.Name, node.PubKey, node.OverlayAddr)
+	}
+
+	return saveStaticPeers(p.StaticPeersFile, nodes)
+}
+
+// PeersDiffCmd is DiffCmd restricted to peers.
+type PeersDiffCmd struct {
+	DiffCmd
+}
+
+// Run compares the cluster members last seen by the running agent (as persisted in its state file) with the peers
+// configured on its wireguard interface and prints every discrepancy.
+// An error is returned if any discrepancy is found.
+func (p *PeersDiffCmd) Run() error {
+	return p.diff((*wg.State).DiffPeers)
+}
+
+type PeersListCmd struct {
+	Interface       string `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	StaticPeersFile string `env:"WESHER_STATIC_PEERS_FILE" help:"static peers file used by the agent, to resolve peer names"`
+	Sort            string `enum:"name,addr,handshake,throughput" help:"field to sort peers by (name/addr/handshake/throughput)" default:"name"`
+	Format          string `enum:"table,json,csv" help:"output format (table/json/csv)" default:"table"`
+}
+
+// peerListEntry is the information shown for each peer by PeersListCmd.
+type peerListEntry struct {
+	Name            string
+	PubKey          string
+	OverlayAddr     string
+	Endpoint        string
+	LatestHandshake time.Time
+	ReceiveBytes    int64
+	TransmitBytes   int64
+}
+
+// Run prints the peers of the running agent's wireguard interface, named after the cluster members they belong to.
+func (p *PeersListCmd) Run() error {
+	nodes, err := knownNodes(p.Interface, p.StaticPeersFile)
+	if err != nil {
+		return err
+	}
+	byPubKey := make(map[string]common.Node, len(nodes))
+	for _, node := range nodes {
+		byPubKey[node.PubKey] = node
+	}
+
+	wgstate, err := wg.Open(p.Interface, 0)
+	if err != nil {
+		return err
+	}
+	device, err := wgstate.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	entries := make([]peerListEntry, len(device.Peers))
+	for i, peer := range device.Peers {
+		entry := peerListEntry{
+			PubKey:          peer.PublicKey.String(),
+			LatestHandshake: peer.LastHandshakeTime,
+			ReceiveBytes:    peer.ReceiveBytes,
+			TransmitBytes:   peer.TransmitBytes,
+		}
+		if peer.Endpoint != nil {
+			entry.Endpoint = peer.Endpoint.String()
+		}
+		if node, ok := byPubKey[entry.PubKey]; ok {
+			entry.Name = node.Name
+			entry.OverlayAddr = node.OverlayAddr.String()
+		} else if len(peer.AllowedIPs) > 0 {
+			entry.OverlayAddr = peer.AllowedIPs[0].IP.String()
+		}
+		entries[i] = entry
+	}
+	sortPeerList(entries, p.Sort)
+
+	return writePeerList(os.Stdout, entries, p.Format)
+}
+
+type PeersResolveCmd struct {
+	PubKeys         []string `arg:"" name:"pubkey" help:"public keys to resolve"`
+	Interface       string   `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+	StaticPeersFile string   `env:"WESHER_STATIC_PEERS_FILE" help:"static peers file used by the agent, to also resolve static peers"`
+}
+
+// peerResolution is the information printed for each public key by PeersResolveCmd; all but PubKey are empty for
+// unknown keys.
+type peerResolution struct {
+	PubKey      string `json:"pubkey"`
+	Hostname    string `json:"hostname,omitempty"`
+	OverlayAddr string `json:"overlay_addr,omitempty"`
+	Endpoint    string `json:"endpoint,omitempty"`
+}
+
+// Run prints the name and overlay address of the cluster members (as known to the running agent) with the given public
+// keys as JSON, along with their endpoints as configured on the wireguard interface, if any.
+// An error is returned if any key is unknown.
+func (p *PeersResolveCmd) Run() error {
+	nodes, err := knownNodes(p.Interface, p.StaticPeersFile)
+	if err != nil {
+		return err
+	}
+	endpoints := map[string]string{}
+	if wgstate, err := wg.Open(p.Interface, 0); err != nil {
+		logrus.WithError(err).Debug("not resolving endpoints")
+	} else if device, err := wgstate.GetConfig(); err != nil {
+		logrus.WithError(err).Debug("not resolving endpoints")
+	} else {
+		for _, peer := range device.Peers {
+			if peer.Endpoint != nil {
+				endpoints[peer.PublicKey.String()] = peer.Endpoint.String()
+			}
+		}
+	}
+
+	resolved, missing := resolvePeers(p.PubKeys, nodes, endpoints)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(resolved); err != nil {
+		return err
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d of %d public keys not found", missing, len(p.PubKeys))
+	}
+	return nil
+}
+
+// resolvePeers returns the resolution of each public key in pubKeys and how many of them are not known.
+func resolvePeers(pubKeys []string, nodes []common.Node, endpoints map[string]string) ([]peerResolution, int) {
+	byPubKey := make(map[string]common.Node, len(nodes))
+	for _, node := range nodes {
+		byPubKey[node.PubKey] = node
+	}
+	resolved := make([]peerResolution, len(pubKeys))
+	missing := 0
+	for i, pubKey := range pubKeys {
+		resolved[i] = peerResolution{PubKey: pubKey}
+		node, ok := byPubKey[pubKey]
+		if !ok {
+			missing++
+			continue
+		}
+		resolved[i].Hostname = node.Name
+		resolved[i].OverlayAddr = node.OverlayAddr.String()
+		resolved[i].Endpoint = endpoints[pubKey]
+	}
+	return resolved, missing
+}
+
+// sortPeerList sorts entries by the given field. Handshakes and throughput are sorted in descending order, to show the
+// most active peers first.
+func sortPeerList(entries []peerListEntry, field string) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch field {
+		case "addr":
+			addrA, _ := netip.ParseAddr(a.OverlayAddr)
+			addrB, _ := netip.ParseAddr(b.OverlayAddr)
+			return addrA.Less(addrB)
+		case "handshake":
+			return a.LatestHandshake.After(b.LatestHandshake)
+		case "throughput":
+			return a.ReceiveBytes+a.TransmitBytes > b.ReceiveBytes+b.TransmitBytes
+		default:
+			return a.Name < b.Name
+		}
+	})
+}
+
+func writePeerList(w io.Writer, entries []peerListEntry, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	rows := [][]string{{"NAME", "PUBKEY", "OVERLAY", "ENDPOINT", "HANDSHAKE", "RX", "TX"}}
+	for _, e := range entries {
+		handshake := ""
+		if !e.LatestHandshake.IsZero() {
+			handshake = e.LatestHandshake.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			e.Name, e.PubKey, e.OverlayAddr, e.Endpoint, handshake,
+			strconv.FormatInt(e.ReceiveBytes, 10), strconv.FormatInt(e.TransmitBytes, 10),
+		})
+	}
+
+	if format == "csv" {
+		cw := csv.NewWriter(w)
+		if err := cw.WriteAll(rows); err != nil {
+			return fmt.Errorf("writing csv: %w", err)
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func (p *PeersImportCmd) quickPeerToNode(qp wg.QuickPeer) (common.Node, error) {
+	node := common.Node{
+		Name: "peer-" + hex.EncodeToString(qp.PublicKey[:4]),
+	}
+	node.PubKey = qp.PublicKey.String()
+
+	for _, prefix := range qp.AllowedIPs {
+		if prefix.IsSingleIP() && !node.OverlayAddr.IsValid() {
+			node.OverlayAddr = prefix.Addr()
+		} else {
+			logrus.Warnf("peer %s: ignoring allowed IPs %s; only a single overlay address is supported", node.Name, prefix)
+		}
+	}
+	if !node.OverlayAddr.IsValid() {
+		return node, fmt.Errorf("no single address found in allowed IPs")
+	}
+
+	if qp.Endpoint == "" {
+		return node, fmt.Errorf("no endpoint")
+	}
+	host, portStr, err := net.SplitHostPort(qp.Endpoint)
+	if err != nil {
+		return node, fmt.Errorf("parsing endpoint: %w", err)
+	}
+	if port, err := strconv.Atoi(portStr); err != nil || port != p.WireguardPort {
+		logrus.Warnf("peer %s: endpoint port %s differs from wireguard port %d, which will be used instead", node.Name, portStr, p.WireguardPort)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		node.Addr = addr.AsSlice()
+	} else {
+		resolver := net.DefaultResolver
+		if p.DNSResolver != "" {
+			resolver = wg.DNSResolver(p.DNSResolver)
+		}
+		ips, err := resolver.LookupIP(context.Background(), "ip", host)
+		if err != nil || len(ips) == 0 {
+			return node, fmt.Errorf("resolving endpoint %s: %w", host, err)
+		}
+		node.Addr = ips[0]
+	}
+
+	return node, nil
+}
+
+// loadStaticPeers reads the static peers configured outside of cluster membership.
+func loadStaticPeers(staticPeersFile string) ([]common.Node, error) {
+	content, err := os.ReadFile(staticPeersFile)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []common.Node
+	if err := json.Unmarshal(content, &nodes); err != nil {
+		return nil, fmt.Errorf("decoding static peers: %w", err)
+	}
+	return nodes, nil
+}
+
+// staticPeerSource caches the static peers read by the agent, so the file is only read again once it changed or on
+// SIGHUP.
+type staticPeerSource struct {
+	path    string
+	loaded  bool
+	modTime time.Time
+	size    int64
+	lastErr string
+	nodes   []common.Node
+}
+
+// Nodes returns the static peers, re-reading the file if its modification time or size changed since it was last read.
+func (s *staticPeerSource) Nodes() []common.Node {
+	s.refresh(false)
+	return s.nodes
+}
+
+// refresh re-reads the static peers file if it changed since it was last read, or unconditionally with force, and
+// returns whether the peers changed. If the file cannot be read, the previously read peers are kept and the error is
+// only logged when it differs from the last one.
+func (s *staticPeerSource) refresh(force bool) bool {
+	info, err := os.Stat(s.path)
+	if err == nil && !force && s.loaded && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false
+	}
+	var nodes []common.Node
+	if err == nil {
+		nodes, err = loadStaticPeers(s.path)
+	}
+	if err != nil {
+		if force || err.Error() != s.lastErr {
+			logrus.WithError(err).Error("could not load static peers")
+		}
+		s.lastErr = err.Error()
+		return false
+	}
+	s.lastErr = ""
+	s.loaded, s.modTime, s.size = true, info.ModTime(), info.Size()
+	changed := !reflect.DeepEqual(nodes, s.nodes)
+	s.nodes = nodes
+	return changed
+}
+
+func saveStaticPeers(staticPeersFile string, nodes []common.Node) error {
+	if err := os.MkdirAll(path.Dir(staticPeersFile), 0o700); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(staticPeersFile, content, 0o600)
+}
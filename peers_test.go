@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_staticPeerSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "static-peers.json")
+	write := func(nodes []common.Node, modTime time.Time) {
+		require.NoError(t, saveStaticPeers(file, nodes))
+		require.NoError(t, os.Chtimes(file, modTime, modTime))
+	}
+	first := time.Now().Add(-time.Hour)
+	write([]common.Node{{Name: "a"}}, first)
+
+	source := &staticPeerSource{path: file}
+	assert.Equal(t, []common.Node{{Name: "a"}}, source.Nodes())
+
+	// same size and modification time: the cached peers are kept
+	write([]common.Node{{Name: "b"}}, first)
+	assert.Equal(t, []common.Node{{Name: "a"}}, source.Nodes())
+	assert.True(t, source.refresh(true), "forced refreshes must re-read the file")
+	assert.Equal(t, []common.Node{{Name: "b"}}, source.Nodes())
+	assert.False(t, source.refresh(true), "unchanged peers must not be reported as changed")
+
+	write([]common.Node{{Name: "c"}}, first.Add(time.Minute))
+	assert.Equal(t, []common.Node{{Name: "c"}}, source.Nodes())
+
+	require.NoError(t, os.WriteFile(file, []byte("not json"), 0o600))
+	assert.Equal(t, []common.Node{{Name: "c"}}, source.Nodes(), "unreadable files must keep the previous peers")
+	require.NoError(t, os.Remove(file))
+	assert.Equal(t, []common.Node{{Name: "c"}}, source.Nodes())
+}
+
+func Test_sortPeerList(t *testing.T) {
+	now := time.Now()
+	entries := []peerListEntry{
+		{Name: "b", OverlayAddr: "10.0.0.10", LatestHandshake: now.Add(-time.Minute), ReceiveBytes: 10, TransmitBytes: 10},
+		{Name: "c", OverlayAddr: "10.0.0.2", LatestHandshake: now, ReceiveBytes: 1},
+		{Name: "a", OverlayAddr: "10.0.0.9", ReceiveBytes: 5, TransmitBytes: 10},
+	}
+	tests := []struct {
+		field string
+		want  []string
+	}{
+		{"name", []string{"a", "b", "c"}},
+		{"addr", []string{"c", "a", "b"}},
+		{"handshake", []string{"c", "b", "a"}},
+		{"throughput", []string{"b", "a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			sorted := append([]peerListEntry(nil), entries...)
+			sortPeerList(sorted, tt.field)
+			names := make([]string, 0, len(sorted))
+			for _, e := range sorted {
+				names = append(names, e.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+}
+
+func Test_writePeerList(t *testing.T) {
+	handshake := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []peerListEntry{
+		{Name: "a", PubKey: "key-a", OverlayAddr: "10.0.0.1", Endpoint: "192.0.2.1:51820", LatestHandshake: handshake, ReceiveBytes: 1, TransmitBytes: 2},
+		{Name: "b", PubKey: "key-b", OverlayAddr: "10.0.0.2"},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"table", "" +
+			"NAME  PUBKEY  OVERLAY   ENDPOINT         HANDSHAKE             RX  TX\n" +
+			"a     key-a   10.0.0.1  192.0.2.1:51820  2020-01-02T03:04:05Z  1   2\n" +
+			"b     key-b   10.0.0.2                                         0   0\n"},
+		{"csv", "" +
+			"NAME,PUBKEY,OVERLAY,ENDPOINT,HANDSHAKE,RX,TX\n" +
+			"a,key-a,10.0.0.1,192.0.2.1:51820,2020-01-02T03:04:05Z,1,2\n" +
+			"b,key-b,10.0.0.2,,,0,0\n"},
+		{"json", `[
+  {
+    "Name": "a",
+    "PubKey": "key-a",
+    "OverlayAddr": "10.0.0.1",
+    "Endpoint": "192.0.2.1:51820",
+    "LatestHandshake": "2020-01-02T03:04:05Z",
+    "ReceiveBytes": 1,
+    "TransmitBytes": 2
+  },
+  {
+    "Name": "b",
+    "PubKey": "key-b",
+    "OverlayAddr": "10.0.0.2",
+    "Endpoint": "",
+    "LatestHandshake": "0001-01-01T00:00:00Z",
+    "ReceiveBytes": 0,
+    "TransmitBytes": 0
+  }
+]
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			require.NoError(t, writePeerList(&out, entries, tt.format))
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func Test_resolvePeers(t *testing.T) {
+	node := func(name, pubKey, addr string) common.Node {
+		n := common.Node{Name: name}
+		n.PubKey = pubKey
+		n.OverlayAddr = netip.MustParseAddr(addr)
+		return n
+	}
+	nodes := []common.Node{node("node1", "key1", "10.0.0.1"), node("node2", "key2", "10.0.0.2")}
+	endpoints := map[string]string{"key1": "192.0.2.1:51820", "unknown": "192.0.2.9:51820"}
+
+	tests := []struct {
+		name        string
+		pubKeys     []string
+		want        []peerResolution
+		wantMissing int
+	}{
+		{
+			name:    "known peers",
+			pubKeys: []string{"key2", "key1"},
+			want: []peerResolution{
+				{PubKey: "key2", Hostname: "node2", OverlayAddr: "10.0.0.2"},
+				{PubKey: "key1", Hostname: "node1", OverlayAddr: "10.0.0.1", Endpoint: "192.0.2.1:51820"},
+			},
+		},
+		{
+			name:        "unknown peers are counted, without endpoint",
+			pubKeys:     []string{"key1", "unknown"},
+			want:        []peerResolution{{PubKey: "key1", Hostname: "node1", OverlayAddr: "10.0.0.1", Endpoint: "192.0.2.1:51820"}, {PubKey: "unknown"}},
+			wantMissing: 1,
+		},
+		{
+			name:    "no keys",
+			pubKeys: nil,
+			want:    []peerResolution{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, missing := resolvePeers(tt.pubKeys, nodes, endpoints)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantMissing, missing)
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package proxy implements version 2 of the HAProxy PROXY protocol for TCP connections, which conveys the original
+// source and destination addresses of a connection through proxies and load balancers.
+// See https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// signature starts every version 2 header.
+var signature = []byte{0x0d, 0x0a, 0x0d, 0x0a, 0x00, 0x0d, 0x0a, 0x51, 0x55, 0x49, 0x54, 0x0a}
+
+const (
+	headerLen = 16
+
+	versionCommandLocal = 0x20
+	versionCommandProxy = 0x21
+
+	familyTCP4 = 0x11
+	familyTCP6 = 0x21
+)
+
+// ErrNoHeader is returned by ReadHeader if the stream does not start with a PROXY protocol header.
+var ErrNoHeader = errors.New("no PROXY protocol header")
+
+// WriteHeader writes a PROXY command header for a TCP connection from src to dst. Both must be *net.TCPAddr of the
+// same address family.
+func WriteHeader(w io.Writer, src, dst net.Addr) error {
+	srcTCP, ok := src.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unsupported source address %s", src)
+	}
+	dstTCP, ok := dst.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("unsupported destination address %s", dst)
+	}
+
+	header := append([]byte{}, signature...)
+	var addrs []byte
+	if src4, dst4 := srcTCP.IP.To4(), dstTCP.IP.To4(); src4 != nil && dst4 != nil {
+		header = append(header, versionCommandProxy, familyTCP4)
+		addrs = append(append(addrs, src4...), dst4...)
+	} else if src4 == nil && dst4 == nil {
+		header = append(header, versionCommandProxy, familyTCP6)
+		addrs = append(append(addrs, srcTCP.IP.To16()...), dstTCP.IP.To16()...)
+	} else {
+		return fmt.Errorf("mismatching address families of %s and %s", src, dst)
+	}
+	addrs = appendUint16(addrs, uint16(srcTCP.Port))
+	addrs = appendUint16(addrs, uint16(dstTCP.Port))
+	header = appendUint16(header, uint16(len(addrs)))
+
+	_, err := w.Write(append(header, addrs...))
+	return err
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// ReadHeader reads a PROXY protocol header from r, returning the conveyed addresses. For LOCAL headers (e.g. health
+// checks of the proxy itself), nil addresses are returned. If r does not start with a header, ErrNoHeader is returned
+// and nothing is consumed.
+func ReadHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	// compare byte by byte, so short messages without header are not waited for
+	for n := 1; n <= len(signature); n++ {
+		start, err := r.Peek(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		if start[n-1] != signature[n-1] {
+			return nil, nil, ErrNoHeader
+		}
+	}
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch header[12] {
+	case versionCommandLocal:
+		return nil, nil, nil
+	case versionCommandProxy:
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version/command 0x%02x", header[12])
+	}
+
+	var ipLen int
+	switch header[13] {
+	case familyTCP4:
+		ipLen = net.IPv4len
+	case familyTCP6:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol address family 0x%02x", header[13])
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("short PROXY protocol address block")
+	}
+	src = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return src, dst, nil
+}
+
+// Conn is a connection whose remote address was conveyed by a PROXY protocol header.
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+// Accept reads the PROXY protocol header of conn, if any, waiting at most timeout for it. The returned connection
+// reports the conveyed source address as remote address; connections without header are returned unchanged.
+func Accept(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	src, _, err := ReadHeader(r)
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	switch {
+	case errors.Is(err, ErrNoHeader):
+		src = conn.RemoteAddr()
+	case err != nil:
+		return nil, fmt.Errorf("reading PROXY protocol header: %w", err)
+	case src == nil:
+		src = conn.RemoteAddr()
+	}
+	return &Conn{Conn: conn, r: r, remote: src}, nil
+}
+
+// Read reads from the connection, after the PROXY protocol header.
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the source address conveyed by the PROXY protocol header.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
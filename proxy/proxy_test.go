@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Header_roundtrip(t *testing.T) {
+	for _, tt := range []struct{ src, dst string }{
+		{"192.0.2.1:1234", "198.51.100.1:7946"},
+		{"[2001:db8::1]:1234", "[2001:db8::2]:7946"},
+	} {
+		t.Run(tt.src, func(t *testing.T) {
+			src, err := net.ResolveTCPAddr("tcp", tt.src)
+			require.NoError(t, err)
+			dst, err := net.ResolveTCPAddr("tcp", tt.dst)
+			require.NoError(t, err)
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, WriteHeader(buf, src, dst))
+			buf.WriteString("payload")
+
+			r := bufio.NewReader(buf)
+			gotSrc, gotDst, err := ReadHeader(r)
+			require.NoError(t, err)
+			assert.Equal(t, src.String(), gotSrc.String())
+			assert.Equal(t, dst.String(), gotDst.String())
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "payload", string(rest))
+		})
+	}
+}
+
+func Test_WriteHeader_mismatching_families(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 2}
+	assert.Error(t, WriteHeader(io.Discard, src, dst))
+	assert.Error(t, WriteHeader(io.Discard, &net.UDPAddr{}, dst))
+}
+
+func Test_ReadHeader_none(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader([]byte("\x0d\x0aGET")))
+	_, _, err := ReadHeader(r)
+	assert.ErrorIs(t, err, ErrNoHeader)
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "\x0d\x0aGET", string(rest), "nothing must be consumed")
+}
+
+func Test_ReadHeader_local(t *testing.T) {
+	header := append(append([]byte{}, signature...), versionCommandLocal, 0, 0, 0)
+	src, dst, err := ReadHeader(bufio.NewReader(bytes.NewReader(header)))
+	require.NoError(t, err)
+	assert.Nil(t, src)
+	assert.Nil(t, dst)
+}
+
+func Test_Accept(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 7946}
+	go func() {
+		WriteHeader(client, src, dst) // nolint: errcheck // checked by the reader
+		client.Write([]byte("hello")) // nolint: errcheck // checked by the reader
+	}()
+
+	conn, err := Accept(server, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, src.String(), conn.RemoteAddr().String())
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/kong"
+	"github.com/costela/wesher/wg"
+)
+
+// reloadableConfig holds the --config options applied to the running interface on SIGHUP.
+type reloadableConfig struct {
+	MTU *int `json:"mtu"`
+}
+
+// reload re-reads the MTU from the --config file and the AWS Parameter Store, in that order, and applies a changed value
+// to the running interface, without recreating it. With --wg-mtu-overhead, the MTU is derived from the underlay
+// interface again instead, e.g. after its MTU changed.
+func (a *AgentCmd) reload(wgstate *wg.State) error {
+	mtu := a.MTU
+	if a.Config != "" {
+		f, err := os.Open(kong.ExpandPath(string(a.Config)))
+		if err != nil {
+			return fmt.Errorf("opening config file: %w", err)
+		}
+		defer f.Close()
+		var cfg reloadableConfig
+		if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", a.Config, err)
+		}
+		if cfg.MTU != nil {
+			mtu = *cfg.MTU
+		}
+	}
+	if a.AWSParamPrefix != "" {
+		var err error
+		if mtu, err = a.reloadAWSParams(mtu); err != nil {
+			return err
+		}
+	}
+	if a.WgMTUOverhead != "" {
+		var err error
+		if mtu, err = a.overlayMTU(); err != nil {
+			return fmt.Errorf("computing MTU: %w", err)
+		}
+	}
+	if err := wgstate.Reload(mtu); err != nil {
+		return err
+	}
+	a.MTU = mtu
+	return nil
+}
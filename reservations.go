@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/costela/wesher/common"
+)
+
+// reservation maps a node to the overlay address assigned to it.
+type reservation struct {
+	Name        string `json:"name"`
+	PubKey      string `json:"pubkey"`
+	OverlayAddr string `json:"overlay_addr"`
+}
+
+// reservationsWriter exports the overlay prefix and the addresses assigned to nodes to a file, for consumption by
+// external IPAM/DHCP systems.
+type reservationsWriter struct {
+	path   string
+	format string
+	prefix string
+	last   []byte
+}
+
+// Write writes the reservations for the given nodes, replacing the file atomically if its content changed.
+func (r *reservationsWriter) Write(nodes []common.Node) error {
+	entries := make([]reservation, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.OverlayAddr.IsValid() {
+			continue
+		}
+		entries = append(entries, reservation{
+			Name:        node.Name,
+			PubKey:      node.PubKey,
+			OverlayAddr: node.OverlayAddr.String(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	content, err := r.encode(entries)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, r.last) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path))
+	if err != nil {
+		return fmt.Errorf("creating temporary reservations file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing temporary reservations file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("setting reservations file permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing temporary reservations file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.path); err != nil {
+		return fmt.Errorf("replacing reservations file: %w", err)
+	}
+	r.last = content
+	return nil
+}
+
+func (r *reservationsWriter) encode(entries []reservation) ([]byte, error) {
+	var buf bytes.Buffer
+	switch r.format {
+	case "json":
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			OverlayNet   string        `json:"overlay_net"`
+			Reservations []reservation `json:"reservations"`
+		}{r.prefix, entries}); err != nil {
+			return nil, fmt.Errorf("encoding reservations: %w", err)
+		}
+	case "csv":
+		cw := csv.NewWriter(&buf)
+		rows := [][]string{{"NAME", "PUBKEY", "OVERLAY"}}
+		for _, e := range entries {
+			rows = append(rows, []string{e.Name, e.PubKey, e.OverlayAddr})
+		}
+		if err := cw.WriteAll(rows); err != nil {
+			return nil, fmt.Errorf("encoding reservations: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported reservations format %q", r.format)
+	}
+	return buf.Bytes(), nil
+}
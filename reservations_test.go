@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_reservationsWriter_Write(t *testing.T) {
+	node := func(name, pubKey, addr string) common.Node {
+		n := common.Node{Name: name}
+		n.PubKey = pubKey
+		if addr != "" {
+			n.OverlayAddr = netip.MustParseAddr(addr)
+		}
+		return n
+	}
+	nodes := []common.Node{
+		node("node2", "key2", "10.0.0.2"),
+		node("node1", "key1", "10.0.0.1"),
+		node("joining", "key3", ""),
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"csv", "" +
+			"NAME,PUBKEY,OVERLAY\n" +
+			"node1,key1,10.0.0.1\n" +
+			"node2,key2,10.0.0.2\n"},
+		{"json", `{
+  "overlay_net": "10.0.0.0/8",
+  "reservations": [
+    {
+      "name": "node1",
+      "pubkey": "key1",
+      "overlay_addr": "10.0.0.1"
+    },
+    {
+      "name": "node2",
+      "pubkey": "key2",
+      "overlay_addr": "10.0.0.2"
+    }
+  ]
+}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "reservations")
+			w := &reservationsWriter{path: path, format: tt.format, prefix: "10.0.0.0/8"}
+			require.NoError(t, w.Write(nodes))
+
+			content, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(content))
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+		})
+	}
+}
+
+func Test_reservationsWriter_Write_unchanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reservations.csv")
+	w := &reservationsWriter{path: path, format: "csv", prefix: "10.0.0.0/8"}
+	node := common.Node{Name: "node1"}
+	node.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+
+	require.NoError(t, w.Write([]common.Node{node}))
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, w.Write([]common.Node{node}))
+	assert.NoFileExists(t, path, "unchanged reservations must not be written again")
+
+	node.OverlayAddr = netip.MustParseAddr("10.0.0.2")
+	require.NoError(t, w.Write([]common.Node{node}))
+	assert.FileExists(t, path)
+}
+
+func Test_reservationsWriter_Write_unsupportedFormat(t *testing.T) {
+	w := &reservationsWriter{path: filepath.Join(t.TempDir(), "reservations"), format: "xml"}
+	assert.Error(t, w.Write(nil))
+}
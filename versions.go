@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/costela/wesher/cluster"
+	"github.com/costela/wesher/common"
+	"github.com/sirupsen/logrus"
+)
+
+type VersionsCmd struct {
+	Interface string `env:"WESHER_INTERFACE" help:"name of the wireguard interface managed by the running agent" default:"wgoverlay"`
+}
+
+// Run prints the wesher and wireguard versions of all cluster nodes, as gossiped by each node, followed by the number
+// of nodes per version, e.g. to follow the progress of rolling upgrades. The local node's version is the one the
+// running agent gossiped last, read from its state file.
+func (v *VersionsCmd) Run() error {
+	nodes, err := knownNodes(v.Interface, "")
+	if err != nil {
+		return err
+	}
+	if local, ok := cluster.KnownLocalNode(v.Interface); !ok {
+		logrus.Warnf("the state of the agent running on %s does not hold the local node yet; omitting it", v.Interface)
+	} else if err := local.DecodeMeta(); err != nil {
+		logrus.Warnf("local node %s: could not decode metadata: %s; omitting it", local.Name, err)
+	} else {
+		nodes = append([]common.Node{local}, nodes...)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tVERSION")
+	counts := map[string]int{}
+	for _, node := range nodes {
+		counts[nodeVersion(node)]++
+		fmt.Fprintf(tw, "%s\t%s\n", node.Name, nodeVersion(node))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	versions := make([]string, 0, len(counts))
+	for version := range counts {
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+	fmt.Println()
+	for _, version := range versions {
+		fmt.Printf("%d/%d nodes running %s\n", counts[version], len(nodes), version)
+	}
+	return nil
+}
+
+// nodeVersion returns the version reported by node, or "unknown" for nodes not reporting it.
+func nodeVersion(node common.Node) string {
+	if node.Version == "" {
+		return "unknown"
+	}
+	return node.Version
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/sirupsen/logrus"
+)
+
+// Recovery steps of the isolation watchdog, in order of escalation.
+type recoveryStep int
+
+const (
+	recoveryNone recoveryStep = iota
+	// recoveryReconfigure re-applies the interface configuration.
+	recoveryReconfigure
+	// recoveryRecreate deletes and recreates the interface.
+	recoveryRecreate
+	// recoveryRejoin re-joins the cluster.
+	recoveryRejoin
+)
+
+func (s recoveryStep) String() string {
+	switch s {
+	case recoveryReconfigure:
+		return "reconfigure interface"
+	case recoveryRecreate:
+		return "recreate interface"
+	case recoveryRejoin:
+		return "rejoin cluster"
+	default:
+		return "none"
+	}
+}
+
+// isolationWatchdog detects the node being isolated, i.e. having peers configured but no handshake with any of them,
+// and escalates recovery steps each time isolation persisted for another threshold.
+type isolationWatchdog struct {
+	threshold time.Duration
+
+	isolatedSince time.Time
+	lastStep      recoveryStep
+	lastStepAt    time.Time
+	// recoveries counts the recovery steps taken, by step
+	recoveries map[recoveryStep]int
+}
+
+func newIsolationWatchdog(threshold time.Duration) *isolationWatchdog {
+	return &isolationWatchdog{
+		threshold:  threshold,
+		recoveries: make(map[recoveryStep]int),
+	}
+}
+
+// check records the current peer health and returns the recovery step to take, if any.
+func (w *isolationWatchdog) check(summary wg.HealthSummary, now time.Time) recoveryStep {
+	if summary.TotalPeers == 0 || summary.HealthyPeers > 0 {
+		if !w.isolatedSince.IsZero() && w.lastStep != recoveryNone {
+			logrus.Infof("connectivity restored after %s, following recovery step %q", now.Sub(w.isolatedSince).Round(time.Second), w.lastStep)
+		}
+		w.isolatedSince = time.Time{}
+		w.lastStep = recoveryNone
+		return recoveryNone
+	}
+	if w.isolatedSince.IsZero() {
+		w.isolatedSince = now
+		w.lastStepAt = now
+	}
+	if now.Sub(w.lastStepAt) < w.threshold {
+		return recoveryNone
+	}
+	// the last step is repeated if escalation is exhausted
+	if w.lastStep < recoveryRejoin {
+		w.lastStep++
+	}
+	w.lastStepAt = now
+	w.recoveries[w.lastStep]++
+	logrus.Warnf("no handshake with any of %d peers for %s, recovering: %s (step taken %d times so far)", summary.TotalPeers, now.Sub(w.isolatedSince).Round(time.Second), w.lastStep, w.recoveries[w.lastStep])
+	return w.lastStep
+}
+
+// rejoinAddrs returns the addresses to rejoin the cluster through: the configured join addresses or, lacking those, the
+// gossip addresses of the last known cluster members.
+func rejoinAddrs(join []string, known []common.Node) []string {
+	if len(join) > 0 {
+		return join
+	}
+	addrs := make([]string, 0, len(known))
+	for _, node := range known {
+		if node.Addr != nil {
+			addrs = append(addrs, node.Addr.String())
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/costela/wesher/wg"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isolationWatchdog_check(t *testing.T) {
+	isolated := wg.HealthSummary{TotalPeers: 2}
+	healthy := wg.HealthSummary{TotalPeers: 2, HealthyPeers: 1}
+	alone := wg.HealthSummary{}
+
+	type check struct {
+		after   time.Duration
+		summary wg.HealthSummary
+		want    recoveryStep
+	}
+	tests := []struct {
+		name   string
+		checks []check
+	}{
+		{
+			name: "healthy",
+			checks: []check{
+				{0, healthy, recoveryNone},
+				{2 * time.Minute, healthy, recoveryNone},
+			},
+		},
+		{
+			name: "no peers is not isolation",
+			checks: []check{
+				{0, alone, recoveryNone},
+				{2 * time.Minute, alone, recoveryNone},
+			},
+		},
+		{
+			name: "escalation",
+			checks: []check{
+				{0, isolated, recoveryNone},
+				{30 * time.Second, isolated, recoveryNone},
+				{time.Minute, isolated, recoveryReconfigure},
+				{90 * time.Second, isolated, recoveryNone},
+				{2 * time.Minute, isolated, recoveryRecreate},
+				{3 * time.Minute, isolated, recoveryRejoin},
+				{4 * time.Minute, isolated, recoveryRejoin},
+			},
+		},
+		{
+			name: "recovery resets escalation",
+			checks: []check{
+				{0, isolated, recoveryNone},
+				{time.Minute, isolated, recoveryReconfigure},
+				{90 * time.Second, healthy, recoveryNone},
+				{2 * time.Minute, isolated, recoveryNone},
+				{3 * time.Minute, isolated, recoveryReconfigure},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := newIsolationWatchdog(time.Minute)
+			start := time.Now()
+			for _, c := range tt.checks {
+				assert.Equal(t, c.want, w.check(c.summary, start.Add(c.after)), "after %s", c.after)
+			}
+		})
+	}
+}
+
+func Test_rejoinAddrs(t *testing.T) {
+	known := []common.Node{{Name: "a", Addr: net.IPv4(192, 0, 2, 1)}, {Name: "b"}, {Name: "c", Addr: net.IPv4(192, 0, 2, 3)}}
+
+	assert.Equal(t, []string{"join.example.com"}, rejoinAddrs([]string{"join.example.com"}, known), "join addresses take precedence")
+	assert.Equal(t, []string{"192.0.2.1", "192.0.2.3"}, rejoinAddrs(nil, known))
+	assert.Empty(t, rejoinAddrs(nil, nil))
+}
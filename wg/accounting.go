@@ -0,0 +1,99 @@
+package wg
+
+import (
+	"fmt"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Labels identifying peers in usage reports.
+const (
+	UsageLabelPubKey = "pubkey"
+	UsageLabelName   = "name"
+)
+
+// PeerUsage holds the traffic of a single peer since the previous accounting period.
+type PeerUsage struct {
+	PubKey        string `json:",omitempty"`
+	Name          string `json:",omitempty"`
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+type byteCounters struct {
+	receive, transmit int64
+}
+
+// UsageTracker computes per-peer traffic deltas from the cumulative counters reported by the wireguard device.
+// The zero value is ready to use; the first call to Deltas reports the full counters.
+type UsageTracker struct {
+	last map[wgtypes.Key]byteCounters
+}
+
+// Deltas returns the traffic of each peer since the previous call.
+// Counters lower than previously seen are treated as reset (e.g. because the peer was re-added), in which case the
+// current value is reported in full.
+func (t *UsageTracker) Deltas(peers []wgtypes.Peer) []PeerUsage {
+	current := make(map[wgtypes.Key]byteCounters, len(peers))
+	usage := make([]PeerUsage, 0, len(peers))
+	for _, peer := range peers {
+		counters := byteCounters{receive: peer.ReceiveBytes, transmit: peer.TransmitBytes}
+		current[peer.PublicKey] = counters
+
+		last := t.last[peer.PublicKey]
+		usage = append(usage, PeerUsage{
+			PubKey:        peer.PublicKey.String(),
+			ReceiveBytes:  counterDelta(last.receive, counters.receive),
+			TransmitBytes: counterDelta(last.transmit, counters.transmit),
+		})
+	}
+	t.last = current // forget peers which are gone
+	return usage
+}
+
+func counterDelta(last, current int64) int64 {
+	if current < last {
+		return current // counter was reset
+	}
+	return current - last
+}
+
+// ValidateUsageLabels returns an error for labels other than the UsageLabel* constants.
+func ValidateUsageLabels(labels []string) error {
+	for _, label := range labels {
+		if label != UsageLabelPubKey && label != UsageLabelName {
+			return fmt.Errorf("unsupported usage label %q", label)
+		}
+	}
+	return nil
+}
+
+// GroupUsage keeps only the given labels of each entry and sums the traffic of entries left with the same labels, in
+// order of first appearance. Without labels, the total traffic of all peers is returned as a single entry.
+func GroupUsage(usage []PeerUsage, labels []string) []PeerUsage {
+	var keepPubKey, keepName bool
+	for _, label := range labels {
+		keepPubKey = keepPubKey || label == UsageLabelPubKey
+		keepName = keepName || label == UsageLabelName
+	}
+	grouped := make([]PeerUsage, 0, len(usage))
+	index := make(map[PeerUsage]int, len(usage))
+	for _, u := range usage {
+		key := PeerUsage{}
+		if keepPubKey {
+			key.PubKey = u.PubKey
+		}
+		if keepName {
+			key.Name = u.Name
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(grouped)
+			index[key] = i
+			grouped = append(grouped, key)
+		}
+		grouped[i].ReceiveBytes += u.ReceiveBytes
+		grouped[i].TransmitBytes += u.TransmitBytes
+	}
+	return grouped
+}
@@ -0,0 +1,73 @@
+package wg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_UsageTracker_Deltas(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	pubKey := key.PublicKey()
+
+	tracker := &UsageTracker{}
+	tests := []struct {
+		name                string
+		receive, transmit   int64
+		wantRecv, wantTrans int64
+	}{
+		{"first period reports full counters", 100, 50, 100, 50},
+		{"increase", 150, 80, 50, 30},
+		{"no traffic", 150, 80, 0, 0},
+		{"reset", 20, 10, 20, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := tracker.Deltas([]wgtypes.Peer{{PublicKey: pubKey, ReceiveBytes: tt.receive, TransmitBytes: tt.transmit}})
+			require.Len(t, usage, 1)
+			assert.Equal(t, tt.wantRecv, usage[0].ReceiveBytes)
+			assert.Equal(t, tt.wantTrans, usage[0].TransmitBytes)
+		})
+	}
+}
+
+func Test_UsageTracker_Deltas_removed_peer(t *testing.T) {
+	key, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peer := wgtypes.Peer{PublicKey: key.PublicKey(), ReceiveBytes: 100}
+
+	tracker := &UsageTracker{}
+	tracker.Deltas([]wgtypes.Peer{peer})
+	tracker.Deltas(nil)
+
+	// re-added peers start from scratch
+	usage := tracker.Deltas([]wgtypes.Peer{peer})
+	require.Len(t, usage, 1)
+	assert.Equal(t, int64(100), usage[0].ReceiveBytes)
+}
+
+func Test_GroupUsage(t *testing.T) {
+	usage := []PeerUsage{
+		{PubKey: "a", Name: "node1", ReceiveBytes: 1, TransmitBytes: 10},
+		{PubKey: "b", Name: "node2", ReceiveBytes: 2, TransmitBytes: 20},
+		{PubKey: "c", ReceiveBytes: 4, TransmitBytes: 40},
+		{PubKey: "d", ReceiveBytes: 8, TransmitBytes: 80},
+	}
+
+	assert.Equal(t, usage, GroupUsage(usage, []string{UsageLabelPubKey, UsageLabelName}))
+	assert.Equal(t, []PeerUsage{
+		{Name: "node1", ReceiveBytes: 1, TransmitBytes: 10},
+		{Name: "node2", ReceiveBytes: 2, TransmitBytes: 20},
+		{ReceiveBytes: 12, TransmitBytes: 120},
+	}, GroupUsage(usage, []string{UsageLabelName}))
+	assert.Equal(t, []PeerUsage{{ReceiveBytes: 15, TransmitBytes: 150}}, GroupUsage(usage, nil))
+	assert.Empty(t, GroupUsage(nil, nil))
+}
+
+func Test_ValidateUsageLabels(t *testing.T) {
+	assert.NoError(t, ValidateUsageLabels([]string{UsageLabelPubKey, UsageLabelName}))
+	assert.Error(t, ValidateUsageLabels([]string{"overlay"}))
+}
@@ -0,0 +1,220 @@
+package wg
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"strings"
+	"text/template"
+)
+
+// maxRehashes is the maximum number of times a name is rehashed when its address lands in a reserved range.
+const maxRehashes = 1000
+
+// Sources overlay addresses can be derived from.
+const (
+	AddrFromName   = "name"
+	AddrFromPubKey = "pubkey"
+)
+
+// AddrOptions holds settings influencing how overlay addresses are assigned.
+// The zero value hashes names into the whole overlay network.
+type AddrOptions struct {
+	// Reserved holds address ranges which are never assigned by hashing, e.g. to keep them free for static assignment.
+	Reserved []AddrRange
+	// HashSeed is mixed into the hashed name, allowing to deterministically shift all assigned addresses.
+	// The zero value keeps the unseeded addresses.
+	HashSeed uint64
+	// From selects what is hashed into the overlay address: the node name (AddrFromName, the default) or the
+	// wireguard public key (AddrFromPubKey).
+	From string
+	// Format is an optional template constructing the overlay address from the hash instead of mapping the hash into
+	// the host part of the overlay network; see ParseAddrFormat.
+	Format *template.Template
+	// Infrastructure lists the names of nodes assigned sequential addresses from the start of the overlay network
+	// instead of hashed ones: the first name gets the first address after the network address, and so on.
+	Infrastructure []string
+	// InfrastructureRange is the number of addresses at the start of the overlay network (including the network
+	// address) never assigned by hashing, so they stay free for Infrastructure nodes. If zero, just enough addresses
+	// for the listed nodes are kept.
+	InfrastructureRange int
+}
+
+// autoAddr reports whether wgAddress requests an automatically assigned overlay address: it is empty or an unspecified
+// address of either family, i.e. "0.0.0.0" or "::".
+func autoAddr(wgAddress string) bool {
+	if wgAddress == "" {
+		return true
+	}
+	addr, err := netip.ParseAddr(wgAddress)
+	return err == nil && addr.IsUnspecified()
+}
+
+// infrastructureAddr returns the sequential address of name if it is an infrastructure node.
+func (o AddrOptions) infrastructureAddr(prefix netip.Prefix, name string) (netip.Addr, bool, error) {
+	for i, infra := range o.Infrastructure {
+		if infra != name {
+			continue
+		}
+		addr := prefix.Masked().Addr()
+		for j := 0; j <= i; j++ {
+			addr = addr.Next()
+		}
+		if !prefix.Contains(addr) {
+			return netip.Addr{}, true, fmt.Errorf("infrastructure address %d does not fit in %s", i+1, prefix)
+		}
+		return addr, true, nil
+	}
+	return netip.Addr{}, false, nil
+}
+
+// infrastructureReserved reports whether addr is at the start of prefix, where infrastructure nodes get their
+// addresses.
+func (o AddrOptions) infrastructureReserved(prefix netip.Prefix, addr netip.Addr) bool {
+	size := o.InfrastructureRange
+	if size == 0 {
+		if len(o.Infrastructure) == 0 {
+			return false
+		}
+		size = len(o.Infrastructure) + 1
+	}
+	start := prefix.Masked().Addr()
+	for i := 0; i < size; i++ {
+		if addr == start {
+			return true
+		}
+		start = start.Next()
+	}
+	return false
+}
+
+func (o AddrOptions) reserved(addr netip.Addr) bool {
+	for _, r := range o.Reserved {
+		if r.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddrRange is an inclusive range of IP addresses.
+type AddrRange struct {
+	From netip.Addr
+	To   netip.Addr
+}
+
+// ParseAddrRange parses a range in the "FROM-TO" format, or a single address.
+func ParseAddrRange(s string) (AddrRange, error) {
+	fromStr, toStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		toStr = fromStr
+	}
+	from, err := netip.ParseAddr(strings.TrimSpace(fromStr))
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("parsing range start: %w", err)
+	}
+	to, err := netip.ParseAddr(strings.TrimSpace(toStr))
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("parsing range end: %w", err)
+	}
+	if from.BitLen() != to.BitLen() || to.Less(from) {
+		return AddrRange{}, fmt.Errorf("invalid address range %s", s)
+	}
+	return AddrRange{From: from, To: to}, nil
+}
+
+// PrefixRange returns the range of all addresses in prefix.
+func PrefixRange(prefix netip.Prefix) AddrRange {
+	from := prefix.Masked().Addr()
+	to := from.AsSlice()
+	hostBits := from.BitLen() - prefix.Bits()
+	for i := len(to) - 1; hostBits > 0; i-- {
+		if hostBits >= 8 {
+			to[i] = 0xff
+		} else {
+			to[i] |= byte(1<<hostBits - 1)
+		}
+		hostBits -= 8
+	}
+	toAddr, _ := netip.AddrFromSlice(to)
+	return AddrRange{From: from, To: toAddr}
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (r *AddrRange) UnmarshalText(text []byte) error {
+	parsed, err := ParseAddrRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+// Contains reports whether addr is part of the range.
+func (r AddrRange) Contains(addr netip.Addr) bool {
+	return addr.BitLen() == r.From.BitLen() && !addr.Less(r.From) && !r.To.Less(addr)
+}
+
+func (r AddrRange) String() string {
+	return r.From.String() + "-" + r.To.String()
+}
+
+// AddrFormatData is the data available to overlay address format templates.
+type AddrFormatData struct {
+	// Prefix is the overlay network
+	Prefix netip.Prefix
+	// Name is the node name
+	Name string
+	// HashHex is the hex encoded hash of the name (or public key)
+	HashHex string
+	// HashBytes is the hash of the name (or public key)
+	HashBytes []byte
+	// HostBits is the number of host bits in the overlay network
+	HostBits int
+}
+
+// ParseAddrFormat parses an overlay address format template. The template is executed with AddrFormatData and must
+// produce an address inside the overlay network.
+func ParseAddrFormat(format string) (*template.Template, error) {
+	return template.New("overlay-addr-format").Option("missingkey=error").Parse(format)
+}
+
+// formatAddr returns the overlay address for the hash, using the Format template if set or hashToAddr otherwise.
+func (o AddrOptions) formatAddr(prefix netip.Prefix, name string, hb []byte) (netip.Addr, error) {
+	if o.Format == nil {
+		return hashToAddr(prefix, hb)
+	}
+	var sb strings.Builder
+	if err := o.Format.Execute(&sb, AddrFormatData{
+		Prefix:    prefix,
+		Name:      name,
+		HashHex:   hex.EncodeToString(hb),
+		HashBytes: hb,
+		HostBits:  prefix.Addr().BitLen() - prefix.Bits(),
+	}); err != nil {
+		return netip.Addr{}, fmt.Errorf("executing overlay address format: %w", err)
+	}
+	addr, err := netip.ParseAddr(strings.TrimSpace(sb.String()))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("parsing formatted overlay address: %w", err)
+	}
+	if !prefix.Contains(addr) {
+		return netip.Addr{}, fmt.Errorf("formatted overlay address %s not part of the overlay network %s", addr, prefix)
+	}
+	return addr, nil
+}
+
+// hashToAddr maps the hash into the host part of the provided network.
+func hashToAddr(prefix netip.Prefix, hb []byte) (netip.Addr, error) {
+	ip := prefix.Addr().AsSlice()
+
+	for i := 1; i <= (prefix.Addr().BitLen()-prefix.Bits())/8; i++ {
+		ip[len(ip)-i] = hb[len(hb)-i]
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, fmt.Errorf("could not create IP from %s", ip)
+	}
+	return addr, nil
+}
@@ -0,0 +1,45 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+)
+
+// ErrOverlayAddrMismatch is returned by CheckOverlayAddr if peers route to an overlay address the node does not use.
+var ErrOverlayAddrMismatch = errors.New("overlay address mismatch")
+
+// CheckOverlayAddr verifies that the overlay address advertised to the cluster matches the locally assigned one, and
+// that the latter is configured on the interface. Otherwise peers route traffic for this node to an address it does
+// not answer on, which shows as one-directional connectivity.
+func (s *State) CheckOverlayAddr(advertised netip.Addr) error {
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return fmt.Errorf("getting link information for %s: %w", s.iface, err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("listing addresses of %s: %w", s.iface, err)
+	}
+	configured := make([]netip.Addr, 0, len(addrs))
+	for _, addr := range addrs {
+		if a, ok := netip.AddrFromSlice(addr.IP); ok {
+			configured = append(configured, a.Unmap())
+		}
+	}
+	return overlayAddrMismatch(s.OverlayAddr, advertised, configured)
+}
+
+func overlayAddrMismatch(assigned netip.Addr, advertised netip.Addr, configured []netip.Addr) error {
+	if advertised != assigned {
+		return fmt.Errorf("%w: advertising %s, but %s is assigned", ErrOverlayAddrMismatch, advertised, assigned)
+	}
+	for _, addr := range configured {
+		if addr == assigned {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is assigned and advertised, but not configured on the interface", ErrOverlayAddrMismatch, assigned)
+}
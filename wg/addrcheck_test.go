@@ -0,0 +1,20 @@
+package wg
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_overlayAddrMismatch(t *testing.T) {
+	assigned := netip.MustParseAddr("10.0.0.1")
+	other := netip.MustParseAddr("10.0.0.2")
+	linkLocal := netip.MustParseAddr("fe80::1")
+
+	assert.NoError(t, overlayAddrMismatch(assigned, assigned, []netip.Addr{linkLocal, assigned}))
+	assert.ErrorIs(t, overlayAddrMismatch(assigned, other, []netip.Addr{assigned}), ErrOverlayAddrMismatch, "advertised differs")
+	assert.ErrorIs(t, overlayAddrMismatch(assigned, netip.Addr{}, []netip.Addr{assigned}), ErrOverlayAddrMismatch, "nothing advertised")
+	assert.ErrorIs(t, overlayAddrMismatch(assigned, assigned, []netip.Addr{other}), ErrOverlayAddrMismatch, "interface differs")
+	assert.ErrorIs(t, overlayAddrMismatch(assigned, assigned, nil), ErrOverlayAddrMismatch, "no address configured")
+}
@@ -0,0 +1,65 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// AdoptDevice takes over an existing wireguard device left behind by a previous instance, by using its private key.
+// Afterwards, SetUpInterface reconciles the device's peers instead of replacing them, so established sessions
+// survive. It returns whether a device was adopted; callers must announce the adopted public key and overlay address,
+// which changes if derived from the public key.
+func (s *State) AdoptDevice() (bool, error) {
+	device, err := s.GetConfig()
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if device.PrivateKey == (wgtypes.Key{}) {
+		return false, nil
+	}
+
+	if device.PrivateKey != s.PrivKey {
+		// the device's key wins to keep sessions; persist it to keep it on later restarts, too
+		if err := saveKey(s.keyFile, device.PrivateKey); err != nil {
+			return false, err
+		}
+	}
+	s.PrivKey = device.PrivateKey
+	s.PubKey = device.PublicKey
+	s.adopted = true
+	if s.addrOpts.From == AddrFromPubKey {
+		if err := s.assignOverlayAddr(s.prefix, s.name, s.wgAddress); err != nil {
+			return true, fmt.Errorf("assigning overlay address: %w", err)
+		}
+	}
+
+	return true, nil
+}
+
+// stalePeers returns configurations removing all peers currently on the device but not in peerCfgs.
+func (s *State) stalePeers(peerCfgs []wgtypes.PeerConfig) ([]wgtypes.PeerConfig, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return stalePeerConfigs(device.Peers, peerCfgs), nil
+}
+
+func stalePeerConfigs(current []wgtypes.Peer, peerCfgs []wgtypes.PeerConfig) []wgtypes.PeerConfig {
+	desired := make(map[wgtypes.Key]bool, len(peerCfgs))
+	for _, cfg := range peerCfgs {
+		desired[cfg.PublicKey] = true
+	}
+	var stale []wgtypes.PeerConfig
+	for _, peer := range current {
+		if !desired[peer.PublicKey] {
+			stale = append(stale, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+	return stale
+}
@@ -0,0 +1,37 @@
+package wg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_stalePeerConfigs(t *testing.T) {
+	keys := make([]wgtypes.Key, 3)
+	for i := range keys {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys[i] = privKey.PublicKey()
+	}
+
+	stale := stalePeerConfigs(
+		[]wgtypes.Peer{{PublicKey: keys[0]}, {PublicKey: keys[1]}},
+		[]wgtypes.PeerConfig{{PublicKey: keys[1]}, {PublicKey: keys[2]}},
+	)
+
+	assert.Equal(t, []wgtypes.PeerConfig{{PublicKey: keys[0], Remove: true}}, stale)
+}
+
+func Test_State_AdoptDevice(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	s := withCachedDevice(&State{}, &wgtypes.Device{PrivateKey: privKey, PublicKey: privKey.PublicKey()})
+
+	adopted, err := s.AdoptDevice()
+	require.NoError(t, err)
+	assert.True(t, adopted)
+	assert.Equal(t, privKey, s.PrivKey)
+	assert.Equal(t, privKey.PublicKey(), s.PubKey)
+}
@@ -0,0 +1,45 @@
+package wg
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/costela/wesher/common"
+)
+
+// minimalAllowedIPs returns a host prefix for each of the node's addresses, i.e. its overlay and service addresses.
+// Since wireguard only routes a given prefix to a single peer, prefixes already claimed by a previous node are
+// skipped; claimed is updated with the prefixes returned.
+func minimalAllowedIPs(node common.Node, claimed map[netip.Prefix]string) []net.IPNet {
+	prefixes := []netip.Prefix{netip.PrefixFrom(node.OverlayAddr, node.OverlayAddr.BitLen())}
+	for _, addr := range node.ServiceAddrs {
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	allowedIPs := make([]net.IPNet, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if owner, ok := overlappingClaim(prefix, claimed); ok {
+			Logger.Warnf("not allowing %s for node %s: already allowed for node %s", prefix, node.Name, owner)
+			continue
+		}
+		claimed[prefix] = node.Name
+		allowedIPs = append(allowedIPs, prefixToIPNet(prefix))
+	}
+	return allowedIPs
+}
+
+func overlappingClaim(prefix netip.Prefix, claimed map[netip.Prefix]string) (string, bool) {
+	for other, owner := range claimed {
+		if prefix.Overlaps(other) {
+			return owner, true
+		}
+	}
+	return "", false
+}
+
+func prefixToIPNet(prefix netip.Prefix) net.IPNet {
+	return net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
@@ -0,0 +1,40 @@
+package wg
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_minimalAllowedIPs_unique(t *testing.T) {
+	claimed := make(map[netip.Prefix]string)
+	node1 := common.Node{Name: "node1"}
+	node1.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+	node2 := common.Node{Name: "node2"}
+	node2.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+
+	assert.Len(t, minimalAllowedIPs(node1, claimed), 1)
+	assert.Empty(t, minimalAllowedIPs(node2, claimed))
+}
+
+func Test_State_nodesToPeerConfigs_fullTunnel_capabilities(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	legacy := common.Node{Name: "legacy"}
+	legacy.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+	legacy.PubKey = privKey.PublicKey().String()
+	capable := legacy
+	capable.Name = "capable"
+	capable.Capabilities = common.CapIPv6Overlay
+
+	s := &State{AllowedIPsPolicy: AllowedIPsFullTunnel}
+	peerCfgs, err := s.nodesToPeerConfigs([]common.Node{legacy, capable})
+	require.NoError(t, err)
+
+	assert.Len(t, peerCfgs[0].AllowedIPs, 2, "legacy peers must not get ::/0")
+	assert.Len(t, peerCfgs[1].AllowedIPs, 3)
+}
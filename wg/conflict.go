@@ -0,0 +1,136 @@
+package wg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/costela/wesher/common"
+	"github.com/vishvananda/netlink"
+)
+
+// ConflictResolver decides which overlay address the local node uses when other claims the same address.
+// Returning the current address keeps it, e.g. when the other node is expected to move instead. Since both nodes
+// detect the conflict, resolvers must agree on which node moves.
+type ConflictResolver func(s *State, other common.Node) (netip.Addr, error)
+
+// ResolveConflicts checks whether any of nodes claims the local overlay address and, if so, lets ConflictResolver
+// pick the address to use. It returns whether the address changed, in which case it must be announced to the cluster.
+func (s *State) ResolveConflicts(nodes []common.Node) (bool, error) {
+	resolve := s.ConflictResolver
+	if resolve == nil {
+		resolve = RehashConflictResolver
+	}
+
+	changed := false
+	for _, node := range nodes {
+		if node.OverlayAddr != s.OverlayAddr {
+			continue
+		}
+		Logger.Warnf("overlay address %s is also claimed by %s", s.OverlayAddr, node.Name)
+		oldAddr := s.OverlayAddr
+		newAddr, err := resolve(s, node)
+		if err != nil {
+			return changed, fmt.Errorf("resolving address conflict with %s: %w", node.Name, err)
+		}
+		if newAddr == oldAddr {
+			continue
+		}
+		Logger.Infof("moving overlay address from %s to %s", oldAddr, newAddr)
+		s.OverlayAddr = newAddr
+		changed = true
+		// the new address is set by the next SetUpInterface, but the old one must not linger
+		if link, err := netlink.LinkByName(s.iface); err == nil {
+			netlink.AddrDel(link, &netlink.Addr{IPNet: addrToIPNet(oldAddr)}) // nolint: errcheck // opportunistic
+		}
+	}
+
+	return changed, nil
+}
+
+// RehashConflictResolver is the default ConflictResolver. The node with the greater name moves, by rehashing as if
+// the conflicting address was reserved. Explicitly set addresses never move.
+// The conflicting addresses are persisted next to the key file, so the node keeps the address it moved to after
+// restarts, instead of claiming the conflicting one again.
+func RehashConflictResolver(s *State, other common.Node) (netip.Addr, error) {
+	if !autoAddr(s.wgAddress) {
+		Logger.Warnf("not moving explicitly set overlay address %s", s.OverlayAddr)
+		return s.OverlayAddr, nil
+	}
+	if s.name < other.Name {
+		return s.OverlayAddr, nil
+	}
+
+	if !s.addrOpts.reserved(s.OverlayAddr) {
+		s.addrOpts.Reserved = append(s.addrOpts.Reserved, AddrRange{From: s.OverlayAddr, To: s.OverlayAddr})
+		s.conflicts = append(s.conflicts, s.OverlayAddr)
+		if err := saveConflicts(conflictsFile(s.keyFile), s.conflicts); err != nil {
+			Logger.WithError(err).Warn("could not persist conflicting overlay addresses, the address may move again after a restart")
+		}
+	}
+	current := s.OverlayAddr
+	if err := s.assignOverlayAddr(s.prefix, s.name, s.wgAddress); err != nil {
+		return netip.Addr{}, err
+	}
+	newAddr := s.OverlayAddr
+	s.OverlayAddr = current
+	return newAddr, nil
+}
+
+// conflictsFile returns the file the addresses given up by RehashConflictResolver are kept in, next to keyFile.
+// Without key persistence, conflicting addresses are not persisted either, since a new key may change the address.
+func conflictsFile(keyFile string) string {
+	if keyFile == "" || keyFile == EphemeralKeyFile {
+		return ""
+	}
+	return strings.TrimSuffix(keyFile, filepath.Ext(keyFile)) + ".conflicts"
+}
+
+// loadConflicts reads the addresses stored by saveConflicts, one per line. A missing file holds no addresses.
+func loadConflicts(file string) ([]netip.Addr, error) {
+	if file == "" {
+		return nil, nil
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var addrs []netip.Addr
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(line)
+		if err != nil {
+			return nil, fmt.Errorf("parsing conflicting address in %s: %w", file, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, scanner.Err()
+}
+
+// saveConflicts replaces the contents of file with addrs. It is a noop without file.
+func saveConflicts(file string, addrs []netip.Addr) error {
+	if file == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	var content strings.Builder
+	for _, addr := range addrs {
+		content.WriteString(addr.String() + "\n")
+	}
+	return os.WriteFile(file, []byte(content.String()), 0600)
+}
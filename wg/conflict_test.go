@@ -0,0 +1,86 @@
+package wg
+
+import (
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_State_ResolveConflicts_rehash(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	s := &State{iface: "wgtest-nonexistent", prefix: prefix, name: "node-b"}
+	require.NoError(t, s.assignOverlayAddr(prefix, s.name, ""))
+	conflicting := s.OverlayAddr
+
+	other := common.Node{Name: "node-a"}
+	other.OverlayAddr = conflicting
+
+	changed, err := s.ResolveConflicts([]common.Node{other})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.NotEqual(t, conflicting, s.OverlayAddr)
+	assert.True(t, prefix.Contains(s.OverlayAddr))
+}
+
+func Test_State_ResolveConflicts_keep(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	s := &State{iface: "wgtest-nonexistent", prefix: prefix, name: "node-a"}
+	require.NoError(t, s.assignOverlayAddr(prefix, s.name, ""))
+	conflicting := s.OverlayAddr
+
+	// the other node has the greater name, so it moves
+	other := common.Node{Name: "node-b"}
+	other.OverlayAddr = conflicting
+
+	changed, err := s.ResolveConflicts([]common.Node{other})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, conflicting, s.OverlayAddr)
+}
+
+func Test_State_ResolveConflicts_custom(t *testing.T) {
+	replacement := netip.MustParseAddr("10.1.2.3")
+	s := &State{
+		iface:       "wgtest-nonexistent",
+		OverlayAddr: netip.MustParseAddr("10.0.0.1"),
+		ConflictResolver: func(s *State, other common.Node) (netip.Addr, error) {
+			return replacement, nil
+		},
+	}
+	other := common.Node{Name: "other"}
+	other.OverlayAddr = s.OverlayAddr
+
+	changed, err := s.ResolveConflicts([]common.Node{other})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, replacement, s.OverlayAddr)
+}
+
+func Test_State_ResolveConflicts_persisted(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	keyFile := filepath.Join(t.TempDir(), "wgtest.key")
+	s, _, err := New("wgtest-nonexistent", 51820, 1420, prefix, "node-b", "", keyFile, AddrOptions{})
+	require.NoError(t, err)
+	conflicting := s.OverlayAddr
+
+	other := common.Node{Name: "node-a"}
+	other.OverlayAddr = conflicting
+	for i := 0; i < 3; i++ {
+		_, err := RehashConflictResolver(s, other)
+		require.NoError(t, err)
+	}
+	assert.Len(t, s.addrOpts.Reserved, 1, "repeated conflicts over the same address must not grow the reservations")
+
+	changed, err := s.ResolveConflicts([]common.Node{other})
+	require.NoError(t, err)
+	require.True(t, changed)
+	moved := s.OverlayAddr
+
+	restarted, _, err := New("wgtest-nonexistent", 51820, 1420, prefix, "node-b", "", keyFile, AddrOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, moved, restarted.OverlayAddr, "the address moved to must be kept after a restart")
+}
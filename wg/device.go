@@ -0,0 +1,138 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultDeviceCacheTTL is the default duration for which GetConfig results are cached.
+const DefaultDeviceCacheTTL = 1 * time.Second
+
+// deviceWaitInterval is the interval in which the availability of a newly created device is polled.
+const deviceWaitInterval = 500 * time.Millisecond
+
+// Client is the subset of wgctrl.Client used to manage wireguard devices.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+}
+
+// SetClient makes the State use c instead of the kernel wireguard client, e.g. to record or replay a session.
+// It has no effect once the State has used its client.
+func (s *State) SetClient(c Client) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.client == nil {
+		s.client = c
+	}
+}
+
+// newClient instantiates the kernel wireguard client.
+var newClient = func() (Client, error) { return wgctrl.New() }
+
+// lazyClient returns the wireguard client, instantiating the kernel client on first use.
+// This avoids opening it when the State is only used for computing the node's configuration. Failures are not cached,
+// so later calls retry, e.g. once the kernel module is loaded.
+func (s *State) lazyClient() (Client, error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if s.client == nil {
+		client, err := newClient()
+		if err != nil {
+			return nil, fmt.Errorf("instantiating wireguard client: %w", err)
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// GetConfig returns the current configuration of the wireguard device.
+// Results are cached for DeviceCacheTTL, to avoid hitting the kernel on every call; any configuration done through
+// State invalidates the cache.
+func (s *State) GetConfig() (wgtypes.Device, error) {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+
+	if s.device != nil && time.Since(s.deviceFetched) < s.DeviceCacheTTL {
+		return *s.device, nil
+	}
+
+	client, err := s.lazyClient()
+	if err != nil {
+		return wgtypes.Device{}, err
+	}
+	device, err := client.Device(s.iface)
+	if err != nil {
+		return wgtypes.Device{}, fmt.Errorf("getting device %s: %w", s.iface, err)
+	}
+	s.device = device
+	s.deviceFetched = time.Now()
+
+	return *device, nil
+}
+
+// PeerEndpoints returns the current endpoint of every peer configured on the wireguard device, from a single
+// (possibly cached) device query. Peers without a known endpoint are included with a nil address.
+func (s *State) PeerEndpoints() (map[wgtypes.Key]*net.UDPAddr, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[wgtypes.Key]*net.UDPAddr, len(device.Peers))
+	for _, peer := range device.Peers {
+		endpoints[peer.PublicKey] = peer.Endpoint
+	}
+	return endpoints, nil
+}
+
+// configureDevice applies cfg to the wireguard device and invalidates the GetConfig cache.
+// OnConfigure is notified of successfully applied configurations.
+func (s *State) configureDevice(cfg wgtypes.Config) error {
+	s.invalidateDevice()
+	defer s.invalidateDevice() // avoid caching results fetched while configuring
+	client, err := s.lazyClient()
+	if err != nil {
+		return err
+	}
+	if err := client.ConfigureDevice(s.iface, cfg); err != nil {
+		return err
+	}
+	if s.OnConfigure != nil {
+		s.OnConfigure(newConfigEvent(s.iface, s.OverlayAddr, cfg))
+	}
+	return nil
+}
+
+func (s *State) invalidateDevice() {
+	s.deviceMu.Lock()
+	defer s.deviceMu.Unlock()
+	s.device = nil
+}
+
+// waitForDevice polls the wireguard device until it becomes available or InterfaceUpTimeout expires.
+// This gives slowly loading kernel modules time to set up a newly created link.
+func (s *State) waitForDevice() error {
+	client, err := s.lazyClient()
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(s.InterfaceUpTimeout)
+	for {
+		_, err := client.Device(s.iface)
+		if err == nil || (!errors.Is(err, os.ErrNotExist) && !errors.Is(err, syscall.ENODEV)) {
+			return err
+		}
+		if time.Now().Add(deviceWaitInterval).After(deadline) {
+			return fmt.Errorf("device %s not available after %s: %w", s.iface, s.InterfaceUpTimeout, err)
+		}
+		Logger.Debugf("device %s not available yet, retrying in %s", s.iface, deviceWaitInterval)
+		time.Sleep(deviceWaitInterval)
+	}
+}
@@ -0,0 +1,72 @@
+package wg
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// withCachedDevice makes s use device as its freshly fetched device, so it does not query the kernel as long as the
+// cache is not invalidated.
+func withCachedDevice(s *State, device *wgtypes.Device) *State {
+	if s.DeviceCacheTTL == 0 {
+		s.DeviceCacheTTL = time.Minute
+	}
+	s.device = device
+	s.deviceFetched = time.Now()
+	return s
+}
+
+func Test_State_GetConfig_cached(t *testing.T) {
+	s := withCachedDevice(&State{iface: "wgtest"}, &wgtypes.Device{Name: "wgtest", ListenPort: 1234})
+
+	device, err := s.GetConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 1234, device.ListenPort)
+}
+
+func Test_State_GetConfig_invalidate(t *testing.T) {
+	s := withCachedDevice(&State{}, &wgtypes.Device{})
+	s.invalidateDevice()
+	assert.Nil(t, s.device)
+}
+
+func Test_State_PeerEndpoints(t *testing.T) {
+	endpoint := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820}
+	s := withCachedDevice(&State{}, &wgtypes.Device{Peers: []wgtypes.Peer{
+		{PublicKey: wgtypes.Key{1}, Endpoint: endpoint},
+		{PublicKey: wgtypes.Key{2}},
+	}})
+
+	endpoints, err := s.PeerEndpoints()
+	require.NoError(t, err)
+	assert.Equal(t, map[wgtypes.Key]*net.UDPAddr{
+		{1}: endpoint,
+		{2}: nil,
+	}, endpoints)
+}
+
+func Test_State_lazyClient_retries(t *testing.T) {
+	failures := 1
+	defer func(orig func() (Client, error)) { newClient = orig }(newClient)
+	newClient = func() (Client, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("module not loaded")
+		}
+		return &configRecorder{}, nil
+	}
+	s := &State{}
+
+	_, err := s.lazyClient()
+	assert.Error(t, err)
+
+	client, err := s.lazyClient()
+	require.NoError(t, err)
+	assert.NotNil(t, client)
+}
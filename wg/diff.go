@@ -0,0 +1,240 @@
+package wg
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/costela/wesher/common"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ConfigDiff describes a discrepancy between the desired and the actual configuration of a wireguard device.
+type ConfigDiff struct {
+	// PubKey is the public key of the peer concerned; empty for device-level discrepancies.
+	PubKey string
+	// Problem is a human-readable description of the discrepancy.
+	Problem string
+}
+
+func (d ConfigDiff) String() string {
+	if d.PubKey == "" {
+		return fmt.Sprintf("device: %s", d.Problem)
+	}
+	return fmt.Sprintf("%s: %s", d.PubKey, d.Problem)
+}
+
+// Open returns a State for inspecting an already existing wireguard interface, e.g. one managed by a running agent.
+// The returned State has no keys or overlay address and must not be used to set up the interface.
+func Open(iface string, port int) (*State, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, fmt.Errorf("instantiating wireguard client: %w", err)
+	}
+	s := &State{
+		iface:          iface,
+		Port:           port,
+		DeviceCacheTTL: DefaultDeviceCacheTTL,
+	}
+	s.SetClient(client)
+	return s, nil
+}
+
+// DiffPeers compares the peers SetUpInterface would configure for nodes with the peers actually configured on the
+// wireguard device, returning all discrepancies found.
+func (s *State) DiffPeers(nodes []common.Node) ([]ConfigDiff, error) {
+	desired, err := s.nodesToPeerConfigs(nodes)
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return diffPeers(desired, device.Peers), nil
+}
+
+// DiffConfig compares the whole configuration SetUpInterface would apply for nodes with the live wireguard device.
+// In addition to DiffPeers, device-level settings are compared; the public key only if State holds a key.
+func (s *State) DiffConfig(nodes []common.Node) ([]ConfigDiff, error) {
+	desired, err := s.nodesToPeerConfigs(nodes)
+	if err != nil {
+		return nil, err
+	}
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []ConfigDiff
+	if device.ListenPort != s.Port {
+		diffs = append(diffs, ConfigDiff{Problem: fmt.Sprintf("listen port is %d, expected %d", device.ListenPort, s.Port)})
+	}
+	if s.PubKey != (wgtypes.Key{}) && device.PublicKey != s.PubKey {
+		diffs = append(diffs, ConfigDiff{Problem: fmt.Sprintf("public key is %s, expected %s", device.PublicKey, s.PubKey)})
+	}
+
+	for _, mismatch := range keyMismatches(nodes, device.Peers) {
+		diffs = append(diffs, ConfigDiff{
+			PubKey:  mismatch.ClusterKey,
+			Problem: fmt.Sprintf("overlay address %s is configured for key %s instead", mismatch.OverlayAddr, mismatch.DeviceKey),
+		})
+	}
+
+	return append(diffs, diffPeers(desired, device.Peers)...), nil
+}
+
+// KeyMismatch describes a node whose overlay address is routed to a device peer with a different public key than the
+// node announces, e.g. after a partially applied key rotation.
+type KeyMismatch struct {
+	Node        string
+	OverlayAddr netip.Addr
+	ClusterKey  string
+	DeviceKey   string
+}
+
+// KeyMismatches compares the public keys of nodes with those of the device peers their overlay addresses are
+// configured for.
+func (s *State) KeyMismatches(nodes []common.Node) ([]KeyMismatch, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return keyMismatches(nodes, device.Peers), nil
+}
+
+func keyMismatches(nodes []common.Node, peers []wgtypes.Peer) []KeyMismatch {
+	byHostAddr := make(map[netip.Addr]wgtypes.Peer, len(peers))
+	for _, peer := range peers {
+		for _, ipNet := range peer.AllowedIPs {
+			addr, ok := netip.AddrFromSlice(ipNet.IP)
+			if ones, bits := ipNet.Mask.Size(); !ok || ones != bits {
+				continue
+			}
+			byHostAddr[addr.Unmap()] = peer
+		}
+	}
+
+	var mismatches []KeyMismatch
+	for _, node := range nodes {
+		peer, ok := byHostAddr[node.OverlayAddr]
+		if !ok || peer.PublicKey.String() == node.PubKey {
+			continue
+		}
+		mismatches = append(mismatches, KeyMismatch{
+			Node:        node.Name,
+			OverlayAddr: node.OverlayAddr,
+			ClusterKey:  node.PubKey,
+			DeviceKey:   peer.PublicKey.String(),
+		})
+	}
+	return mismatches
+}
+
+// diffPeers compares desired with actual peer configs. Wireguard routes each allowed IP to a single peer, so a prefix
+// desired for several peers (e.g. the ranges of the private-ranges policy) ends up on whichever of them was configured
+// last; such shared prefixes are only required to be held by one of the peers wanting them.
+func diffPeers(desired []wgtypes.PeerConfig, actual []wgtypes.Peer) []ConfigDiff {
+	var diffs []ConfigDiff
+
+	wantedBy := make(map[string]map[wgtypes.Key]bool)
+	for _, want := range desired {
+		for _, ipNet := range want.AllowedIPs {
+			prefix := ipNet.String()
+			if wantedBy[prefix] == nil {
+				wantedBy[prefix] = make(map[wgtypes.Key]bool)
+			}
+			wantedBy[prefix][want.PublicKey] = true
+		}
+	}
+	shared := func(ipNet net.IPNet) bool { return len(wantedBy[ipNet.String()]) > 1 }
+
+	actualByKey := make(map[wgtypes.Key]wgtypes.Peer, len(actual))
+	heldBy := make(map[string]wgtypes.Key)
+	for _, peer := range actual {
+		actualByKey[peer.PublicKey] = peer
+		for _, ipNet := range peer.AllowedIPs {
+			heldBy[ipNet.String()] = peer.PublicKey
+		}
+	}
+
+	for _, want := range desired {
+		key := want.PublicKey.String()
+		got, ok := actualByKey[want.PublicKey]
+		if !ok {
+			diffs = append(diffs, ConfigDiff{PubKey: key, Problem: "in cluster but not in wireguard"})
+			continue
+		}
+		delete(actualByKey, want.PublicKey)
+
+		if wantEndpoint, gotEndpoint := udpAddrString(want.Endpoint), udpAddrString(got.Endpoint); wantEndpoint != gotEndpoint {
+			diffs = append(diffs, ConfigDiff{
+				PubKey:  key,
+				Problem: fmt.Sprintf("endpoint is %s, expected %s", gotEndpoint, wantEndpoint),
+			})
+		}
+		wantIPs, gotIPs := ipNetsString(filterIPNets(want.AllowedIPs, shared)), ipNetsString(filterIPNets(got.AllowedIPs, shared))
+		if wantIPs != gotIPs {
+			diffs = append(diffs, ConfigDiff{
+				PubKey:  key,
+				Problem: fmt.Sprintf("allowed IPs are %s, expected %s", gotIPs, wantIPs),
+			})
+		}
+	}
+
+	var sharedPrefixes []string
+	for prefix, keys := range wantedBy {
+		if len(keys) > 1 {
+			sharedPrefixes = append(sharedPrefixes, prefix)
+		}
+	}
+	sort.Strings(sharedPrefixes)
+	for _, prefix := range sharedPrefixes {
+		holder, ok := heldBy[prefix]
+		switch {
+		case !ok:
+			diffs = append(diffs, ConfigDiff{Problem: fmt.Sprintf("allowed IP %s is not configured for any peer", prefix)})
+		case !wantedBy[prefix][holder]:
+			diffs = append(diffs, ConfigDiff{Problem: fmt.Sprintf("allowed IP %s is configured for unexpected peer %s", prefix, holder)})
+		}
+	}
+
+	for _, peer := range actual {
+		if _, ok := actualByKey[peer.PublicKey]; ok {
+			diffs = append(diffs, ConfigDiff{PubKey: peer.PublicKey.String(), Problem: "in wireguard but not in cluster"})
+		}
+	}
+
+	return diffs
+}
+
+// filterIPNets returns the nets for which exclude returns false.
+func filterIPNets(nets []net.IPNet, exclude func(net.IPNet) bool) []net.IPNet {
+	var filtered []net.IPNet
+	for _, n := range nets {
+		if !exclude(n) {
+			filtered = append(filtered, n)
+		}
+	}
+	return filtered
+}
+
+func udpAddrString(addr *net.UDPAddr) string {
+	if addr == nil {
+		return "(none)"
+	}
+	return addr.String()
+}
+
+// ipNetsString returns an order-independent representation of nets.
+func ipNetsString(nets []net.IPNet) string {
+	strs := make([]string, len(nets))
+	for i, n := range nets {
+		strs[i] = n.String()
+	}
+	sort.Strings(strs)
+	return "[" + strings.Join(strs, ", ") + "]"
+}
@@ -0,0 +1,142 @@
+package wg
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_diffPeers(t *testing.T) {
+	keys := make([]wgtypes.Key, 4)
+	for i := range keys {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys[i] = privKey.PublicKey()
+	}
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+	otherEndpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 51820}
+	_, net1, _ := net.ParseCIDR("10.0.0.1/32")
+	_, net2, _ := net.ParseCIDR("192.168.0.0/16")
+	_, net3, _ := net.ParseCIDR("10.0.0.2/32")
+	_, net4, _ := net.ParseCIDR("10.0.0.3/32")
+	_, net5, _ := net.ParseCIDR("10.0.0.4/32")
+
+	desired := []wgtypes.PeerConfig{
+		{PublicKey: keys[0], Endpoint: endpoint, AllowedIPs: []net.IPNet{*net1, *net2}},
+		{PublicKey: keys[1], Endpoint: endpoint, AllowedIPs: []net.IPNet{*net3}},
+		{PublicKey: keys[2], Endpoint: endpoint, AllowedIPs: []net.IPNet{*net4}},
+	}
+	actual := []wgtypes.Peer{
+		// allowed IPs order does not matter
+		{PublicKey: keys[0], Endpoint: endpoint, AllowedIPs: []net.IPNet{*net2, *net1}},
+		{PublicKey: keys[1], Endpoint: otherEndpoint, AllowedIPs: []net.IPNet{*net5}},
+		{PublicKey: keys[3], Endpoint: endpoint, AllowedIPs: []net.IPNet{*net4}},
+	}
+
+	diffs := diffPeers(desired, actual)
+
+	assert.Equal(t, []ConfigDiff{
+		{PubKey: keys[1].String(), Problem: "endpoint is 192.0.2.2:51820, expected 192.0.2.1:51820"},
+		{PubKey: keys[1].String(), Problem: "allowed IPs are [10.0.0.4/32], expected [10.0.0.2/32]"},
+		{PubKey: keys[2].String(), Problem: "in cluster but not in wireguard"},
+		{PubKey: keys[3].String(), Problem: "in wireguard but not in cluster"},
+	}, diffs)
+}
+
+func Test_diffPeers_consistent(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+	_, ipnet, _ := net.ParseCIDR("10.0.0.1/32")
+
+	diffs := diffPeers(
+		[]wgtypes.PeerConfig{{PublicKey: privKey.PublicKey(), Endpoint: endpoint, AllowedIPs: []net.IPNet{*ipnet}}},
+		[]wgtypes.Peer{{PublicKey: privKey.PublicKey(), Endpoint: endpoint, AllowedIPs: []net.IPNet{*ipnet}}},
+	)
+
+	assert.Empty(t, diffs)
+}
+
+func Test_diffPeers_sharedAllowedIPs(t *testing.T) {
+	keys := make([]wgtypes.Key, 4)
+	for i := range keys {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys[i] = privKey.PublicKey()
+	}
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+	ipNet := func(cidr string) net.IPNet {
+		_, n, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		return *n
+	}
+	private1, private2 := ipNet("172.16.0.0/12"), ipNet("192.168.0.0/16")
+
+	// as with the private-ranges policy, all peers want the same ranges besides their own overlay address
+	desired := make([]wgtypes.PeerConfig, 3)
+	for i := range desired {
+		desired[i] = wgtypes.PeerConfig{
+			PublicKey:  keys[i],
+			Endpoint:   endpoint,
+			AllowedIPs: []net.IPNet{ipNet(fmt.Sprintf("10.0.0.%d/32", i+1)), private1, private2},
+		}
+	}
+
+	// the kernel keeps each shared range on a single peer only
+	actual := []wgtypes.Peer{
+		{PublicKey: keys[0], Endpoint: endpoint, AllowedIPs: []net.IPNet{ipNet("10.0.0.1/32")}},
+		{PublicKey: keys[1], Endpoint: endpoint, AllowedIPs: []net.IPNet{ipNet("10.0.0.2/32"), private1}},
+		{PublicKey: keys[2], Endpoint: endpoint, AllowedIPs: []net.IPNet{ipNet("10.0.0.3/32"), private2}},
+	}
+	assert.Empty(t, diffPeers(desired, actual))
+
+	actual[1].AllowedIPs = []net.IPNet{ipNet("10.0.0.2/32")}
+	actual[2].AllowedIPs = []net.IPNet{ipNet("10.0.0.3/32")}
+	actual = append(actual, wgtypes.Peer{PublicKey: keys[3], Endpoint: endpoint, AllowedIPs: []net.IPNet{private1}})
+	assert.Equal(t, []ConfigDiff{
+		{Problem: "allowed IP 172.16.0.0/12 is configured for unexpected peer " + keys[3].String()},
+		{Problem: "allowed IP 192.168.0.0/16 is not configured for any peer"},
+		{PubKey: keys[3].String(), Problem: "in wireguard but not in cluster"},
+	}, diffPeers(desired, actual))
+}
+
+func Test_keyMismatches(t *testing.T) {
+	keys := make([]wgtypes.Key, 3)
+	for i := range keys {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys[i] = privKey.PublicKey()
+	}
+	_, host1, _ := net.ParseCIDR("10.0.0.1/32")
+	_, host2, _ := net.ParseCIDR("10.0.0.2/32")
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+
+	node1 := common.Node{Name: "node1"}
+	node1.OverlayAddr = netip.MustParseAddr("10.0.0.1")
+	node1.PubKey = keys[0].String()
+	node2 := common.Node{Name: "node2"}
+	node2.OverlayAddr = netip.MustParseAddr("10.0.0.2")
+	node2.PubKey = keys[1].String()
+
+	mismatches := keyMismatches(
+		[]common.Node{node1, node2},
+		[]wgtypes.Peer{
+			{PublicKey: keys[0], AllowedIPs: []net.IPNet{*host1, *private}},
+			// stale key for node2, e.g. after a partial rotation
+			{PublicKey: keys[2], AllowedIPs: []net.IPNet{*host2, *private}},
+		},
+	)
+
+	assert.Equal(t, []KeyMismatch{{
+		Node:        "node2",
+		OverlayAddr: node2.OverlayAddr,
+		ClusterKey:  keys[1].String(),
+		DeviceKey:   keys[2].String(),
+	}}, mismatches)
+}
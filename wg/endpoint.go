@@ -0,0 +1,59 @@
+package wg
+
+import (
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// endpointState tracks the endpoint configured for a peer and a changed endpoint waiting to become stable.
+type endpointState struct {
+	configured *net.UDPAddr
+	candidate  *net.UDPAddr
+	since      time.Time
+}
+
+// NextEndpointUpdate returns the time at which an endpoint change held back by EndpointStabilityWindow during the last
+// SetUpInterface becomes stable, or the zero time if there is none. Callers should set up the interface again at that
+// time if no other update arrives.
+func (s *State) NextEndpointUpdate() time.Time {
+	return s.nextEndpointUpdate
+}
+
+// debounceEndpoints keeps the previously configured endpoint of peers whose endpoint changed less than
+// EndpointStabilityWindow ago, so brief flaps to another address do not churn the device.
+func (s *State) debounceEndpoints(peerCfgs []wgtypes.PeerConfig, now time.Time) {
+	endpoints := make(map[wgtypes.Key]*endpointState, len(peerCfgs))
+	s.nextEndpointUpdate = time.Time{}
+	for i := range peerCfgs {
+		cfg := &peerCfgs[i]
+		if cfg.Endpoint == nil {
+			continue
+		}
+		state, ok := s.endpoints[cfg.PublicKey]
+		if !ok || s.EndpointStabilityWindow == 0 || udpAddrString(state.configured) == udpAddrString(cfg.Endpoint) {
+			endpoints[cfg.PublicKey] = &endpointState{configured: cfg.Endpoint}
+			continue
+		}
+		endpoints[cfg.PublicKey] = state
+
+		if udpAddrString(state.candidate) != udpAddrString(cfg.Endpoint) {
+			state.candidate = cfg.Endpoint
+			state.since = now
+		}
+		stableAt := state.since.Add(s.EndpointStabilityWindow)
+		if !now.Before(stableAt) {
+			Logger.Infof("endpoint of %s changed to %s", cfg.PublicKey, cfg.Endpoint)
+			state.configured, state.candidate = cfg.Endpoint, nil
+			continue
+		}
+
+		Logger.Debugf("endpoint of %s changed to %s, keeping %s until %s", cfg.PublicKey, cfg.Endpoint, state.configured, stableAt.Format(time.RFC3339))
+		cfg.Endpoint = state.configured
+		if s.nextEndpointUpdate.IsZero() || stableAt.Before(s.nextEndpointUpdate) {
+			s.nextEndpointUpdate = stableAt
+		}
+	}
+	s.endpoints = endpoints
+}
@@ -0,0 +1,55 @@
+package wg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_debounceEndpoints(t *testing.T) {
+	key := wgtypes.Key{1}
+	oldEndpoint := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820}
+	newEndpoint := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 51820}
+	peers := func(endpoint *net.UDPAddr) []wgtypes.PeerConfig {
+		return []wgtypes.PeerConfig{{PublicKey: key, Endpoint: endpoint}}
+	}
+	s := &State{EndpointStabilityWindow: 10 * time.Second}
+	start := time.Now()
+
+	cfgs := peers(oldEndpoint)
+	s.debounceEndpoints(cfgs, start)
+	assert.Equal(t, oldEndpoint, cfgs[0].Endpoint, "first endpoint must be used right away")
+
+	cfgs = peers(newEndpoint)
+	s.debounceEndpoints(cfgs, start.Add(time.Second))
+	assert.Equal(t, oldEndpoint, cfgs[0].Endpoint, "new endpoint must be held back")
+	assert.Equal(t, start.Add(11*time.Second), s.NextEndpointUpdate())
+
+	cfgs = peers(oldEndpoint)
+	s.debounceEndpoints(cfgs, start.Add(2*time.Second))
+	assert.Equal(t, oldEndpoint, cfgs[0].Endpoint)
+	assert.True(t, s.NextEndpointUpdate().IsZero(), "reverted flap must not be pending")
+
+	cfgs = peers(newEndpoint)
+	s.debounceEndpoints(cfgs, start.Add(3*time.Second))
+	assert.Equal(t, oldEndpoint, cfgs[0].Endpoint, "flap must restart the window")
+
+	cfgs = peers(newEndpoint)
+	s.debounceEndpoints(cfgs, start.Add(13*time.Second))
+	assert.Equal(t, newEndpoint, cfgs[0].Endpoint, "stable endpoint must be used")
+	assert.True(t, s.NextEndpointUpdate().IsZero())
+}
+
+func Test_State_debounceEndpoints_disabled(t *testing.T) {
+	key := wgtypes.Key{1}
+	s := &State{}
+	s.debounceEndpoints([]wgtypes.PeerConfig{{PublicKey: key, Endpoint: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}}}, time.Now())
+
+	newEndpoint := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}
+	cfgs := []wgtypes.PeerConfig{{PublicKey: key, Endpoint: newEndpoint}}
+	s.debounceEndpoints(cfgs, time.Now())
+	assert.Equal(t, newEndpoint, cfgs[0].Endpoint)
+}
@@ -0,0 +1,55 @@
+package wg
+
+import (
+	"net/netip"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ConfigEvent describes a configuration successfully applied to the wireguard device.
+// It is derived from the exact configuration passed to the device, minus the private key.
+type ConfigEvent struct {
+	Time         time.Time
+	Interface    string
+	OverlayAddr  netip.Addr
+	ListenPort   *int `json:",omitempty"`
+	ReplacePeers bool
+	Peers        []PeerEvent
+}
+
+// PeerEvent describes the configuration applied to a single peer.
+type PeerEvent struct {
+	PublicKey         string
+	Remove            bool   `json:",omitempty"`
+	Endpoint          string `json:",omitempty"`
+	ReplaceAllowedIPs bool
+	AllowedIPs        []string
+}
+
+func newConfigEvent(iface string, overlayAddr netip.Addr, cfg wgtypes.Config) ConfigEvent {
+	event := ConfigEvent{
+		Time:         time.Now(),
+		Interface:    iface,
+		OverlayAddr:  overlayAddr,
+		ListenPort:   cfg.ListenPort,
+		ReplacePeers: cfg.ReplacePeers,
+		Peers:        make([]PeerEvent, len(cfg.Peers)),
+	}
+	for i, peer := range cfg.Peers {
+		pe := PeerEvent{
+			PublicKey:         peer.PublicKey.String(),
+			Remove:            peer.Remove,
+			ReplaceAllowedIPs: peer.ReplaceAllowedIPs,
+			AllowedIPs:        make([]string, len(peer.AllowedIPs)),
+		}
+		if peer.Endpoint != nil {
+			pe.Endpoint = peer.Endpoint.String()
+		}
+		for j, allowedIP := range peer.AllowedIPs {
+			pe.AllowedIPs[j] = allowedIP.String()
+		}
+		event.Peers[i] = pe
+	}
+	return event
+}
@@ -0,0 +1,39 @@
+package wg
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_newConfigEvent(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	port := 51820
+
+	event := newConfigEvent("wgtest", netip.MustParseAddr("10.0.0.1"), wgtypes.Config{
+		PrivateKey:   &privKey,
+		ListenPort:   &port,
+		ReplacePeers: true,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:  peerKey.PublicKey(),
+			Endpoint:   &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: port},
+			AllowedIPs: []net.IPNet{{IP: net.ParseIP("10.0.0.2").To4(), Mask: net.CIDRMask(32, 32)}},
+		}},
+	})
+
+	require.Len(t, event.Peers, 1)
+	assert.Equal(t, "192.0.2.1:51820", event.Peers[0].Endpoint)
+	assert.Equal(t, []string{"10.0.0.2/32"}, event.Peers[0].AllowedIPs)
+
+	encoded, err := json.Marshal(event)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), privKey.String())
+}
@@ -0,0 +1,74 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// ErrForeignPeers is returned by SetUpInterface if the interface has peers not configured by wesher, e.g. because it
+// is managed by wg-quick, and TakeOver is not set. The interface is left untouched.
+var ErrForeignPeers = errors.New("interface has foreign peers")
+
+// checkForeignPeers looks for peers on the device neither configured by this State nor about to be.
+// Foreign peers are refused, unless TakeOver is set, in which case they are logged and will be replaced. Unknown peers
+// of a device left behind by a previous wesher instance are not foreign, but stale, and replaced as well.
+func (s *State) checkForeignPeers(peerCfgs []wgtypes.PeerConfig) error {
+	if s.adopted {
+		// peers of an adopted device were configured by a previous wesher instance
+		return nil
+	}
+	device, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	foreign := foreignPeers(device.Peers, peerCfgs, s.configuredPeers)
+	if len(foreign) == 0 {
+		return nil
+	}
+	if s.leftBehind(device) {
+		Logger.Infof("%s was left behind by a previous wesher instance, replacing its %d stale peers", s.iface, len(foreign))
+		return nil
+	}
+	if !s.TakeOver {
+		s.foreign = true
+		return fmt.Errorf("%w: %d peers of %s were not configured by wesher (e.g. by wg-quick)", ErrForeignPeers, len(foreign), s.iface)
+	}
+	for _, key := range foreign {
+		Logger.Warnf("taking over %s: replacing foreign peer %s", s.iface, key)
+	}
+	s.foreign = false
+	return nil
+}
+
+// leftBehind returns whether device was set up by a previous wesher instance which did not clean up, e.g. because it
+// crashed: the device either still uses our persisted private key, carries an alias set by wesher or is in the
+// configured link group.
+func (s *State) leftBehind(device wgtypes.Device) bool {
+	if device.PrivateKey != (wgtypes.Key{}) && device.PrivateKey == s.PrivKey {
+		return true
+	}
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return false
+	}
+	attrs := link.Attrs()
+	return s.ownAlias(attrs.Alias) || (s.Group != 0 && attrs.Group == s.Group)
+}
+
+func foreignPeers(current []wgtypes.Peer, peerCfgs []wgtypes.PeerConfig, configured map[wgtypes.Key]bool) []wgtypes.Key {
+	known := make(map[wgtypes.Key]bool, len(peerCfgs))
+	for _, cfg := range peerCfgs {
+		known[cfg.PublicKey] = true
+	}
+	var foreign []wgtypes.Key
+	for _, peer := range current {
+		if !known[peer.PublicKey] && !configured[peer.PublicKey] {
+			foreign = append(foreign, peer.PublicKey)
+		}
+	}
+	return foreign
+}
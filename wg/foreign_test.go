@@ -0,0 +1,53 @@
+package wg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_foreignPeers(t *testing.T) {
+	keys := make([]wgtypes.Key, 3)
+	for i := range keys {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		keys[i] = privKey.PublicKey()
+	}
+
+	foreign := foreignPeers(
+		[]wgtypes.Peer{{PublicKey: keys[0]}, {PublicKey: keys[1]}, {PublicKey: keys[2]}},
+		[]wgtypes.PeerConfig{{PublicKey: keys[0]}},
+		map[wgtypes.Key]bool{keys[1]: true}, // configured previously, about to be removed
+	)
+
+	assert.Equal(t, []wgtypes.Key{keys[2]}, foreign)
+}
+
+func Test_State_checkForeignPeers(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	s := withCachedDevice(&State{iface: "wgtest"}, &wgtypes.Device{Peers: []wgtypes.Peer{{PublicKey: privKey.PublicKey()}}})
+
+	err = s.checkForeignPeers(nil)
+	assert.True(t, errors.Is(err, ErrForeignPeers))
+
+	s.TakeOver = true
+	assert.NoError(t, s.checkForeignPeers(nil))
+}
+
+// After a crash, the interface is left behind with the peers of the previous instance; as it still uses the persisted
+// key, its unknown peers are stale rather than foreign.
+func Test_State_checkForeignPeers_afterCrash(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	stalePeer, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	s := withCachedDevice(&State{iface: "wgtest", PrivKey: privKey, PubKey: privKey.PublicKey()},
+		&wgtypes.Device{PrivateKey: privKey, Peers: []wgtypes.Peer{{PublicKey: stalePeer.PublicKey()}}})
+
+	assert.NoError(t, s.checkForeignPeers(nil))
+	assert.False(t, s.foreign)
+}
@@ -0,0 +1,133 @@
+package wg
+
+import (
+	"time"
+
+	"github.com/costela/wesher/common"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeerHealth classifies the handshake state of a configured peer.
+// Since wireguard does not report failed handshakes, they are inferred from the handshake times over time. Handshakes
+// only happen when traffic is sent, so idle peers may show as unhealthy too.
+type PeerHealth int
+
+const (
+	// PeerHealthy peers had a handshake within their handshake timeout.
+	PeerHealthy PeerHealth = iota
+	// PeerConnecting peers were configured recently and had no handshake yet.
+	PeerConnecting
+	// PeerNeverHandshaked peers never had a handshake within the handshake timeout since being configured, which
+	// usually means a wrong key or endpoint.
+	PeerNeverHandshaked
+	// PeerStale peers had a handshake once, but not within the handshake timeout, which usually means a network problem.
+	PeerStale
+)
+
+func (h PeerHealth) String() string {
+	switch h {
+	case PeerHealthy:
+		return "healthy"
+	case PeerConnecting:
+		return "connecting"
+	case PeerNeverHandshaked:
+		return "never-handshaked"
+	case PeerStale:
+		return "stale"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerHealth returns the health of each of the given nodes configured as peers during the last SetUpInterface, keyed
+// by public key.
+func (s *State) PeerHealth(nodes []common.Node) (map[string]PeerHealth, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return s.peerHealth(device.Peers, nodes, time.Now()), nil
+}
+
+func (s *State) peerHealth(peers []wgtypes.Peer, nodes []common.Node, now time.Time) map[string]PeerHealth {
+	handshakes := make(map[wgtypes.Key]time.Time, len(peers))
+	for _, peer := range peers {
+		handshakes[peer.PublicKey] = peer.LastHandshakeTime
+	}
+
+	health := make(map[string]PeerHealth, len(nodes))
+	for _, node := range nodes {
+		key, err := wgtypes.ParseKey(node.PubKey)
+		if err != nil {
+			continue
+		}
+		configuredAt, ok := s.peersConfiguredAt[key]
+		if !ok {
+			continue
+		}
+		timeout := s.handshakeTimeout(node)
+		handshake := handshakes[key]
+		switch {
+		case !handshake.IsZero() && now.Sub(handshake) < timeout:
+			health[node.PubKey] = PeerHealthy
+		case handshake.IsZero() && now.Sub(configuredAt) < timeout:
+			health[node.PubKey] = PeerConnecting
+		case handshake.IsZero():
+			health[node.PubKey] = PeerNeverHandshaked
+		default:
+			health[node.PubKey] = PeerStale
+		}
+	}
+	return health
+}
+
+// recordConfiguredPeers keeps track of when each peer was first configured, forgetting removed peers.
+func (s *State) recordConfiguredPeers(peerCfgs []wgtypes.PeerConfig, now time.Time) {
+	configuredAt := make(map[wgtypes.Key]time.Time, len(peerCfgs))
+	for _, cfg := range peerCfgs {
+		if t, ok := s.peersConfiguredAt[cfg.PublicKey]; ok {
+			configuredAt[cfg.PublicKey] = t
+		} else {
+			configuredAt[cfg.PublicKey] = now
+		}
+	}
+	s.peersConfiguredAt = configuredAt
+}
+
+// HealthSummary summarizes the handshake state of all configured peers.
+type HealthSummary struct {
+	// Score is the fraction of peers with a recent handshake; 1 if no peers are configured
+	Score        float64 `json:"score"`
+	HealthyPeers int     `json:"healthy_peers"`
+	TotalPeers   int     `json:"total_peers"`
+}
+
+// HealthScore returns the fraction of peers configured on the device with a handshake within HandshakeTimeout, between
+// 0 and 1. A device without peers is considered healthy.
+func (s *State) HealthScore() (float64, error) {
+	summary, err := s.Health()
+	return summary.Score, err
+}
+
+// Health returns the HealthSummary of the peers configured on the device.
+func (s *State) Health() (HealthSummary, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return HealthSummary{}, err
+	}
+	return s.healthSummary(device.Peers, time.Now()), nil
+}
+
+func (s *State) healthSummary(peers []wgtypes.Peer, now time.Time) HealthSummary {
+	summary := HealthSummary{Score: 1, TotalPeers: len(peers)}
+	timeout := s.handshakeTimeout(common.Node{})
+	for _, peer := range peers {
+		if !peer.LastHandshakeTime.IsZero() && now.Sub(peer.LastHandshakeTime) < timeout {
+			summary.HealthyPeers++
+		}
+	}
+	if summary.TotalPeers > 0 {
+		summary.Score = float64(summary.HealthyPeers) / float64(summary.TotalPeers)
+	}
+	return summary
+}
@@ -0,0 +1,64 @@
+package wg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_peerHealth(t *testing.T) {
+	now := time.Now()
+	s := &State{HandshakeTimeout: time.Minute}
+
+	var nodes []common.Node
+	var peers []wgtypes.Peer
+	var cfgs []wgtypes.PeerConfig
+	for i, handshake := range []time.Time{now.Add(-time.Second), {}, {}, now.Add(-time.Hour)} {
+		key := wgtypes.Key{byte(i + 1)}
+		node := common.Node{}
+		node.PubKey = key.String()
+		nodes = append(nodes, node)
+		peers = append(peers, wgtypes.Peer{PublicKey: key, LastHandshakeTime: handshake})
+		cfgs = append(cfgs, wgtypes.PeerConfig{PublicKey: key})
+	}
+	s.recordConfiguredPeers(cfgs[1:], now) // only the second node was configured recently
+	s.peersConfiguredAt[cfgs[0].PublicKey] = now.Add(-time.Hour)
+	s.peersConfiguredAt[cfgs[2].PublicKey] = now.Add(-time.Hour)
+
+	assert.Equal(t, map[string]PeerHealth{
+		nodes[0].PubKey: PeerHealthy,
+		nodes[1].PubKey: PeerConnecting,
+		nodes[2].PubKey: PeerNeverHandshaked,
+		nodes[3].PubKey: PeerStale,
+	}, s.peerHealth(peers, nodes, now))
+}
+
+func Test_State_recordConfiguredPeers(t *testing.T) {
+	s := &State{}
+	first := time.Now()
+	s.recordConfiguredPeers([]wgtypes.PeerConfig{{PublicKey: wgtypes.Key{1}}}, first)
+	s.recordConfiguredPeers([]wgtypes.PeerConfig{{PublicKey: wgtypes.Key{1}}, {PublicKey: wgtypes.Key{2}}}, first.Add(time.Minute))
+	assert.Equal(t, first, s.peersConfiguredAt[wgtypes.Key{1}], "configuration time must be kept")
+	assert.Equal(t, first.Add(time.Minute), s.peersConfiguredAt[wgtypes.Key{2}])
+
+	s.recordConfiguredPeers(nil, first.Add(2*time.Minute))
+	assert.Empty(t, s.peersConfiguredAt)
+}
+
+func Test_State_healthSummary(t *testing.T) {
+	now := time.Now()
+	s := &State{HandshakeTimeout: time.Minute}
+
+	assert.Equal(t, HealthSummary{Score: 1}, s.healthSummary(nil, now), "no peers must be healthy")
+
+	peers := []wgtypes.Peer{
+		{LastHandshakeTime: now.Add(-time.Second)},
+		{LastHandshakeTime: now.Add(-10 * time.Second)},
+		{LastHandshakeTime: now.Add(-time.Hour)},
+		{},
+	}
+	assert.Equal(t, HealthSummary{Score: 0.5, HealthyPeers: 2, TotalPeers: 4}, s.healthSummary(peers, now))
+}
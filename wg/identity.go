@@ -0,0 +1,44 @@
+package wg
+
+import (
+	"hash/fnv"
+	"net"
+	"strings"
+)
+
+// AliasIdentity is the Alias value requesting an alias derived from the node name, see IdentityAlias.
+const AliasIdentity = "identity"
+
+// identityAliasPrefix starts all aliases returned by IdentityAlias.
+const identityAliasPrefix = "wesher "
+
+// IdentityAlias returns a stable interface alias for the node called name, for tools tracking interfaces by hardware
+// address. Wireguard interfaces have no link-layer address, so the alias carries a locally administered pseudo-MAC
+// derived from the name instead; it stays the same across recreations of the interface.
+func IdentityAlias(name string) string {
+	return identityAliasPrefix + pseudoMAC(name).String()
+}
+
+// pseudoMAC derives a locally administered unicast MAC address from name.
+func pseudoMAC(name string) net.HardwareAddr {
+	h := fnv.New64a()
+	h.Write([]byte(name)) // nolint: errcheck // never fails
+	sum := h.Sum(nil)
+	mac := net.HardwareAddr(sum[:6])
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac
+}
+
+// alias returns the alias to set on the interface, resolving AliasIdentity.
+func (s *State) alias() string {
+	if s.Alias == AliasIdentity {
+		return IdentityAlias(s.name)
+	}
+	return s.Alias
+}
+
+// ownAlias returns whether current, the alias found on an existing interface, was set by wesher: it is the configured
+// alias or an identity alias.
+func (s *State) ownAlias(current string) bool {
+	return current != "" && (current == s.alias() || strings.HasPrefix(current, identityAliasPrefix))
+}
@@ -0,0 +1,42 @@
+package wg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IdentityAlias(t *testing.T) {
+	alias := IdentityAlias("node1")
+	assert.Equal(t, alias, IdentityAlias("node1"), "alias must be stable")
+	assert.NotEqual(t, alias, IdentityAlias("node2"))
+	assert.Regexp(t, `^wesher [0-9a-f]{2}(:[0-9a-f]{2}){5}$`, alias)
+}
+
+func Test_pseudoMAC(t *testing.T) {
+	for _, name := range []string{"", "node1", "node2.example.com"} {
+		mac := pseudoMAC(name)
+		assert.Len(t, mac, 6)
+		assert.Equal(t, byte(0x02), mac[0]&0x03, "%s must be a locally administered unicast address", mac)
+	}
+}
+
+func Test_State_alias(t *testing.T) {
+	s := &State{name: "node1"}
+	assert.Equal(t, "", s.alias())
+	s.Alias = "custom"
+	assert.Equal(t, "custom", s.alias())
+	s.Alias = AliasIdentity
+	assert.Equal(t, IdentityAlias("node1"), s.alias())
+}
+
+func Test_State_ownAlias(t *testing.T) {
+	s := &State{name: "node1", Alias: "custom"}
+	assert.True(t, s.ownAlias("custom"))
+	assert.True(t, s.ownAlias(IdentityAlias("node2")))
+	assert.False(t, s.ownAlias(""))
+	assert.False(t, s.ownAlias("vpn"))
+	s.Alias = ""
+	assert.False(t, s.ownAlias(""))
+	assert.True(t, s.ownAlias(IdentityAlias("node1")))
+}
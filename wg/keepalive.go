@@ -0,0 +1,24 @@
+package wg
+
+import (
+	"net"
+	"time"
+)
+
+// keepalive returns the persistent keepalive interval for peers reached via endpoint: the one configured for the
+// endpoint's address family, or PersistentKeepalive otherwise. Nil is returned if no interval is configured, leaving
+// keepalives disabled.
+func (s *State) keepalive(endpoint net.IP) *time.Duration {
+	interval := s.PersistentKeepalive
+	if endpoint.To4() != nil {
+		if s.PersistentKeepaliveIPv4 != 0 {
+			interval = s.PersistentKeepaliveIPv4
+		}
+	} else if endpoint != nil && s.PersistentKeepaliveIPv6 != 0 {
+		interval = s.PersistentKeepaliveIPv6
+	}
+	if interval == 0 {
+		return nil
+	}
+	return &interval
+}
@@ -0,0 +1,30 @@
+package wg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_State_keepalive(t *testing.T) {
+	ipv4, ipv6 := net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")
+
+	s := &State{}
+	assert.Nil(t, s.keepalive(ipv4), "keepalives must be disabled by default")
+
+	s.PersistentKeepalive = 25 * time.Second
+	assert.Equal(t, 25*time.Second, *s.keepalive(ipv4))
+	assert.Equal(t, 25*time.Second, *s.keepalive(ipv6))
+
+	s.PersistentKeepaliveIPv4 = 10 * time.Second
+	assert.Equal(t, 10*time.Second, *s.keepalive(ipv4))
+	assert.Equal(t, 25*time.Second, *s.keepalive(ipv6), "the global value must apply to families without their own")
+
+	s.PersistentKeepalive = 0
+	s.PersistentKeepaliveIPv6 = time.Minute
+	assert.Equal(t, 10*time.Second, *s.keepalive(ipv4))
+	assert.Equal(t, time.Minute, *s.keepalive(ipv6))
+	assert.Nil(t, s.keepalive(nil))
+}
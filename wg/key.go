@@ -0,0 +1,72 @@
+package wg
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RotateKey generates a new key pair and applies its private key to the wireguard device, leaving peers untouched.
+// It returns the new public key; announcing it to the cluster is up to the caller, see SetPrivKey. Until peers learn the
+// new key, they cannot complete handshakes with this node.
+func (s *State) RotateKey() (*wgtypes.Key, error) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generating private key: %w", err)
+	}
+
+	if err := s.SetPrivKey(privKey); err != nil {
+		return nil, err
+	}
+	pubKey := s.PubKey
+
+	return &pubKey, nil
+}
+
+// SetPrivKey replaces the key pair with the one of privKey, e.g. when an external key management system delivers it
+// after startup. If the wireguard device exists, the private key is applied to it right away, leaving peers untouched;
+// otherwise SetUpInterface applies it later. The key is then persisted, if a key file is used, so that restarts keep
+// it; if that fails, the device is reverted to the previous key. With AddrFromPubKey, a new overlay address is derived
+// from the new public key and set by the next SetUpInterface.
+// The State does not gossip: callers must announce the new PubKey and OverlayAddr to the cluster (i.e. update the local
+// common.Node and pass it to cluster.Update), as peers cannot complete handshakes with this node until then.
+func (s *State) SetPrivKey(privKey wgtypes.Key) error {
+	client, err := s.lazyClient()
+	if err != nil {
+		return err
+	}
+	applied := false
+	if _, err := client.Device(s.iface); err == nil {
+		if err := s.configureDevice(wgtypes.Config{PrivateKey: &privKey}); err != nil {
+			return fmt.Errorf("setting private key: %w", err)
+		}
+		applied = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("getting device %s: %w", s.iface, err)
+	}
+	if err := saveKey(s.keyFile, privKey); err != nil {
+		if applied && s.PrivKey != (wgtypes.Key{}) {
+			// keep the device consistent with the persisted key
+			if err := s.configureDevice(wgtypes.Config{PrivateKey: &s.PrivKey}); err != nil {
+				Logger.WithError(err).Errorf("could not restore previous private key of %s", s.iface)
+			}
+		}
+		return err
+	}
+
+	s.PrivKey = privKey
+	s.PubKey = privKey.PublicKey()
+	if s.addrOpts.From == AddrFromPubKey {
+		oldAddr := s.OverlayAddr
+		if err := s.assignOverlayAddr(s.prefix, s.name, s.wgAddress); err != nil {
+			return fmt.Errorf("assigning overlay address: %w", err)
+		}
+		// the new address is set by the next SetUpInterface, but the old one must not linger
+		if link, err := netlink.LinkByName(s.iface); err == nil && oldAddr.IsValid() && oldAddr != s.OverlayAddr {
+			netlink.AddrDel(link, &netlink.Addr{IPNet: addrToIPNet(oldAddr)}) // nolint: errcheck // opportunistic
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package wg
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// missingDeviceClient behaves like the kernel client before the device is created.
+type missingDeviceClient struct {
+	configRecorder
+}
+
+func (c *missingDeviceClient) Device(name string) (*wgtypes.Device, error) {
+	return nil, os.ErrNotExist
+}
+
+func Test_State_SetPrivKey(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	client := &configRecorder{}
+	s := &State{iface: "wgtest"}
+	s.SetClient(client)
+
+	require.NoError(t, s.SetPrivKey(privKey))
+	assert.Equal(t, privKey, s.PrivKey)
+	assert.Equal(t, privKey.PublicKey(), s.PubKey)
+	require.Len(t, client.configs, 1)
+	assert.Equal(t, privKey, *client.configs[0].PrivateKey)
+	assert.False(t, client.configs[0].ReplacePeers, "peers must be kept")
+	assert.Empty(t, client.configs[0].Peers)
+}
+
+func Test_State_SetPrivKey_before_setup(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	client := &missingDeviceClient{}
+	s := &State{iface: "wgtest"}
+	s.SetClient(client)
+
+	require.NoError(t, s.SetPrivKey(privKey))
+	assert.Equal(t, privKey.PublicKey(), s.PubKey)
+	assert.Empty(t, client.configs, "a missing device must not be configured")
+}
+
+func Test_State_SetPrivKey_rollback(t *testing.T) {
+	oldKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	newKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	// the key file cannot be written below a regular file
+	notDir := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(notDir, nil, 0600))
+	client := &configRecorder{}
+	s := &State{iface: "wgtest", keyFile: filepath.Join(notDir, "wgtest.key"), PrivKey: oldKey, PubKey: oldKey.PublicKey()}
+	s.SetClient(client)
+
+	require.Error(t, s.SetPrivKey(newKey))
+	assert.Equal(t, oldKey, s.PrivKey)
+	require.Len(t, client.configs, 2)
+	assert.Equal(t, newKey, *client.configs[0].PrivateKey)
+	assert.Equal(t, oldKey, *client.configs[1].PrivateKey, "the previous key must be restored")
+}
+
+func Test_State_SetPrivKey_addrFromPubKey(t *testing.T) {
+	s := &State{
+		iface:    "wgtest",
+		prefix:   netip.MustParsePrefix("10.0.0.0/8"),
+		name:     "node1",
+		addrOpts: AddrOptions{From: AddrFromPubKey},
+	}
+	s.SetClient(&missingDeviceClient{})
+
+	var addrs []netip.Addr
+	for i := 0; i < 2; i++ {
+		privKey, err := wgtypes.GeneratePrivateKey()
+		require.NoError(t, err)
+		require.NoError(t, s.SetPrivKey(privKey))
+		assert.True(t, s.prefix.Contains(s.OverlayAddr))
+		addrs = append(addrs, s.OverlayAddr)
+	}
+	assert.NotEqual(t, addrs[0], addrs[1], "the overlay address must follow the public key")
+}
@@ -0,0 +1,77 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// EphemeralKeyFile disables key persistence when used as key file: a new key is generated on every start.
+const EphemeralKeyFile = os.DevNull
+
+// loadOrCreateKey returns the private key stored in keyFile, generating and storing a new one if the file does not
+// exist yet. The file holds the base64 encoded key, like the output of "wg genkey". Without keyFile, or with
+// EphemeralKeyFile, a new key is generated and not stored.
+func loadOrCreateKey(keyFile string) (wgtypes.Key, error) {
+	if keyFile != "" && keyFile != EphemeralKeyFile {
+		content, err := os.ReadFile(keyFile)
+		if err == nil {
+			privKey, err := wgtypes.ParseKey(strings.TrimSpace(string(content)))
+			if err != nil {
+				return wgtypes.Key{}, fmt.Errorf("invalid private key in %s: %w", keyFile, err)
+			}
+			if privKey == (wgtypes.Key{}) {
+				return wgtypes.Key{}, fmt.Errorf("invalid private key in %s: key is all zeros", keyFile)
+			}
+			return privKey, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return wgtypes.Key{}, fmt.Errorf("reading private key: %w", err)
+		}
+	}
+
+	privKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("generating private key: %w", err)
+	}
+	if err := saveKey(keyFile, privKey); err != nil {
+		return wgtypes.Key{}, err
+	}
+	return privKey, nil
+}
+
+// saveKey atomically replaces the contents of keyFile with privKey, readable only by the owner. It is a noop without
+// keyFile or with EphemeralKeyFile.
+func saveKey(keyFile string, privKey wgtypes.Key) error {
+	if keyFile == "" || keyFile == EphemeralKeyFile {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return fmt.Errorf("creating private key directory: %w", err)
+	}
+	// CreateTemp creates files with 0600 permissions
+	tmp, err := os.CreateTemp(filepath.Dir(keyFile), filepath.Base(keyFile)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck // fails after successful renames
+	if _, err := tmp.WriteString(privKey.String() + "\n"); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), keyFile); err != nil {
+		return fmt.Errorf("writing private key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package wg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_loadOrCreateKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "wesher", "wgoverlay.key")
+
+	created, err := loadOrCreateKey(keyFile)
+	require.NoError(t, err)
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := loadOrCreateKey(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, created, loaded)
+	assert.Equal(t, created.PublicKey(), loaded.PublicKey(), "the public key must be stable across restarts")
+}
+
+func Test_loadOrCreateKey_ephemeral(t *testing.T) {
+	for _, keyFile := range []string{"", EphemeralKeyFile} {
+		first, err := loadOrCreateKey(keyFile)
+		require.NoError(t, err)
+		second, err := loadOrCreateKey(keyFile)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	}
+}
+
+func Test_loadOrCreateKey_corrupt(t *testing.T) {
+	for name, content := range map[string]string{
+		"garbage":   "not a key\n",
+		"truncated": "aGVsbG8=\n",
+		"zero":      wgtypes.Key{}.String(),
+		"empty":     "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			keyFile := filepath.Join(t.TempDir(), "wgoverlay.key")
+			require.NoError(t, os.WriteFile(keyFile, []byte(content), 0600))
+
+			_, err := loadOrCreateKey(keyFile)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), keyFile)
+
+			kept, err := os.ReadFile(keyFile)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(kept), "corrupt keys must not be overwritten")
+		})
+	}
+}
+
+func Test_State_SetPrivKey_persists(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "wgoverlay.key")
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	s := &State{iface: "wgtest", keyFile: keyFile}
+	s.SetClient(&missingDeviceClient{})
+
+	require.NoError(t, s.SetPrivKey(privKey))
+	loaded, err := loadOrCreateKey(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, privKey, loaded)
+}
@@ -0,0 +1,66 @@
+package wg
+
+import (
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// recoverConfigure recovers from cfg failing to apply with cause. Since the kernel applies peers one by one, a peer
+// rejected halfway leaves the device partially configured: all peers are retried individually, isolating the offending
+// ones. Peers applied before the failure are retried as well, which is harmless, since the device cannot tell which
+// updates of existing peers got applied. The device is re-queried for peers to be replaced. The public keys of the
+// peers rejected again are returned; cause is returned if the device settings themselves cannot be applied.
+func (s *State) recoverConfigure(cfg wgtypes.Config, cause error) (map[wgtypes.Key]error, error) {
+	client, err := s.lazyClient()
+	if err != nil {
+		return nil, cause
+	}
+	device, err := client.Device(s.iface)
+	if err != nil {
+		return nil, cause
+	}
+	present := make(map[wgtypes.Key]bool, len(device.Peers))
+	for _, peer := range device.Peers {
+		present[peer.PublicKey] = true
+	}
+
+	settings := cfg
+	settings.Peers = nil
+	settings.ReplacePeers = false
+	if err := s.configureDevice(settings); err != nil {
+		return nil, cause
+	}
+
+	wanted := make(map[wgtypes.Key]bool, len(cfg.Peers))
+	retry := append([]wgtypes.PeerConfig(nil), cfg.Peers...)
+	for _, peer := range cfg.Peers {
+		wanted[peer.PublicKey] = !peer.Remove
+	}
+	if cfg.ReplacePeers {
+		// peers to be replaced may not have been removed before the failure
+		for key := range present {
+			if !wanted[key] {
+				retry = append(retry, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+			}
+		}
+	}
+
+	rejected := map[wgtypes.Key]error{}
+	for _, peer := range retry {
+		if err := s.configureDevice(wgtypes.Config{Peers: []wgtypes.PeerConfig{peer}}); err != nil {
+			Logger.WithError(err).Errorf("peer %s rejected by device %s", peer.PublicKey, s.iface)
+			rejected[peer.PublicKey] = err
+		}
+	}
+	return rejected, nil
+}
+
+// withoutRejected returns peerCfgs without the rejected peers.
+func withoutRejected(peerCfgs []wgtypes.PeerConfig, rejected map[wgtypes.Key]error) []wgtypes.PeerConfig {
+	kept := make([]wgtypes.PeerConfig, 0, len(peerCfgs))
+	for _, cfg := range peerCfgs {
+		if _, ok := rejected[cfg.PublicKey]; !ok {
+			kept = append(kept, cfg)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,93 @@
+package wg
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// partialClient applies peers one by one like the kernel does, failing at the first peer in bad.
+type partialClient struct {
+	peers     map[wgtypes.Key]bool
+	bad       map[wgtypes.Key]bool
+	endpoints map[wgtypes.Key]string
+}
+
+func (c *partialClient) Device(name string) (*wgtypes.Device, error) {
+	device := &wgtypes.Device{Name: name}
+	for key := range c.peers {
+		device.Peers = append(device.Peers, wgtypes.Peer{PublicKey: key})
+	}
+	return device, nil
+}
+
+func (c *partialClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if cfg.ReplacePeers {
+		c.peers = map[wgtypes.Key]bool{}
+	}
+	for _, peer := range cfg.Peers {
+		if c.bad[peer.PublicKey] {
+			return errors.New("invalid argument")
+		}
+		if peer.Remove {
+			delete(c.peers, peer.PublicKey)
+		} else {
+			c.peers[peer.PublicKey] = true
+			if peer.Endpoint != nil {
+				c.endpoints[peer.PublicKey] = peer.Endpoint.String()
+			}
+		}
+	}
+	return nil
+}
+
+func Test_State_recoverConfigure(t *testing.T) {
+	stale, good1, bad, good2 := wgtypes.Key{1}, wgtypes.Key{2}, wgtypes.Key{3}, wgtypes.Key{4}
+	client := &partialClient{
+		peers:     map[wgtypes.Key]bool{stale: true},
+		bad:       map[wgtypes.Key]bool{bad: true},
+		endpoints: map[wgtypes.Key]string{},
+	}
+	s := &State{iface: "wgtest"}
+	s.SetClient(client)
+
+	peerCfgs := []wgtypes.PeerConfig{{PublicKey: good1}, {PublicKey: bad}, {PublicKey: good2}}
+	cfg := wgtypes.Config{ReplacePeers: true, Peers: peerCfgs}
+	err := s.configureDevice(cfg)
+	require.Error(t, err)
+	assert.Equal(t, map[wgtypes.Key]bool{good1: true}, client.peers, "configuration must stop at the bad peer")
+
+	rejected, err := s.recoverConfigure(cfg, err)
+	require.NoError(t, err)
+	assert.Len(t, rejected, 1)
+	assert.Contains(t, rejected, bad)
+	assert.Equal(t, map[wgtypes.Key]bool{good1: true, good2: true}, client.peers)
+	assert.Equal(t, []wgtypes.PeerConfig{{PublicKey: good1}, {PublicKey: good2}}, withoutRejected(peerCfgs, rejected))
+}
+
+// Updates of existing peers behind the rejected one must be applied, too, although those peers are already present.
+func Test_State_recoverConfigure_updatedPeer(t *testing.T) {
+	bad, existing := wgtypes.Key{1}, wgtypes.Key{2}
+	client := &partialClient{
+		peers:     map[wgtypes.Key]bool{existing: true},
+		bad:       map[wgtypes.Key]bool{bad: true},
+		endpoints: map[wgtypes.Key]string{existing: "192.0.2.1:51820"},
+	}
+	s := &State{iface: "wgtest"}
+	s.SetClient(client)
+
+	moved := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 51820}
+	cfg := wgtypes.Config{Peers: []wgtypes.PeerConfig{{PublicKey: bad}, {PublicKey: existing, Endpoint: moved}}}
+	err := s.configureDevice(cfg)
+	require.Error(t, err)
+
+	rejected, err := s.recoverConfigure(cfg, err)
+	require.NoError(t, err)
+	assert.Contains(t, rejected, bad)
+	assert.NotContains(t, rejected, existing)
+	assert.Equal(t, "192.0.2.2:51820", client.endpoints[existing])
+}
@@ -0,0 +1,45 @@
+package wg
+
+import (
+	"net"
+
+	"github.com/costela/wesher/common"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// addedPeer is a peer configured for the first time, along with the endpoint it was configured with.
+type addedPeer struct {
+	node     common.Node
+	endpoint *net.UDPAddr
+}
+
+// addedPeers returns the peers in peerCfgs which were not configured before. It must be called before
+// recordConfiguredPeers.
+func (s *State) addedPeers(nodes []common.Node, peerCfgs []wgtypes.PeerConfig) []addedPeer {
+	byKey := make(map[string]common.Node, len(nodes))
+	for _, node := range nodes {
+		byKey[node.PubKey] = node
+	}
+	var added []addedPeer
+	for _, cfg := range peerCfgs {
+		if _, ok := s.peersConfiguredAt[cfg.PublicKey]; ok {
+			continue
+		}
+		node, ok := byKey[cfg.PublicKey.String()]
+		if !ok {
+			continue
+		}
+		added = append(added, addedPeer{node: node, endpoint: cfg.Endpoint})
+	}
+	return added
+}
+
+// notifyAddedPeers calls OnPeerAdd, if set, for each added peer.
+func (s *State) notifyAddedPeers(added []addedPeer) {
+	if s.OnPeerAdd == nil {
+		return
+	}
+	for _, peer := range added {
+		s.OnPeerAdd(peer.node, peer.endpoint)
+	}
+}
@@ -0,0 +1,46 @@
+package wg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_addedPeers(t *testing.T) {
+	s := &State{}
+	var nodes []common.Node
+	var cfgs []wgtypes.PeerConfig
+	for i := 0; i < 3; i++ {
+		key := wgtypes.Key{byte(i + 1)}
+		node := common.Node{}
+		node.PubKey = key.String()
+		nodes = append(nodes, node)
+		cfgs = append(cfgs, wgtypes.PeerConfig{PublicKey: key, Endpoint: &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i+1)), Port: 51820}})
+	}
+
+	var notified []string
+	s.OnPeerAdd = func(node common.Node, endpoint *net.UDPAddr) {
+		notified = append(notified, node.PubKey+"@"+endpoint.String())
+	}
+	update := func(cfgs []wgtypes.PeerConfig) {
+		added := s.addedPeers(nodes, cfgs)
+		s.recordConfiguredPeers(cfgs, time.Now())
+		s.notifyAddedPeers(added)
+	}
+
+	update(cfgs[:2])
+	assert.Equal(t, []string{nodes[0].PubKey + "@192.0.2.1:51820", nodes[1].PubKey + "@192.0.2.2:51820"}, notified)
+
+	notified = nil
+	update(cfgs)
+	assert.Equal(t, []string{nodes[2].PubKey + "@192.0.2.3:51820"}, notified, "only new peers must be reported")
+
+	notified = nil
+	update(cfgs[1:])
+	update(cfgs)
+	assert.Equal(t, []string{nodes[0].PubKey + "@192.0.2.1:51820"}, notified, "removed peers must be reported when added again")
+}
@@ -0,0 +1,79 @@
+package wg
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// NetworkPolicy describes the routing intent for a single peer, as currently applied to the system.
+type NetworkPolicy struct {
+	PubKey     wgtypes.Key
+	AllowedIPs []net.IPNet
+	// RoutedIPs holds the allowed IPs with a route via the wireguard interface in the route table used by the State.
+	RoutedIPs []net.IPNet
+	// Handshake is set if the peer had a handshake within the State's handshake timeout.
+	Handshake bool
+}
+
+// Routed returns whether traffic to any of the peer's allowed IPs is routed via the wireguard interface.
+func (p NetworkPolicy) Routed() bool {
+	return len(p.RoutedIPs) > 0
+}
+
+// NetworkPolicies returns the policy of every peer configured on the wireguard device, joining the device
+// configuration with the routes via its interface.
+func (s *State) NetworkPolicies() ([]NetworkPolicy, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return nil, fmt.Errorf("getting link information for %s: %w", s.iface, err)
+	}
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Table:     s.RouteTable,
+	}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("listing routes via %s: %w", s.iface, err)
+	}
+	return s.networkPolicies(device.Peers, routes, time.Now()), nil
+}
+
+func (s *State) networkPolicies(peers []wgtypes.Peer, routes []netlink.Route, now time.Time) []NetworkPolicy {
+	timeout := s.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	policies := make([]NetworkPolicy, 0, len(peers))
+	for _, peer := range peers {
+		policy := NetworkPolicy{
+			PubKey:     peer.PublicKey,
+			AllowedIPs: peer.AllowedIPs,
+			Handshake:  !peer.LastHandshakeTime.IsZero() && now.Sub(peer.LastHandshakeTime) < timeout,
+		}
+		for _, allowed := range peer.AllowedIPs {
+			for _, route := range routes {
+				if route.Dst != nil && ipNetContains(*route.Dst, allowed) {
+					policy.RoutedIPs = append(policy.RoutedIPs, allowed)
+					break
+				}
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// ipNetContains returns whether outer contains all addresses of inner.
+func ipNetContains(outer, inner net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
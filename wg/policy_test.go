@@ -0,0 +1,41 @@
+package wg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_networkPolicies(t *testing.T) {
+	now := time.Now()
+	mustParseCIDR := func(s string) net.IPNet {
+		_, ipnet, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return *ipnet
+	}
+	host := mustParseCIDR("10.0.0.1/32")
+	private := mustParseCIDR("192.168.0.0/16")
+	routeDst := mustParseCIDR("10.0.0.1/32")
+
+	peers := []wgtypes.Peer{
+		{PublicKey: wgtypes.Key{1}, AllowedIPs: []net.IPNet{host, private}, LastHandshakeTime: now.Add(-time.Second)},
+		{PublicKey: wgtypes.Key{2}, AllowedIPs: []net.IPNet{mustParseCIDR("10.0.0.2/32")}},
+	}
+	routes := []netlink.Route{{Dst: &routeDst}}
+
+	s := &State{}
+	policies := s.networkPolicies(peers, routes, now)
+	require.Len(t, policies, 2)
+
+	assert.Equal(t, []net.IPNet{host}, policies[0].RoutedIPs)
+	assert.True(t, policies[0].Routed())
+	assert.True(t, policies[0].Handshake)
+
+	assert.False(t, policies[1].Routed())
+	assert.False(t, policies[1].Handshake)
+}
@@ -0,0 +1,65 @@
+package wg
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/costela/wesher/common"
+)
+
+// DeferredPeers returns the number of peers left out during the last SetUpInterface because their endpoint appeared
+// unreachable. Callers should retry setting up the interface later while this is non-zero.
+func (s *State) DeferredPeers() int {
+	return s.deferredPeers
+}
+
+// reachableNodes probes the wireguard endpoints of all nodes in parallel and returns those which may be reachable.
+func (s *State) reachableNodes(nodes []common.Node) []common.Node {
+	reachable := make([]bool, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node common.Node) {
+			defer wg.Done()
+			reachable[i] = probeEndpoint(&net.UDPAddr{IP: s.resolveEndpointIP(node), Port: s.Port}, s.PeerProbeTimeout)
+		}(i, node)
+	}
+	wg.Wait()
+
+	filtered := make([]common.Node, 0, len(nodes))
+	for i, node := range nodes {
+		if reachable[i] {
+			filtered = append(filtered, node)
+		} else {
+			Logger.Infof("endpoint of %s seems unreachable, deferring its configuration", node.Name)
+		}
+	}
+	s.deferredPeers = len(nodes) - len(filtered)
+
+	return filtered
+}
+
+// probeEndpoint sends a single UDP packet to addr and waits up to timeout for an ICMP error.
+// Since wireguard silently drops invalid packets, the absence of an answer is only a hint the endpoint is reachable,
+// while an error reliably means it is not.
+func probeEndpoint(addr *net.UDPAddr, timeout time.Duration) bool {
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return !isUnreachable(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout)) // nolint: errcheck // cannot fail on a fresh connection
+
+	if _, err := conn.Write([]byte{0}); err != nil {
+		return !isUnreachable(err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	return !isUnreachable(err)
+}
+
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH)
+}
@@ -0,0 +1,29 @@
+package wg
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_probeEndpoint(t *testing.T) {
+	// a listener which never answers, like wireguard
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	assert.True(t, probeEndpoint(listener.LocalAddr().(*net.UDPAddr), 100*time.Millisecond))
+}
+
+func Test_probeEndpoint_closed(t *testing.T) {
+	// grab a free port and close it again, so the kernel answers with port unreachable
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := listener.LocalAddr().(*net.UDPAddr)
+	listener.Close()
+
+	assert.False(t, probeEndpoint(addr, time.Second))
+}
@@ -0,0 +1,34 @@
+package wg
+
+import (
+	"sort"
+
+	"github.com/costela/wesher/common"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PSKGroupNames returns the sorted names of the configured PSK groups, to be announced to the cluster.
+func (s *State) PSKGroupNames() []string {
+	names := make([]string, 0, len(s.PSKGroups))
+	for name := range s.PSKGroups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// presharedKey returns the preshared key to use with node: the key of the first (by name) PSK group both are members
+// of. Since both sides pick the same group, they agree on the key.
+func (s *State) presharedKey(node common.Node) (wgtypes.Key, bool) {
+	if !node.HasCapability(common.CapPSK) {
+		return wgtypes.Key{}, false
+	}
+	groups := append([]string(nil), node.PSKGroups...)
+	sort.Strings(groups)
+	for _, group := range groups {
+		if psk, ok := s.PSKGroups[group]; ok {
+			return psk, true
+		}
+	}
+	return wgtypes.Key{}, false
+}
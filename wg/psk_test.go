@@ -0,0 +1,34 @@
+package wg
+
+import (
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_presharedKey(t *testing.T) {
+	pskA, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	pskB, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	s := &State{PSKGroups: map[string]wgtypes.Key{"b": pskB, "a": pskA}}
+
+	node := common.Node{Name: "node"}
+	node.Capabilities = common.CapPSK
+
+	node.PSKGroups = []string{"c"}
+	_, ok := s.presharedKey(node)
+	assert.False(t, ok, "no common group")
+
+	node.PSKGroups = []string{"c", "b", "a"}
+	psk, ok := s.presharedKey(node)
+	assert.True(t, ok)
+	assert.Equal(t, pskA, psk, "first common group must be used")
+
+	node.Capabilities = 0
+	_, ok = s.presharedKey(node)
+	assert.False(t, ok, "nodes without PSK support must not get a PSK")
+}
@@ -0,0 +1,84 @@
+package wg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// QuickPeer holds the peer settings relevant to wesher from a wg-quick configuration file.
+type QuickPeer struct {
+	PublicKey  wgtypes.Key
+	AllowedIPs []netip.Prefix
+	Endpoint   string
+}
+
+// ParseQuickConfig parses the [Peer] sections of a wg-quick configuration file.
+// Other sections and unknown keys are ignored.
+func ParseQuickConfig(r io.Reader) ([]QuickPeer, error) {
+	var peers []QuickPeer
+	var current *QuickPeer
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			current = nil
+			if strings.EqualFold(line, "[Peer]") {
+				peers = append(peers, QuickPeer{})
+				current = &peers[len(peers)-1]
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "publickey":
+			pubKey, err := wgtypes.ParseKey(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: parsing public key: %w", lineNo, err)
+			}
+			current.PublicKey = pubKey
+		case "allowedips":
+			for _, allowedIP := range strings.Split(value, ",") {
+				prefix, err := netip.ParsePrefix(strings.TrimSpace(allowedIP))
+				if err != nil {
+					return nil, fmt.Errorf("line %d: parsing allowed IPs: %w", lineNo, err)
+				}
+				current.AllowedIPs = append(current.AllowedIPs, prefix)
+			}
+		case "endpoint":
+			current.Endpoint = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	for i, peer := range peers {
+		if peer.PublicKey == (wgtypes.Key{}) {
+			return nil, fmt.Errorf("peer %d has no public key", i+1)
+		}
+	}
+
+	return peers, nil
+}
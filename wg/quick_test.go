@@ -0,0 +1,53 @@
+package wg
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseQuickConfig(t *testing.T) {
+	conf := `
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+ListenPort = 51820
+
+# first peer
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.192.122.3/32, 10.192.124.1/24
+Endpoint = 192.95.5.67:1234
+
+[peer]
+publickey = TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=
+AllowedIPs = 10.192.122.4/32 # inline comment
+Endpoint = example.com:51820
+`
+	peers, err := ParseQuickConfig(strings.NewReader(conf))
+	require.NoError(t, err)
+	require.Len(t, peers, 2)
+
+	assert.Equal(t, "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=", peers[0].PublicKey.String())
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.192.122.3/32"), netip.MustParsePrefix("10.192.124.1/24")}, peers[0].AllowedIPs)
+	assert.Equal(t, "192.95.5.67:1234", peers[0].Endpoint)
+
+	assert.Equal(t, "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0=", peers[1].PublicKey.String())
+	assert.Equal(t, "example.com:51820", peers[1].Endpoint)
+}
+
+func Test_ParseQuickConfig_errors(t *testing.T) {
+	for name, conf := range map[string]string{
+		"bad key":        "[Peer]\nPublicKey = foo\n",
+		"bad allowed ip": "[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nAllowedIPs = foo\n",
+		"missing key":    "[Peer]\nAllowedIPs = 10.0.0.1/32\n",
+		"bad line":       "[Peer]\nfoo\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseQuickConfig(strings.NewReader(conf))
+			assert.Error(t, err)
+		})
+	}
+}
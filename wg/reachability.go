@@ -0,0 +1,52 @@
+package wg
+
+import (
+	"net/netip"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// DefaultHandshakeTimeout is the default maximum age of the latest handshake with a peer for it to be considered
+// reachable. Wireguard renews sessions every 2 minutes while traffic flows and rejects sessions older than 3 minutes.
+const DefaultHandshakeTimeout = 3 * time.Minute
+
+// ReachableNodes returns the overlay addresses of the nodes with a recent handshake on the wireguard device.
+// A handshake is recent if it is younger than the node's own HandshakeTimeout, or the State's if the node does not
+// set one.
+func (s *State) ReachableNodes(nodes []common.Node) ([]netip.Addr, error) {
+	device, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return s.handshakeReachableNodes(device.Peers, nodes, time.Now()), nil
+}
+
+func (s *State) handshakeReachableNodes(peers []wgtypes.Peer, nodes []common.Node, now time.Time) []netip.Addr {
+	handshakes := make(map[string]time.Time, len(peers))
+	for _, peer := range peers {
+		handshakes[peer.PublicKey.String()] = peer.LastHandshakeTime
+	}
+
+	var reachable []netip.Addr
+	for _, node := range nodes {
+		handshake, ok := handshakes[node.PubKey]
+		if ok && !handshake.IsZero() && now.Sub(handshake) < s.handshakeTimeout(node) {
+			reachable = append(reachable, node.OverlayAddr)
+		}
+	}
+	return reachable
+}
+
+// handshakeTimeout returns the maximum handshake age for node to be considered reachable: its own HandshakeTimeout,
+// or the State's if the node does not set one.
+func (s *State) handshakeTimeout(node common.Node) time.Duration {
+	if node.HandshakeTimeout != 0 {
+		return node.HandshakeTimeout
+	}
+	if s.HandshakeTimeout != 0 {
+		return s.HandshakeTimeout
+	}
+	return DefaultHandshakeTimeout
+}
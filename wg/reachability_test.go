@@ -0,0 +1,35 @@
+package wg
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_handshakeReachableNodes(t *testing.T) {
+	now := time.Now()
+	nodes := make([]common.Node, 4)
+	peers := make([]wgtypes.Peer, 0, 3)
+	for i, handshake := range []time.Time{now.Add(-time.Minute), now.Add(-time.Hour), {}} {
+		key := wgtypes.Key{byte(i + 1)}
+		nodes[i].PubKey = key.String()
+		nodes[i].OverlayAddr = netip.AddrFrom4([4]byte{10, 0, 0, byte(i + 1)})
+		peers = append(peers, wgtypes.Peer{PublicKey: key, LastHandshakeTime: handshake})
+	}
+	// a node not configured on the device
+	nodes[3].PubKey = wgtypes.Key{4}.String()
+	nodes[3].OverlayAddr = netip.MustParseAddr("10.0.0.4")
+
+	s := &State{}
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.1")}, s.handshakeReachableNodes(peers, nodes, now))
+
+	s.HandshakeTimeout = 30 * time.Second
+	assert.Empty(t, s.handshakeReachableNodes(peers, nodes, now), "global timeout must apply")
+
+	nodes[1].HandshakeTimeout = 2 * time.Hour
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.2")}, s.handshakeReachableNodes(peers, nodes, now), "node timeout must take precedence")
+}
@@ -0,0 +1,25 @@
+package wg
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Reload applies a new MTU to the running interface in place, keeping the interface and all peer sessions. The listen
+// port is not reloadable, since peers keep sending to the old one until the whole cluster changed it.
+func (s *State) Reload(mtu int) error {
+	if mtu == s.MTU {
+		return nil
+	}
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return fmt.Errorf("getting link information for %s: %w", s.iface, err)
+	}
+	if err := s.retryNetlink(func() error { return netlink.LinkSetMTU(link, mtu) }); err != nil {
+		return fmt.Errorf("setting MTU for %s: %w", s.iface, err)
+	}
+	Logger.Infof("changed MTU of %s from %d to %d", s.iface, s.MTU, mtu)
+	s.MTU = mtu
+	return nil
+}
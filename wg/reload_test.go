@@ -0,0 +1,34 @@
+package wg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// configRecorder records the configurations applied to a device.
+type configRecorder struct {
+	configs []wgtypes.Config
+}
+
+func (c *configRecorder) Device(name string) (*wgtypes.Device, error) {
+	return &wgtypes.Device{Name: name}, nil
+}
+
+func (c *configRecorder) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.configs = append(c.configs, cfg)
+	return nil
+}
+
+func Test_State_Reload(t *testing.T) {
+	client := &configRecorder{}
+	s := &State{iface: "wgtest", MTU: 1420, Port: 51820}
+	s.SetClient(client)
+
+	require.NoError(t, s.Reload(1420), "unchanged MTU must not touch the interface")
+	assert.Empty(t, client.configs, "the device must not be reconfigured")
+	assert.Error(t, s.Reload(1380), "missing interface must be reported")
+	assert.Equal(t, 1420, s.MTU)
+}
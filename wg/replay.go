@@ -0,0 +1,209 @@
+package wg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	recordDevice    = "device"
+	recordConfigure = "configure"
+)
+
+// clientRecord is a single recorded Client call, stored as one JSON line.
+type clientRecord struct {
+	Op     string          `json:"op"`
+	Name   string          `json:"name"`
+	Device *wgtypes.Device `json:"device,omitempty"`
+	Config *wgtypes.Config `json:"config,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	// NotExist is set if the error signaled a missing device, which callers check for.
+	NotExist bool `json:"not_exist,omitempty"`
+}
+
+func (r *clientRecord) err() error {
+	switch {
+	case r.NotExist:
+		return fmt.Errorf("%s: %w", r.Error, os.ErrNotExist)
+	case r.Error != "":
+		return errors.New(r.Error)
+	default:
+		return nil
+	}
+}
+
+// RecordingClient wraps a Client, e.g. a real wgctrl.Client, writing every call and its result to a session file
+// which can later be replayed by a ReplayClient. Private and preshared keys are zeroed in the recording.
+type RecordingClient struct {
+	client Client
+
+	mu  sync.Mutex
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// NewRecordingClient returns a RecordingClient forwarding calls to client and recording them to w.
+func NewRecordingClient(client Client, w io.Writer) *RecordingClient {
+	return &RecordingClient{client: client, w: w, enc: json.NewEncoder(w)}
+}
+
+// Close closes the recording and the wrapped client, if they can be closed.
+func (c *RecordingClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for _, v := range []interface{}{c.w, c.client} {
+		if closer, ok := v.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// Device implements Client.
+func (c *RecordingClient) Device(name string) (*wgtypes.Device, error) {
+	device, err := c.client.Device(name)
+	c.record(clientRecord{Op: recordDevice, Name: name, Device: redactDevice(device)}, err)
+	return device, err
+}
+
+// ConfigureDevice implements Client.
+func (c *RecordingClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	err := c.client.ConfigureDevice(name, cfg)
+	c.record(clientRecord{Op: recordConfigure, Name: name, Config: redactConfig(cfg)}, err)
+	return err
+}
+
+func (c *RecordingClient) record(r clientRecord, err error) {
+	if err != nil {
+		r.Error = err.Error()
+		r.NotExist = errors.Is(err, os.ErrNotExist)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(r); err != nil {
+		Logger.WithError(err).Error("could not record wireguard client call")
+	}
+}
+
+// redactDevice returns a copy of device with its private key and peers' preshared keys zeroed.
+func redactDevice(device *wgtypes.Device) *wgtypes.Device {
+	if device == nil {
+		return nil
+	}
+	redacted := *device
+	redacted.PrivateKey = wgtypes.Key{}
+	redacted.Peers = make([]wgtypes.Peer, len(device.Peers))
+	for i, peer := range device.Peers {
+		peer.PresharedKey = wgtypes.Key{}
+		redacted.Peers[i] = peer
+	}
+	return &redacted
+}
+
+// redactConfig returns a copy of cfg with its private key and peers' preshared keys zeroed. Set keys stay set, so
+// replays still see which keys were configured.
+func redactConfig(cfg wgtypes.Config) *wgtypes.Config {
+	if cfg.PrivateKey != nil {
+		cfg.PrivateKey = &wgtypes.Key{}
+	}
+	peers := make([]wgtypes.PeerConfig, len(cfg.Peers))
+	for i, peer := range cfg.Peers {
+		if peer.PresharedKey != nil {
+			peer.PresharedKey = &wgtypes.Key{}
+		}
+		peers[i] = peer
+	}
+	cfg.Peers = peers
+	return &cfg
+}
+
+// ReplayClient replays a session recorded by a RecordingClient, without accessing any device.
+// Calls must happen in the recorded order; configurations passed to ConfigureDevice must match the recorded ones, save
+// for the keys zeroed while recording.
+type ReplayClient struct {
+	mu      sync.Mutex
+	records []clientRecord
+}
+
+// NewReplayClient reads a session recorded by a RecordingClient from r.
+func NewReplayClient(r io.Reader) (*ReplayClient, error) {
+	c := &ReplayClient{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var record clientRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("decoding recorded call %d: %w", len(c.records)+1, err)
+		}
+		c.records = append(c.records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading recorded session: %w", err)
+	}
+	return c, nil
+}
+
+// Remaining returns the number of recorded calls not replayed yet.
+func (c *ReplayClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.records)
+}
+
+// Device implements Client.
+func (c *ReplayClient) Device(name string) (*wgtypes.Device, error) {
+	r, err := c.next(recordDevice, name)
+	if err != nil {
+		return nil, err
+	}
+	return r.Device, r.err()
+}
+
+// ConfigureDevice implements Client.
+func (c *ReplayClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	r, err := c.next(recordConfigure, name)
+	if err != nil {
+		return err
+	}
+	// compare through JSON, like the recorded configuration went
+	encoded, err := json.Marshal(redactConfig(cfg))
+	if err != nil {
+		return err
+	}
+	var replayed wgtypes.Config
+	if err := json.Unmarshal(encoded, &replayed); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(&replayed, r.Config) {
+		return fmt.Errorf("configuration for %s differs from recorded one", name)
+	}
+	return r.err()
+}
+
+func (c *ReplayClient) next(op, name string) (*clientRecord, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.records) == 0 {
+		return nil, fmt.Errorf("unexpected %s call for %s: recorded session exhausted", op, name)
+	}
+	r := c.records[0]
+	if r.Op != op || r.Name != name {
+		return nil, fmt.Errorf("unexpected %s call for %s: recorded %s call for %s", op, name, r.Op, r.Name)
+	}
+	c.records = c.records[1:]
+	return &r, nil
+}
@@ -0,0 +1,123 @@
+package wg
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeClient stands in for the kernel client while recording.
+type fakeClient struct {
+	device *wgtypes.Device
+}
+
+func (c *fakeClient) Device(name string) (*wgtypes.Device, error) {
+	if c.device == nil {
+		return nil, os.ErrNotExist
+	}
+	return c.device, nil
+}
+
+func (c *fakeClient) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	c.device = &wgtypes.Device{Name: name, ListenPort: *cfg.ListenPort}
+	for _, peer := range cfg.Peers {
+		c.device.Peers = append(c.device.Peers, wgtypes.Peer{PublicKey: peer.PublicKey, Endpoint: peer.Endpoint, AllowedIPs: peer.AllowedIPs})
+	}
+	return nil
+}
+
+func Test_ReplayClient(t *testing.T) {
+	port := 51820
+	cfg := wgtypes.Config{
+		ListenPort:   &port,
+		ReplacePeers: true,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:  wgtypes.Key{1},
+			Endpoint:   &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+			AllowedIPs: []net.IPNet{{IP: net.IPv4(10, 0, 0, 1).To4(), Mask: net.CIDRMask(32, 32)}},
+		}},
+	}
+	session := func(s *State) error {
+		if _, err := s.GetConfig(); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected missing device, got %v", err)
+		}
+		if err := s.configureDevice(cfg); err != nil {
+			return err
+		}
+		device, err := s.GetConfig()
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, port, device.ListenPort)
+		assert.Len(t, device.Peers, 1)
+		return nil
+	}
+
+	recorded := &bytes.Buffer{}
+	recording := &State{iface: "wgtest", DeviceCacheTTL: time.Minute}
+	recording.SetClient(NewRecordingClient(&fakeClient{}, recorded))
+	require.NoError(t, session(recording))
+
+	replay, err := NewReplayClient(bytes.NewReader(recorded.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 3, replay.Remaining())
+	replaying := &State{iface: "wgtest", DeviceCacheTTL: time.Minute}
+	replaying.SetClient(replay)
+	require.NoError(t, session(replaying))
+	assert.Zero(t, replay.Remaining())
+}
+
+func Test_ReplayClient_mismatch(t *testing.T) {
+	port := 51820
+	recorded := &bytes.Buffer{}
+	client := NewRecordingClient(&fakeClient{}, recorded)
+	require.NoError(t, client.ConfigureDevice("wgtest", wgtypes.Config{ListenPort: &port}))
+
+	replay, err := NewReplayClient(recorded)
+	require.NoError(t, err)
+	_, err = replay.Device("wgtest")
+	assert.Error(t, err, "calls must happen in recorded order")
+
+	otherPort := 1234
+	assert.Error(t, replay.ConfigureDevice("wgtest", wgtypes.Config{ListenPort: &otherPort}))
+}
+
+func Test_RecordingClient_redactsKeys(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	psk, err := wgtypes.GenerateKey()
+	require.NoError(t, err)
+	port := 51820
+	cfg := wgtypes.Config{
+		PrivateKey: &privKey,
+		ListenPort: &port,
+		Peers:      []wgtypes.PeerConfig{{PublicKey: wgtypes.Key{1}, PresharedKey: &psk}},
+	}
+
+	recorded := &bytes.Buffer{}
+	client := NewRecordingClient(&fakeClient{device: &wgtypes.Device{Name: "wgtest", PrivateKey: privKey, Peers: []wgtypes.Peer{{PresharedKey: psk}}}}, recorded)
+	device, err := client.Device("wgtest")
+	require.NoError(t, err)
+	assert.Equal(t, privKey, device.PrivateKey, "callers must get the unredacted device")
+	require.NoError(t, client.ConfigureDevice("wgtest", cfg))
+	assert.Equal(t, psk, *cfg.Peers[0].PresharedKey, "callers' configurations must not be modified")
+
+	replay, err := NewReplayClient(bytes.NewReader(recorded.Bytes()))
+	require.NoError(t, err)
+	require.Len(t, replay.records, 2)
+	assert.Zero(t, replay.records[0].Device.PrivateKey)
+	assert.Zero(t, replay.records[0].Device.Peers[0].PresharedKey)
+	assert.Zero(t, *replay.records[1].Config.PrivateKey)
+	assert.Zero(t, *replay.records[1].Config.Peers[0].PresharedKey)
+
+	_, err = replay.Device("wgtest")
+	require.NoError(t, err)
+	assert.NoError(t, replay.ConfigureDevice("wgtest", cfg), "keys must not make replayed configurations differ")
+}
@@ -0,0 +1,97 @@
+package wg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// DNSResolver returns a resolver sending all queries to the DNS server at addr (ip:port), bypassing the system
+// configuration in /etc/resolv.conf.
+func DNSResolver(addr string) *net.Resolver {
+	var d net.Dialer
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// SetDNSResolver makes all endpoint hostname lookups of the package use the DNS server at addr (ip:port) instead of
+// the system resolver.
+func SetDNSResolver(addr string) {
+	lookupIPAddr = DNSResolver(addr).LookupIPAddr
+}
+
+// fqdnResolveTimeout is the maximum time spent resolving a node's FQDN.
+const fqdnResolveTimeout = 2 * time.Second
+
+// lookupIPAddr resolves hostnames; it can be replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// Resolved FQDNs are used for fqdnCacheTTL before being resolved again; failed lookups are retried after
+// fqdnRetryInterval.
+const (
+	fqdnCacheTTL      = time.Minute
+	fqdnRetryInterval = 10 * time.Second
+)
+
+type fqdnEntry struct {
+	addrs      []net.IPAddr
+	expires    time.Time
+	refreshing bool
+}
+
+// fqdnCache caches resolved hostnames, so that configuring peers does not wait for DNS on every cluster change.
+// The zero value is ready to use.
+type fqdnCache struct {
+	mu      sync.Mutex
+	entries map[string]*fqdnEntry
+}
+
+// lookup returns the addresses of host. Only the first lookup of a host waits for its resolution; expired entries are
+// still returned while they are refreshed in the background.
+func (c *fqdnCache) lookup(host string) []net.IPAddr {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok {
+		if !entry.refreshing && time.Now().After(entry.expires) {
+			entry.refreshing = true
+			go c.resolve(host)
+		}
+		addrs := entry.addrs
+		c.mu.Unlock()
+		return addrs
+	}
+	c.mu.Unlock()
+	return c.resolve(host)
+}
+
+// resolve resolves host and caches the result. On failure, previously resolved addresses are kept until the retry.
+func (c *fqdnCache) resolve(host string) []net.IPAddr {
+	ctx, cancel := context.WithTimeout(context.Background(), fqdnResolveTimeout)
+	defer cancel()
+	addrs, err := lookupIPAddr(ctx, host)
+	ttl := fqdnCacheTTL
+	if err == nil && len(addrs) == 0 {
+		err = errors.New("no addresses found")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*fqdnEntry)
+	}
+	if err != nil {
+		ttl = fqdnRetryInterval
+		if previous, ok := c.entries[host]; ok {
+			addrs = previous.addrs
+		}
+		Logger.Warnf("could not resolve %s, retrying in %s: %v", host, ttl, err)
+	}
+	c.entries[host] = &fqdnEntry{addrs: addrs, expires: time.Now().Add(ttl)}
+	return addrs
+}
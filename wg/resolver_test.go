@@ -0,0 +1,22 @@
+package wg
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DNSResolver(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	r := DNSResolver(server.LocalAddr().String())
+	conn, err := r.Dial(context.Background(), "udp", "192.0.2.53:53")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, server.LocalAddr().String(), conn.RemoteAddr().String(), "queries must go to the configured server")
+}
@@ -0,0 +1,84 @@
+package wg
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []net.IPNet {
+	t.Helper()
+	nets := make([]net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		nets[i] = *ipnet
+	}
+	return nets
+}
+
+func Test_getNamespaceRoutes(t *testing.T) {
+	_, overlay, _ := net.ParseCIDR("10.0.0.1/32")
+
+	for _, netList := range [][]string{privateNetList, fullTunnelNetList} {
+		routes, err := getNamespaceRoutes(*overlay, netList)
+		require.NoError(t, err)
+		assert.Len(t, routes, len(netList)+1)
+		assert.Equal(t, *overlay, routes[0])
+	}
+}
+
+func Test_getNamespaceRoutes_private(t *testing.T) {
+	_, overlay, _ := net.ParseCIDR("10.0.0.1/32")
+
+	routes, err := getNamespaceRoutes(*overlay, privateNetList)
+	require.NoError(t, err)
+	assert.Equal(t, mustParseCIDRs(t, "10.0.0.1/32", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"), routes)
+}
+
+func Test_getNamespaceRoutes_overlay(t *testing.T) {
+	tests := map[string]struct {
+		overlay string
+		netList []string
+		want    []string
+	}{
+		"ipv4 custom": {"10.0.0.1/32", []string{"198.51.100.0/24"}, []string{"10.0.0.1/32", "198.51.100.0/24"}},
+		"ipv4 empty":  {"10.0.0.1/32", []string{}, []string{"10.0.0.1/32"}},
+		"ipv4 nil":    {"10.0.0.1/32", nil, []string{"10.0.0.1/32"}},
+		"ipv6 custom": {"2001:db8::1/128", []string{"2001:db8:1::/48", "10.0.0.0/8"}, []string{"2001:db8::1/128", "2001:db8:1::/48", "10.0.0.0/8"}},
+		"ipv6 empty":  {"2001:db8::1/128", []string{}, []string{"2001:db8::1/128"}},
+		"ipv6 tunnel": {"2001:db8::1/128", fullTunnelNetList, []string{"2001:db8::1/128", "0.0.0.0/0", "::/0"}},
+		"host bits":   {"10.0.0.1/32", []string{"192.168.1.1/16"}, []string{"10.0.0.1/32", "192.168.0.0/16"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, overlay, err := net.ParseCIDR(tt.overlay)
+			require.NoError(t, err)
+
+			routes, err := getNamespaceRoutes(*overlay, tt.netList)
+			require.NoError(t, err)
+			assert.Equal(t, mustParseCIDRs(t, tt.want...), routes)
+			assert.Equal(t, *overlay, routes[0], "overlay route must come first")
+		})
+	}
+}
+
+func Test_getNamespaceRoutes_malformed(t *testing.T) {
+	for _, overlayCIDR := range []string{"10.0.0.1/32", "2001:db8::1/128"} {
+		_, overlay, _ := net.ParseCIDR(overlayCIDR)
+
+		for _, netList := range [][]string{
+			{"10.0.0.0/8", "172.16.0.0/33", "192.168.0.0/16"},
+			{"2001:db8::/129"},
+			{"10.0.0.0"},
+			{""},
+			{"foo/8"},
+		} {
+			routes, err := getNamespaceRoutes(*overlay, netList)
+			assert.Error(t, err, "%v", netList)
+			assert.Nil(t, routes)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package wg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+// Self routes, deciding how traffic to the local overlay address is delivered.
+const (
+	// SelfRouteInterface relies on the local route the kernel creates for the address of the wireguard interface.
+	SelfRouteInterface = "interface"
+	// SelfRouteLoopback additionally installs a local route via the loopback interface, so local traffic to the overlay
+	// address keeps being delivered while the wireguard interface is down or recreated.
+	SelfRouteLoopback = "loopback"
+	// SelfRouteNone removes the kernel-created local route, e.g. when local delivery is handled by other means.
+	SelfRouteNone = "none"
+)
+
+// localRoute returns the local route to the overlay address via the link with index linkIndex.
+func (s *State) localRoute(linkIndex int) *netlink.Route {
+	return &netlink.Route{
+		LinkIndex: linkIndex,
+		Dst:       addrToIPNet(s.OverlayAddr),
+		Src:       s.OverlayAddr.AsSlice(),
+		Scope:     netlink.SCOPE_HOST,
+		Type:      syscall.RTN_LOCAL,
+		Table:     syscall.RT_TABLE_LOCAL,
+	}
+}
+
+// setUpSelfRoute installs or removes the local route to the overlay address according to SelfRoute. It must be called
+// once link is up, since the kernel only creates its local route then.
+func (s *State) setUpSelfRoute(link netlink.Link) error {
+	switch s.SelfRoute {
+	case SelfRouteLoopback:
+		lo, err := netlink.LinkByName("lo")
+		if err != nil {
+			return fmt.Errorf("getting loopback link: %w", err)
+		}
+		if s.loopbackRoute != nil && !s.loopbackRoute.Dst.IP.Equal(s.OverlayAddr.AsSlice()) {
+			// the overlay address changed since the last setup
+			s.removeLoopbackRoute()
+		}
+		route := s.localRoute(lo.Attrs().Index)
+		if err := netlink.RouteReplace(route); err != nil {
+			return fmt.Errorf("adding loopback route for %s: %w", s.OverlayAddr, err)
+		}
+		s.loopbackRoute = route
+	case SelfRouteNone:
+		if err := netlink.RouteDel(s.localRoute(link.Attrs().Index)); err != nil && !errors.Is(err, syscall.ESRCH) && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing local route for %s: %w", s.OverlayAddr, err)
+		}
+	}
+	return nil
+}
+
+// removeLoopbackRoute removes the loopback route installed by setUpSelfRoute, if any.
+func (s *State) removeLoopbackRoute() {
+	if s.loopbackRoute == nil {
+		return
+	}
+	netlink.RouteDel(s.loopbackRoute) // nolint: errcheck // opportunistic
+	s.loopbackRoute = nil
+}
@@ -0,0 +1,21 @@
+package wg
+
+import (
+	"net/netip"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func Test_State_localRoute(t *testing.T) {
+	s := &State{OverlayAddr: netip.MustParseAddr("10.0.0.1")}
+	route := s.localRoute(42)
+
+	assert.Equal(t, 42, route.LinkIndex)
+	assert.Equal(t, "10.0.0.1/32", route.Dst.String())
+	assert.Equal(t, syscall.RTN_LOCAL, route.Type)
+	assert.Equal(t, syscall.RT_TABLE_LOCAL, route.Table)
+	assert.Equal(t, netlink.SCOPE_HOST, route.Scope)
+}
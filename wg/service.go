@@ -0,0 +1,51 @@
+package wg
+
+import (
+	"net/netip"
+
+	"github.com/costela/wesher/common"
+)
+
+// serviceAddrs returns the additional addresses served by node which are inside the overlay network or one of the
+// ServiceRanges. Other addresses are ignored, so nodes cannot claim traffic to arbitrary destinations. Overlay
+// addresses of other nodes, as returned by overlayOwners, are ignored as well, so nodes cannot hijack traffic to their
+// peers.
+func (s *State) serviceAddrs(node common.Node, owners map[netip.Addr]string) []netip.Addr {
+	var addrs []netip.Addr
+	for _, addr := range node.ServiceAddrs {
+		if !s.serviceAddrAllowed(addr) {
+			Logger.Debugf("ignoring service address %s of node %s: outside of overlay network and service ranges", addr, node.Name)
+			continue
+		}
+		if owner, ok := owners[addr]; ok && owner != node.Name {
+			Logger.Debugf("ignoring service address %s of node %s: overlay address of node %s", addr, node.Name, owner)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// overlayOwners maps the overlay addresses of nodes and the local node to their names.
+func (s *State) overlayOwners(nodes []common.Node) map[netip.Addr]string {
+	owners := make(map[netip.Addr]string, len(nodes)+1)
+	if s.OverlayAddr.IsValid() {
+		owners[s.OverlayAddr] = s.name
+	}
+	for _, node := range nodes {
+		owners[node.OverlayAddr] = node.Name
+	}
+	return owners
+}
+
+func (s *State) serviceAddrAllowed(addr netip.Addr) bool {
+	if s.prefix.Contains(addr) {
+		return true
+	}
+	for _, r := range s.ServiceRanges {
+		if r.Contains(addr) && !r.Overlaps(s.prefix) {
+			return true
+		}
+	}
+	return false
+}
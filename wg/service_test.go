@@ -0,0 +1,81 @@
+package wg
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/costela/wesher/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_serviceAddrs(t *testing.T) {
+	s := &State{
+		prefix:        netip.MustParsePrefix("10.0.0.0/8"),
+		ServiceRanges: []netip.Prefix{netip.MustParsePrefix("192.168.100.0/24")},
+	}
+	node := common.Node{Name: "node"}
+	node.ServiceAddrs = []netip.Addr{
+		netip.MustParseAddr("10.1.2.3"),
+		netip.MustParseAddr("192.168.100.1"),
+		netip.MustParseAddr("8.8.8.8"),
+	}
+
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("192.168.100.1")}, s.serviceAddrs(node, nil))
+}
+
+func Test_State_nodesToPeerConfigs_serviceAddrs(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	node := common.Node{Name: "node"}
+	node.OverlayAddr = netip.MustParseAddr("10.0.0.2")
+	node.PubKey = privKey.PublicKey().String()
+	node.ServiceAddrs = []netip.Addr{netip.MustParseAddr("10.0.0.3")}
+
+	s := &State{prefix: netip.MustParsePrefix("10.0.0.0/8"), AllowedIPsPolicy: AllowedIPsOverlayOnly}
+	cfgs, err := s.nodesToPeerConfigs([]common.Node{node})
+	require.NoError(t, err)
+	require.Len(t, cfgs, 1)
+	assert.Equal(t, []net.IPNet{
+		{IP: net.IPv4(10, 0, 0, 2).To4(), Mask: net.CIDRMask(32, 32)},
+		{IP: net.IPv4(10, 0, 0, 3).To4(), Mask: net.CIDRMask(32, 32)},
+	}, cfgs[0].AllowedIPs)
+
+	s.AllowedIPsPolicy = AllowedIPsPrivateRanges
+	cfgs, err = s.nodesToPeerConfigs([]common.Node{node})
+	require.NoError(t, err)
+	assert.Contains(t, cfgs[0].AllowedIPs, net.IPNet{IP: net.IPv4(10, 0, 0, 3).To4(), Mask: net.CIDRMask(32, 32)})
+}
+
+func Test_State_serviceAddrs_overlappingRange(t *testing.T) {
+	s := &State{
+		prefix:        netip.MustParsePrefix("10.0.0.0/8"),
+		ServiceRanges: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/7"), netip.MustParsePrefix("192.168.100.0/24")},
+	}
+	node := common.Node{Name: "node"}
+	node.ServiceAddrs = []netip.Addr{
+		netip.MustParseAddr("10.1.2.3"),
+		netip.MustParseAddr("11.1.2.3"),
+		netip.MustParseAddr("192.168.100.1"),
+	}
+
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("192.168.100.1")}, s.serviceAddrs(node, nil), "ranges overlapping the overlay network must be ignored")
+}
+
+func Test_State_serviceAddrs_overlayAddrs(t *testing.T) {
+	s := &State{prefix: netip.MustParsePrefix("10.0.0.0/8"), name: "local", OverlayAddr: netip.MustParseAddr("10.0.0.1")}
+	other := common.Node{Name: "other"}
+	other.OverlayAddr = netip.MustParseAddr("10.0.0.2")
+	node := common.Node{Name: "node"}
+	node.OverlayAddr = netip.MustParseAddr("10.0.0.3")
+	node.ServiceAddrs = []netip.Addr{
+		netip.MustParseAddr("10.0.0.1"),
+		netip.MustParseAddr("10.0.0.2"),
+		netip.MustParseAddr("10.0.0.4"),
+	}
+
+	owners := s.overlayOwners([]common.Node{other, node})
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.0.0.4")}, s.serviceAddrs(node, owners), "overlay addresses of other nodes must be ignored")
+}
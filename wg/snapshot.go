@@ -0,0 +1,75 @@
+package wg
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// StateSnapshot holds the non-sensitive parts of a State, suitable for handing over to a standby instance.
+// The private key is deliberately not part of the snapshot and must be provided to the standby by other means.
+type StateSnapshot struct {
+	Iface       string
+	Port        int
+	MTU         int
+	RouteTable  int
+	OverlayAddr netip.Addr
+	PubKey      string
+}
+
+// Snapshot returns the current non-sensitive state.
+func (s *State) Snapshot() (*StateSnapshot, error) {
+	return &StateSnapshot{
+		Iface:       s.iface,
+		Port:        s.Port,
+		MTU:         s.MTU,
+		RouteTable:  s.RouteTable,
+		OverlayAddr: s.OverlayAddr,
+		PubKey:      s.PubKey.String(),
+	}, nil
+}
+
+// RestoreSnapshot takes over the state described by snap and re-applies it to the existing wireguard interface,
+// without touching its peers.
+// The snapshot must have been taken from an instance using the same private key as this one.
+func (s *State) RestoreSnapshot(snap *StateSnapshot) error {
+	if snap.Iface != s.iface {
+		return fmt.Errorf("snapshot is for interface %s, not %s", snap.Iface, s.iface)
+	}
+	pubKey, err := wgtypes.ParseKey(snap.PubKey)
+	if err != nil {
+		return fmt.Errorf("parsing snapshot public key: %w", err)
+	}
+	if pubKey != s.PrivKey.PublicKey() {
+		return fmt.Errorf("snapshot public key %s does not match private key", pubKey)
+	}
+
+	s.Port = snap.Port
+	s.MTU = snap.MTU
+	s.RouteTable = snap.RouteTable
+	s.OverlayAddr = snap.OverlayAddr
+	s.PubKey = pubKey
+
+	if err := s.configureDevice(wgtypes.Config{
+		PrivateKey: &s.PrivKey,
+		ListenPort: &s.Port,
+	}); err != nil {
+		return fmt.Errorf("setting wireguard configuration for %s: %w", s.iface, err)
+	}
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return fmt.Errorf("getting link information for %s: %w", s.iface, err)
+	}
+	if err := s.retryNetlink(func() error {
+		return netlink.AddrReplace(link, &netlink.Addr{IPNet: addrToIPNet(s.OverlayAddr)})
+	}); err != nil {
+		return fmt.Errorf("setting address for %s: %w", s.iface, err)
+	}
+	if err := s.retryNetlink(func() error { return netlink.LinkSetMTU(link, s.MTU) }); err != nil {
+		return fmt.Errorf("setting MTU for %s: %w", s.iface, err)
+	}
+
+	return nil
+}
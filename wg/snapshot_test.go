@@ -0,0 +1,46 @@
+package wg
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func Test_State_Snapshot_json(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	s := &State{
+		iface:       "wgtest",
+		Port:        51820,
+		MTU:         1420,
+		OverlayAddr: netip.MustParseAddr("10.0.0.1"),
+		PrivKey:     privKey,
+		PubKey:      privKey.PublicKey(),
+	}
+
+	snap, err := s.Snapshot()
+	require.NoError(t, err)
+
+	encoded, err := json.Marshal(snap)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), privKey.String())
+
+	decoded := &StateSnapshot{}
+	require.NoError(t, json.Unmarshal(encoded, decoded))
+	assert.Equal(t, snap, decoded)
+}
+
+func Test_State_RestoreSnapshot_key_mismatch(t *testing.T) {
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	otherKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+
+	s := &State{iface: "wgtest", PrivKey: privKey, PubKey: privKey.PublicKey()}
+	err = s.RestoreSnapshot(&StateSnapshot{Iface: "wgtest", PubKey: otherKey.PublicKey().String()})
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package wg
+
+import (
+	"os"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+)
+
+// kernelReleaseFile holds the running kernel's release, as reported by uname -r.
+var kernelReleaseFile = "/proc/sys/kernel/osrelease"
+
+// Implementation describes the wireguard implementation backing iface: "kernel RELEASE" for the kernel module, which is
+// also used for interfaces not created yet, or "userspace" for interfaces backed by another device type, e.g. a tun
+// device managed by wireguard-go.
+func Implementation(iface string) string {
+	if link, err := netlink.LinkByName(iface); err == nil && link.Type() != (&wireguard{}).Type() {
+		return "userspace"
+	}
+	release, err := os.ReadFile(kernelReleaseFile)
+	if err != nil {
+		return "kernel"
+	}
+	// drop distribution suffixes like "-13-amd64"
+	r, _, _ := strings.Cut(strings.TrimSpace(string(release)), "-")
+	return "kernel " + r
+}
@@ -1,59 +1,181 @@
 package wg
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"net"
 	"net/netip"
 	"os"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/cenkalti/backoff/v4"
 	"github.com/costela/wesher/common"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 // State holds the configured state of a Wesher Wireguard interface.
 type State struct {
 	iface       string
-	client      *wgctrl.Client
 	OverlayAddr netip.Addr
 	Port        int
 	PrivKey     wgtypes.Key
 	PubKey      wgtypes.Key
 	MTU         int
+	// RouteTable is the routing table peer routes are installed in; 0 means the main table.
+	// When set, an ip rule steering traffic originating from OverlayAddr to this table is also installed, which
+	// allows participating in multiple meshes with overlapping overlay networks.
+	RouteTable int
+	// AllowedIPsPolicy selects the allowed IPs configured for each peer, in addition to its overlay address; one of
+	// AllowedIPsOverlayOnly, AllowedIPsPrivateRanges (the default) or AllowedIPsFullTunnel.
+	AllowedIPsPolicy string
+	// DeviceCacheTTL is the duration for which GetConfig results are cached.
+	DeviceCacheTTL time.Duration
+	// NetlinkRetries is the number of times transiently failing link setup calls are retried.
+	NetlinkRetries uint64
+	// NetlinkRetryInterval is the time waited between retries of link setup calls.
+	NetlinkRetryInterval time.Duration
+	// Group is the link group the interface is put in, allowing to manage all interfaces in it at once; 0 means no
+	// group.
+	Group uint32
+	// InterfaceUpTimeout is the time to wait for a newly created wireguard device to become available, e.g. while the
+	// kernel module is loading.
+	InterfaceUpTimeout time.Duration
+	// Alias is an optional human-readable alias set on the interface; it is purely cosmetic. AliasIdentity sets an
+	// alias derived from the node name instead.
+	Alias string
+	// PeerProbeTimeout enables probing peer endpoints before configuring them, waiting up to this long for an
+	// unreachable error. Peers which appear unreachable are left out; see DeferredPeers. 0 disables probing.
+	PeerProbeTimeout time.Duration
+	// EndpointStabilityWindow is the time a peer's changed endpoint must be gossiped before it is configured; until
+	// then the previous endpoint is kept, so brief flaps do not reset sessions. 0 applies changes immediately.
+	EndpointStabilityWindow time.Duration
+	// RouteOrder is the order in which the link is configured and peer routes are installed; one of
+	// RouteOrderLinkFirst (the default) or RouteOrderRoutesFirst.
+	RouteOrder string
+	// SelfRoute selects how traffic to the local overlay address is delivered; one of SelfRouteInterface (the default),
+	// SelfRouteLoopback or SelfRouteNone.
+	SelfRoute string
+	// ServiceRanges are networks outside of the overlay network peers may announce service addresses in; ranges
+	// overlapping the overlay network are ignored. Service addresses outside of both are ignored.
+	ServiceRanges []netip.Prefix
+	// PersistentKeepalive is the interval in which keepalive packets are sent to peers, to keep NAT and firewall state
+	// alive; PersistentKeepaliveIPv4 and PersistentKeepaliveIPv6 override it for endpoints of the respective family.
+	// Zero disables keepalives.
+	PersistentKeepalive     time.Duration
+	PersistentKeepaliveIPv4 time.Duration
+	PersistentKeepaliveIPv6 time.Duration
+	// HandshakeTimeout is the maximum age of the latest handshake with peers not setting their own timeout for them to
+	// be considered reachable; if zero, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+	// PSKGroups maps the names of the preshared key groups this node is a member of to their keys. Peers sharing a
+	// group use its key as additional symmetric encryption layer.
+	PSKGroups map[string]wgtypes.Key
+	// TakeOver allows SetUpInterface to replace peers it did not configure, instead of failing with ErrForeignPeers.
+	TakeOver bool
+	// BindAddr is the local underlay address wireguard traffic is sent from, if known. Resolved peer endpoints of its
+	// address family are preferred.
+	BindAddr netip.Addr
+	// ConflictResolver is called by ResolveConflicts when another node claims the local overlay address; if nil, the
+	// default resolver is used.
+	ConflictResolver ConflictResolver
+	// OnConfigure is an optional callback invoked after each successful configuration of the wireguard device.
+	OnConfigure func(ConfigEvent)
+	// OnPeerAdd is an optional callback invoked once for each peer configured for the first time, after the device
+	// was configured; peers kept across calls to SetUpInterface are not reported again.
+	OnPeerAdd func(node common.Node, endpoint *net.UDPAddr)
+
+	addrOpts AddrOptions
+	// prefix, name and wgAddress are the inputs of the overlay address assignment, kept for reassignments
+	prefix    netip.Prefix
+	name      string
+	wgAddress string
+	// keyFile is where the private key is persisted, if anywhere
+	keyFile string
+	// fqdns caches the resolved FQDNs of peers
+	fqdns fqdnCache
+	// conflicts holds the overlay addresses given up to other nodes by RehashConflictResolver, see conflictsFile
+	conflicts []netip.Addr
+	// peerAddrs holds the overlay addresses of the peers routes were added for during the last SetUpInterface
+	peerAddrs []netip.Addr
+	// adopted is set if the State took over an existing device, whose peers are then reconciled instead of replaced
+	adopted bool
+	// configuredPeers holds the peers configured during the last SetUpInterface
+	configuredPeers map[wgtypes.Key]bool
+	// peersConfiguredAt holds the time each currently configured peer was first configured
+	peersConfiguredAt map[wgtypes.Key]time.Time
+	// loopbackRoute is the local route installed via the loopback interface for SelfRouteLoopback
+	loopbackRoute *netlink.Route
+	// foreign is set if the interface was found to be managed by someone else, so it must not be removed
+	foreign bool
+	// deferredPeers is the number of peers left out by probing during the last SetUpInterface
+	deferredPeers int
+	// endpoints tracks the configured and changed endpoints of peers for debouncing
+	endpoints          map[wgtypes.Key]*endpointState
+	nextEndpointUpdate time.Time
+
+	// client is the wireguard client, initialized to the kernel client on first use by lazyClient unless set with
+	// SetClient
+	clientMu sync.Mutex
+	client   Client
+
+	deviceMu      sync.Mutex
+	device        *wgtypes.Device
+	deviceFetched time.Time
 }
 
+// Logger is used for all log output of the package.
+var Logger = logrus.StandardLogger()
+
+// ErrKernelModuleMissing is returned by SetUpInterface if the kernel does not support wireguard links.
+var ErrKernelModuleMissing = errors.New("kernel does not support wireguard interfaces; load the module with \"modprobe wireguard\", or create the interface with a userspace implementation (e.g. wireguard-go) before starting wesher")
+
 // New creates a new Wesher Wireguard state.
-// The Wireguard keys are generated for every new interface.
+// The private key is loaded from keyFile, which is created with a new key on first use, so that the public key stays
+// stable across restarts. Without keyFile, or with EphemeralKeyFile, the keys are generated for every new interface.
 // The interface must later be setup using SetUpInterface.
-func New(iface string, port int, mtu int, prefix netip.Prefix, name string, wgAddress string) (*State, *common.Node, error) {
-	client, err := wgctrl.New()
-	if err != nil {
-		return nil, nil, fmt.Errorf("instantiating wireguard client: %w", err)
-	}
-
-	privKey, err := wgtypes.GeneratePrivateKey()
+func New(iface string, port int, mtu int, prefix netip.Prefix, name string, wgAddress string, keyFile string, addrOpts AddrOptions) (*State, *common.Node, error) {
+	privKey, err := loadOrCreateKey(keyFile)
 	if err != nil {
-		return nil, nil, fmt.Errorf("generating private key: %w", err)
+		return nil, nil, err
 	}
 	pubKey := privKey.PublicKey()
 
 	state := State{
-		iface:   iface,
-		client:  client,
-		Port:    port,
-		PrivKey: privKey,
-		PubKey:  pubKey,
-		MTU:     mtu,
+		iface:          iface,
+		Port:           port,
+		PrivKey:        privKey,
+		PubKey:         pubKey,
+		MTU:            mtu,
+		DeviceCacheTTL: DefaultDeviceCacheTTL,
+		addrOpts:       addrOpts,
+		prefix:         prefix,
+		name:           name,
+		wgAddress:      wgAddress,
+		keyFile:        keyFile,
 	}
+	// addresses previously given up in conflicts are skipped, so the address moved to is kept
+	conflicts, err := loadConflicts(conflictsFile(keyFile))
+	if err != nil {
+		Logger.WithError(err).Warn("could not load conflicting overlay addresses")
+	}
+	reserved := addrOpts.Reserved[:len(addrOpts.Reserved):len(addrOpts.Reserved)]
+	for _, addr := range conflicts {
+		reserved = append(reserved, AddrRange{From: addr, To: addr})
+	}
+	state.addrOpts.Reserved = reserved
+	state.conflicts = conflicts
 	if err := state.assignOverlayAddr(prefix, name, wgAddress); err != nil {
 		return nil, nil, fmt.Errorf("xassigning overlay address: %w", err)
 	}
 
 	node := &common.Node{}
+	node.Capabilities = common.SupportedCapabilities
 	node.OverlayAddr = state.OverlayAddr
 	node.PubKey = state.PubKey.String()
 
@@ -62,15 +184,17 @@ func New(iface string, port int, mtu int, prefix netip.Prefix, name string, wgAd
 
 // assignOverlayAddr assigns a new address to the interface.
 // The address is assigned inside the provided network and depends on the
-// provided name deterministically.
+// provided name (or the public key, depending on addrOpts) deterministically.
 // Currently, the address is assigned by hashing the name and mapping that
-// hash in the target network space.
+// hash in the target network space. If the resulting address is reserved,
+// the hash is rehashed until an unreserved address is found.
+// Infrastructure nodes get sequential addresses from the start of the network instead.
 func (s *State) assignOverlayAddr(prefix netip.Prefix, name string, wgAddress string) error {
 	var overlayAddr netip.Addr
 
-	logrus.Debugf("wireguard address: %s", wgAddress)
+	Logger.Debugf("wireguard address: %s", wgAddress)
 
-	if wgAddress != "" && wgAddress != "0.0.0.0" {
+	if !autoAddr(wgAddress) {
 		addr, err := netip.ParseAddr(wgAddress)
 		if err != nil {
 			return fmt.Errorf("could not set wireguard IP %q", wgAddress)
@@ -78,29 +202,45 @@ func (s *State) assignOverlayAddr(prefix netip.Prefix, name string, wgAddress st
 			if prefix.Contains(addr) {
 				overlayAddr = addr
 			} else {
-				fmt.Errorf("wireguard IP %q not part of the overlay network %s", wgAddress, prefix.String())
+				return fmt.Errorf("wireguard IP %q not part of the overlay network %s", wgAddress, prefix.String())
 			}
 		}
+	} else if addr, ok, err := s.addrOpts.infrastructureAddr(prefix, name); ok {
+		if err != nil {
+			return err
+		}
+		overlayAddr = addr
 	} else {
-		ip := prefix.Addr().AsSlice()
-
 		h := fnv.New128a()
-		h.Write([]byte(name))
-		hb := h.Sum(nil)
-
-		for i := 1; i <= (prefix.Addr().BitLen()-prefix.Bits())/8; i++ {
-			ip[len(ip)-i] = hb[len(hb)-i]
+		if s.addrOpts.HashSeed != 0 {
+			seed := make([]byte, 8)
+			binary.BigEndian.PutUint64(seed, s.addrOpts.HashSeed)
+			h.Write(seed)
 		}
-
-		addr, ok := netip.AddrFromSlice(ip)
-		if !ok {
-			return fmt.Errorf("could not create IP from %s", ip)
+		if s.addrOpts.From == AddrFromPubKey {
+			h.Write(s.PubKey[:])
+		} else {
+			h.Write([]byte(name))
+		}
+		for i := 0; ; i++ {
+			hb := h.Sum(nil)
+			addr, err := s.addrOpts.formatAddr(prefix, name, hb)
+			if err != nil {
+				return err
+			}
+			if !s.addrOpts.reserved(addr) && !s.addrOpts.infrastructureReserved(prefix, addr) {
+				overlayAddr = addr
+				break
+			}
+			if i == maxRehashes {
+				return fmt.Errorf("could not find unreserved address in %s", prefix)
+			}
+			Logger.Debugf("address %s is reserved, rehashing", addr)
+			h.Write(hb)
 		}
-
-		overlayAddr = addr
 	}
 
-	logrus.Debugf("assigned overlay address: %s", overlayAddr)
+	Logger.Debugf("assigned overlay address: %s", overlayAddr)
 
 	s.OverlayAddr = overlayAddr
 
@@ -109,7 +249,14 @@ func (s *State) assignOverlayAddr(prefix netip.Prefix, name string, wgAddress st
 
 // DownInterface shuts down the associated network interface.
 func (s *State) DownInterface() error {
-	if _, err := s.client.Device(s.iface); err != nil {
+	if s.foreign {
+		return nil // not ours to remove
+	}
+	client, err := s.lazyClient()
+	if err != nil {
+		return err
+	}
+	if _, err := client.Device(s.iface); err != nil {
 		if os.IsNotExist(err) {
 			return nil // device already gone; noop
 		}
@@ -119,56 +266,254 @@ func (s *State) DownInterface() error {
 	if err != nil {
 		return fmt.Errorf("getting link for %s: %w", s.iface, err)
 	}
+	if s.RouteTable != 0 {
+		netlink.RuleDel(s.routeRule()) // nolint: errcheck // opportunistic
+	}
+	s.removeLoopbackRoute()
+	s.invalidateDevice()
 	return netlink.LinkDel(link)
 }
 
 // SetUpInterface creates and sets up the associated network interface.
 func (s *State) SetUpInterface(nodes []common.Node) error {
-	if err := netlink.LinkAdd(&wireguard{LinkAttrs: netlink.LinkAttrs{Name: s.iface}}); err != nil && !os.IsExist(err) {
+	created := true
+	if err := netlink.LinkAdd(&wireguard{LinkAttrs: netlink.LinkAttrs{Name: s.iface}}); os.IsExist(err) {
+		created = false
+	} else if err != nil {
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			return fmt.Errorf("creating link %s: %w", s.iface, ErrKernelModuleMissing)
+		}
 		return fmt.Errorf("creating link %s: %w", s.iface, err)
 	}
+	if err := s.waitForDevice(); err != nil {
+		return fmt.Errorf("waiting for device %s: %w", s.iface, err)
+	}
+	if created {
+		// mark the link as ours right away, so it is recognized as left behind if we crash before completing the setup
+		if link, err := netlink.LinkByName(s.iface); err == nil {
+			if alias := s.alias(); alias != "" {
+				netlink.LinkSetAlias(link, alias) // nolint: errcheck // set again below
+			}
+			if s.Group != 0 {
+				netlink.LinkSetGroup(link, int(s.Group)) // nolint: errcheck // set again below
+			}
+		}
+	}
 
+	if s.PeerProbeTimeout > 0 {
+		nodes = s.reachableNodes(nodes)
+	}
 	peerCfgs, err := s.nodesToPeerConfigs(nodes)
 	if err != nil {
 		return fmt.Errorf("converting received node information to wireguard format: %w", err)
 	}
-	if err := s.client.ConfigureDevice(s.iface, wgtypes.Config{
+	if err := s.checkForeignPeers(peerCfgs); err != nil {
+		return err
+	}
+	s.debounceEndpoints(peerCfgs, time.Now())
+	cfg := wgtypes.Config{
 		PrivateKey:   &s.PrivKey,
 		ListenPort:   &s.Port,
 		ReplacePeers: true,
 		Peers:        peerCfgs,
-	}); err != nil {
-		return fmt.Errorf("setting wireguard configuration for %s: %w", s.iface, err)
 	}
+	if s.adopted {
+		// keep the sessions of unchanged peers by only removing stale ones
+		stale, err := s.stalePeers(peerCfgs)
+		if err != nil {
+			return fmt.Errorf("getting stale peers: %w", err)
+		}
+		cfg.ReplacePeers = false
+		cfg.Peers = append(cfg.Peers, stale...)
+	}
+	if err := s.configureDevice(cfg); err != nil {
+		if len(cfg.Peers) == 0 {
+			return fmt.Errorf("setting wireguard configuration for %s: %w", s.iface, err)
+		}
+		Logger.WithError(err).Warnf("could not configure %s, retrying missing peers individually", s.iface)
+		rejected, err := s.recoverConfigure(cfg, err)
+		if err != nil {
+			return fmt.Errorf("setting wireguard configuration for %s: %w", s.iface, err)
+		}
+		peerCfgs = withoutRejected(peerCfgs, rejected)
+	}
+	s.configuredPeers = make(map[wgtypes.Key]bool, len(peerCfgs))
+	for _, cfg := range peerCfgs {
+		s.configuredPeers[cfg.PublicKey] = true
+	}
+	added := s.addedPeers(nodes, peerCfgs)
+	s.recordConfiguredPeers(peerCfgs, time.Now())
+	s.notifyAddedPeers(added)
 
 	link, err := netlink.LinkByName(s.iface)
 	if err != nil {
 		return fmt.Errorf("getting link information for %s: %w", s.iface, err)
 	}
-	if err := netlink.AddrReplace(link, &netlink.Addr{
-		IPNet: addrToIPNet(s.OverlayAddr),
+	// routes can only be added via links which are up, so they cannot come first for new links
+	routesAdded := false
+	if s.RouteOrder == RouteOrderRoutesFirst && link.Attrs().Flags&net.FlagUp != 0 {
+		if err := s.addPeerRoutes(link, nodes); err != nil {
+			return err
+		}
+		routesAdded = true
+	}
+	if err := s.retryNetlink(func() error {
+		return netlink.AddrReplace(link, &netlink.Addr{IPNet: addrToIPNet(s.OverlayAddr)})
 	}); err != nil {
 		return fmt.Errorf("setting address for %s: %w", s.iface, err)
 	}
-	if err := netlink.LinkSetMTU(link, s.MTU); err != nil {
+	if err := s.retryNetlink(func() error { return netlink.LinkSetMTU(link, s.MTU) }); err != nil {
 		return fmt.Errorf("setting MTU for %s: %w", s.iface, err)
 	}
-	if err := netlink.LinkSetUp(link); err != nil {
+	if alias := s.alias(); alias != "" && link.Attrs().Alias != alias {
+		if err := netlink.LinkSetAlias(link, alias); err != nil {
+			return fmt.Errorf("setting alias for %s: %w", s.iface, err)
+		}
+	}
+	if s.Group != 0 && link.Attrs().Group != s.Group {
+		if err := netlink.LinkSetGroup(link, int(s.Group)); err != nil {
+			return fmt.Errorf("setting group for %s: %w", s.iface, err)
+		}
+	}
+	if err := s.retryNetlink(func() error { return netlink.LinkSetUp(link) }); err != nil {
 		return fmt.Errorf("enabling interface %s: %w", s.iface, err)
 	}
+	if err := s.setUpSelfRoute(link); err != nil {
+		return err
+	}
+	if !routesAdded {
+		if err := s.addPeerRoutes(link, nodes); err != nil {
+			return err
+		}
+	}
+	if s.RouteTable != 0 {
+		if err := netlink.RuleAdd(s.routeRule()); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("adding rule for table %d: %w", s.RouteTable, err)
+		}
+	}
+
+	return nil
+}
+
+// addPeerRoutes adds routes to the overlay and service addresses of all nodes via link.
+func (s *State) addPeerRoutes(link netlink.Link, nodes []common.Node) error {
+	s.peerAddrs = s.peerAddrs[:0]
+	owners := s.overlayOwners(nodes)
 	for _, node := range nodes {
-		if err := netlink.RouteAdd(&netlink.Route{
-			LinkIndex: link.Attrs().Index,
-			Dst:       addrToIPNet(node.OverlayAddr),
-			Scope:     netlink.SCOPE_LINK,
-		}); err != nil && !errors.Is(err, os.ErrExist) {
-			return fmt.Errorf("adding route %s to %s: %w", node.OverlayAddr, s.iface, err)
+		for _, addr := range append([]netip.Addr{node.OverlayAddr}, s.serviceAddrs(node, owners)...) {
+			if err := s.addRoute(link, addr); err != nil {
+				return fmt.Errorf("adding route %s to %s: %w", addr, s.iface, err)
+			}
+			s.peerAddrs = append(s.peerAddrs, addr)
 		}
 	}
+	return nil
+}
+
+// retryNetlink calls op, retrying up to NetlinkRetries times if it fails with an error considered transient.
+func (s *State) retryNetlink(op func() error) error {
+	return backoff.RetryNotify(
+		func() error {
+			err := op()
+			if err != nil && !errors.Is(err, syscall.EBUSY) && !errors.Is(err, syscall.EAGAIN) && !errors.Is(err, syscall.ENODEV) {
+				return backoff.Permanent(err)
+			}
+			return err
+		},
+		backoff.WithMaxRetries(backoff.NewConstantBackOff(s.NetlinkRetryInterval), s.NetlinkRetries),
+		func(err error, dur time.Duration) {
+			Logger.WithError(err).Debugf("netlink call failed, retrying in %s", dur)
+		},
+	)
+}
 
+// addRoute adds a link-scoped route to the provided address via link.
+// Some kernels reject link-scoped routes in certain configurations; in that case the route is retried with universe
+// scope, which is equivalent for our point-to-point interface.
+func (s *State) addRoute(link netlink.Link, addr netip.Addr) error {
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst:       addrToIPNet(addr),
+		Scope:     netlink.SCOPE_LINK,
+		Table:     s.RouteTable,
+	}
+	err := netlink.RouteAdd(route)
+	if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENETUNREACH) {
+		Logger.WithError(err).Warnf("link scope route to %s rejected, falling back to universe scope", addr)
+		route.Scope = netlink.SCOPE_UNIVERSE
+		err = netlink.RouteAdd(route)
+	}
+	if err != nil && !errors.Is(err, os.ErrExist) {
+		return err
+	}
 	return nil
 }
 
+// RepairRoutes verifies the routes to all peers configured in the last SetUpInterface call are still in place, and
+// re-adds any missing ones.
+// It must not be called concurrently with SetUpInterface.
+func (s *State) RepairRoutes() error {
+	if len(s.peerAddrs) == 0 {
+		return nil
+	}
+	link, err := netlink.LinkByName(s.iface)
+	if err != nil {
+		return fmt.Errorf("getting link information for %s: %w", s.iface, err)
+	}
+	for _, addr := range s.peerAddrs {
+		ok, err := s.routeInstalled(link, addr)
+		if err != nil {
+			return fmt.Errorf("checking route to %s: %w", addr, err)
+		}
+		if ok {
+			continue
+		}
+		Logger.Warnf("route to %s via %s missing, re-adding it", addr, s.iface)
+		if err := s.addRoute(link, addr); err != nil {
+			return fmt.Errorf("adding route %s to %s: %w", addr, s.iface, err)
+		}
+	}
+	return nil
+}
+
+// routeInstalled checks whether traffic to addr is routed via link.
+func (s *State) routeInstalled(link netlink.Link, addr netip.Addr) (bool, error) {
+	var routes []netlink.Route
+	var err error
+	if s.RouteTable == 0 {
+		routes, err = netlink.RouteGet(addr.AsSlice())
+		if errors.Is(err, syscall.ENETUNREACH) {
+			return false, nil
+		}
+	} else {
+		family := netlink.FAMILY_V4
+		if addr.Is6() {
+			family = netlink.FAMILY_V6
+		}
+		routes, err = netlink.RouteListFiltered(family, &netlink.Route{
+			Table: s.RouteTable,
+			Dst:   addrToIPNet(addr),
+		}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST)
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, route := range routes {
+		if route.LinkIndex == link.Attrs().Index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// routeRule returns the ip rule steering traffic from the overlay address to the configured route table.
+func (s *State) routeRule() *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Src = addrToIPNet(s.OverlayAddr)
+	rule.Table = s.RouteTable
+	return rule
+}
+
 func addrToIPNet(addr netip.Addr) *net.IPNet {
 	return &net.IPNet{
 		IP:   addr.AsSlice(),
@@ -178,35 +523,132 @@ func addrToIPNet(addr netip.Addr) *net.IPNet {
 
 func (s *State) nodesToPeerConfigs(nodes []common.Node) ([]wgtypes.PeerConfig, error) {
 	peerCfgs := make([]wgtypes.PeerConfig, len(nodes))
+	claimed := make(map[netip.Prefix]string, len(nodes))
+	owners := s.overlayOwners(nodes)
 	for i, node := range nodes {
 		pubKey, err := wgtypes.ParseKey(node.PubKey)
 		if err != nil {
 			return nil, fmt.Errorf("parsing wireguard key: %w", err)
 		}
+		node.ServiceAddrs = s.serviceAddrs(node, owners)
+		var allowedIPs []net.IPNet
+		if node.Draining {
+			// only keep reaching the node itself until it is gone
+			allowedIPs = []net.IPNet{*addrToIPNet(node.OverlayAddr)}
+			for _, addr := range node.ServiceAddrs {
+				allowedIPs = append(allowedIPs, *addrToIPNet(addr))
+			}
+		} else {
+			switch s.AllowedIPsPolicy {
+			case AllowedIPsOverlayOnly:
+				allowedIPs = minimalAllowedIPs(node, claimed)
+			case AllowedIPsFullTunnel:
+				netList := fullTunnelNetList
+				if !node.HasCapability(common.CapIPv6Overlay) {
+					netList = fullTunnelNetListIPv4
+				}
+				allowedIPs, err = getNamespaceRoutes(*addrToIPNet(node.OverlayAddr), netList)
+			default:
+				allowedIPs, err = getNamespaceRoutes(*addrToIPNet(node.OverlayAddr), privateNetList)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("getting allowed IPs for %s: %w", node.Name, err)
+			}
+			if s.AllowedIPsPolicy != AllowedIPsOverlayOnly {
+				for _, addr := range node.ServiceAddrs {
+					allowedIPs = append(allowedIPs, *addrToIPNet(addr))
+				}
+			}
+		}
+		endpointIP := s.resolveEndpointIP(node)
 		peerCfgs[i] = wgtypes.PeerConfig{
 			PublicKey:         pubKey,
 			ReplaceAllowedIPs: true,
 			Endpoint: &net.UDPAddr{
-				IP:   node.Addr,
+				IP:   endpointIP,
 				Port: s.Port,
 			},
-			AllowedIPs: getPrivateNamespaceRoutes(*addrToIPNet(node.OverlayAddr)),
+			PersistentKeepaliveInterval: s.keepalive(endpointIP),
+			AllowedIPs:                  allowedIPs,
+		}
+		if psk, ok := s.presharedKey(node); ok {
+			peerCfgs[i].PresharedKey = &psk
 		}
 	}
 	return peerCfgs, nil
 }
 
-func getPrivateNamespaceRoutes(overlayAddr net.IPNet) []net.IPNet {
-	privateNetList := []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
-	routes := make([]net.IPNet, len(privateNetList)+1)
+// resolveEndpointIP returns the IP peers should use to reach node via wireguard, resolving its FQDN if advertised.
+// Addresses of the same family as BindAddr are preferred. If resolution fails, nodeEndpointIP is used instead.
+func (s *State) resolveEndpointIP(node common.Node) net.IP {
+	if node.FQDN != "" {
+		if addrs := s.fqdns.lookup(node.FQDN); len(addrs) > 0 {
+			return preferFamily(addrs, s.BindAddr)
+		}
+	}
+	return nodeEndpointIP(node)
+}
+
+// preferFamily returns the first of addrs in the address family of bind, or the first one if there is none or bind
+// is not set.
+func preferFamily(addrs []net.IPAddr, bind netip.Addr) net.IP {
+	if bind.IsValid() {
+		for _, addr := range addrs {
+			if (addr.IP.To4() != nil) == bind.Unmap().Is4() {
+				return addr.IP
+			}
+		}
+	}
+	return addrs[0].IP
+}
+
+// nodeEndpointIP returns the IP peers should use to reach node via wireguard, without resolving its FQDN.
+func nodeEndpointIP(node common.Node) net.IP {
+	if node.Endpoint.IsValid() {
+		return node.Endpoint.AsSlice()
+	}
+	return node.Addr
+}
+
+// Policies for the allowed IPs of each peer.
+const (
+	// AllowedIPsOverlayOnly allows only host prefixes of the peer's own addresses.
+	AllowedIPsOverlayOnly = "overlay-only"
+	// AllowedIPsPrivateRanges allows all private network ranges.
+	AllowedIPsPrivateRanges = "private-ranges"
+	// AllowedIPsFullTunnel allows any address, so all traffic may be routed through the overlay.
+	AllowedIPsFullTunnel = "full-tunnel"
+)
+
+// Orders in which SetUpInterface configures the link and installs peer routes.
+const (
+	// RouteOrderLinkFirst configures the link's address and MTU and brings it up before installing routes.
+	RouteOrderLinkFirst = "link-first"
+	// RouteOrderRoutesFirst installs routes right after configuring peers, before touching the link, if the link is
+	// already up; this minimizes the time routes lag behind peer changes on reconfiguration.
+	RouteOrderRoutesFirst = "routes-first"
+)
+
+// privateNetList holds the networks allowed through the tunnel by AllowedIPsPrivateRanges.
+var privateNetList = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"}
+
+// fullTunnelNetList holds the networks allowed through the tunnel by AllowedIPsFullTunnel.
+var fullTunnelNetList = []string{"0.0.0.0/0", "::/0"}
+
+// fullTunnelNetListIPv4 is used instead of fullTunnelNetList for peers not supporting common.CapIPv6Overlay.
+var fullTunnelNetListIPv4 = []string{"0.0.0.0/0"}
+
+// getNamespaceRoutes returns the overlay address along with netList.
+func getNamespaceRoutes(overlayAddr net.IPNet, netList []string) ([]net.IPNet, error) {
+	routes := make([]net.IPNet, len(netList)+1)
 	routes[0] = overlayAddr
-	for i := 0; i < len(privateNetList); i += 1 {
-		_, ipnet, err := net.ParseCIDR(privateNetList[i])
+	for i := 0; i < len(netList); i += 1 {
+		_, ipnet, err := net.ParseCIDR(netList[i])
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("parsing allowed network: %w", err)
 		}
 		routes[i+1] = *ipnet
 	}
 
-	return routes
+	return routes, nil
 }
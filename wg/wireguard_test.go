@@ -1,11 +1,19 @@
 package wg
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"net/netip"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/costela/wesher/common"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func Test_State_AssignOverlayAddr(t *testing.T) {
@@ -37,7 +45,7 @@ func Test_State_AssignOverlayAddr(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &State{}
-			err := s.assignOverlayAddr(tt.args.prefix, tt.args.hostname)
+			err := s.assignOverlayAddr(tt.args.prefix, tt.args.hostname, "")
 			require.NoError(t, err)
 
 			assert.Equal(t, tt.want, s.OverlayAddr.String())
@@ -52,7 +60,7 @@ func Test_State_AssignOverlayAddr_no_obvious_collisions(t *testing.T) {
 	assignments := make(map[string]string)
 	for _, n := range []string{"test", "test1", "test2", "1test", "2test"} {
 		s := &State{}
-		err := s.assignOverlayAddr(prefix, n)
+		err := s.assignOverlayAddr(prefix, n, "")
 		require.NoError(t, err)
 
 		assert.NotContainsf(t, assignments, s.OverlayAddr.String(), "IP assignment collision for hostname %q", n)
@@ -65,11 +73,11 @@ func Test_State_AssignOverlayAddr_no_obvious_collisions(t *testing.T) {
 func Test_State_AssignOverlayAddr_consistent(t *testing.T) {
 	prefix := netip.MustParsePrefix("10.0.0.0/8")
 	s1 := &State{}
-	err := s1.assignOverlayAddr(prefix, "test")
+	err := s1.assignOverlayAddr(prefix, "test", "")
 	require.NoError(t, err)
 
 	s2 := &State{}
-	err = s2.assignOverlayAddr(prefix, "test")
+	err = s2.assignOverlayAddr(prefix, "test", "")
 	require.NoError(t, err)
 
 	assert.Equal(t, s1.OverlayAddr.String(), s2.OverlayAddr.String())
@@ -78,13 +86,249 @@ func Test_State_AssignOverlayAddr_consistent(t *testing.T) {
 func Test_State_AssignOverlayAddr_repeatable(t *testing.T) {
 	prefix := netip.MustParsePrefix("10.0.0.0/8")
 	s := &State{}
-	err := s.assignOverlayAddr(prefix, "test")
+	err := s.assignOverlayAddr(prefix, "test", "")
 	require.NoError(t, err)
 	gen1 := s.OverlayAddr.String()
 
-	err = s.assignOverlayAddr(prefix, "test")
+	err = s.assignOverlayAddr(prefix, "test", "")
 	require.NoError(t, err)
 	gen2 := s.OverlayAddr.String()
 
 	assert.Equal(t, gen1, gen2)
 }
+
+func Test_State_AssignOverlayAddr_reserved(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	reserved, err := ParseAddrRange("10.0.0.160-10.0.0.170")
+	require.NoError(t, err)
+
+	s := &State{addrOpts: AddrOptions{Reserved: []AddrRange{reserved}}}
+	err = s.assignOverlayAddr(prefix, "test", "")
+	require.NoError(t, err)
+
+	assert.True(t, prefix.Contains(s.OverlayAddr))
+	assert.False(t, reserved.Contains(s.OverlayAddr), "assigned reserved address %s", s.OverlayAddr)
+
+	// static assignments may use reserved addresses
+	err = s.assignOverlayAddr(prefix, "test", "10.0.0.165")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.165", s.OverlayAddr.String())
+}
+
+func Test_State_AssignOverlayAddr_auto(t *testing.T) {
+	for _, prefix := range []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/64")} {
+		hashed := &State{}
+		require.NoError(t, hashed.assignOverlayAddr(prefix, "test", ""))
+
+		for _, wgAddress := range []string{"", "0.0.0.0", "::"} {
+			t.Run(prefix.String()+" "+wgAddress, func(t *testing.T) {
+				s := &State{}
+				require.NoError(t, s.assignOverlayAddr(prefix, "test", wgAddress))
+				assert.Equal(t, hashed.OverlayAddr, s.OverlayAddr)
+			})
+		}
+	}
+}
+
+func Test_ParseAddrRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"10.0.0.1-10.0.0.16", "10.0.0.1-10.0.0.16", false},
+		{"10.0.0.1", "10.0.0.1-10.0.0.1", false},
+		{"2001:db8::1-2001:db8::ff", "2001:db8::1-2001:db8::ff", false},
+		{"10.0.0.16-10.0.0.1", "", true},
+		{"10.0.0.1-2001:db8::1", "", true},
+		{"foo", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseAddrRange(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func Test_State_AssignOverlayAddr_seed(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+
+	unseeded := &State{}
+	require.NoError(t, unseeded.assignOverlayAddr(prefix, "test", ""))
+	zeroSeed := &State{addrOpts: AddrOptions{HashSeed: 0}}
+	require.NoError(t, zeroSeed.assignOverlayAddr(prefix, "test", ""))
+	assert.Equal(t, unseeded.OverlayAddr, zeroSeed.OverlayAddr)
+
+	seeded1 := &State{addrOpts: AddrOptions{HashSeed: 1}}
+	require.NoError(t, seeded1.assignOverlayAddr(prefix, "test", ""))
+	assert.NotEqual(t, unseeded.OverlayAddr, seeded1.OverlayAddr)
+
+	seeded2 := &State{addrOpts: AddrOptions{HashSeed: 1}}
+	require.NoError(t, seeded2.assignOverlayAddr(prefix, "test", ""))
+	assert.Equal(t, seeded1.OverlayAddr, seeded2.OverlayAddr)
+}
+
+func Test_State_AssignOverlayAddr_format(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+
+	hashed := &State{}
+	require.NoError(t, hashed.assignOverlayAddr(prefix, "test", ""))
+
+	format, err := ParseAddrFormat(`10.42.{{index .HashBytes 14}}.{{index .HashBytes 15}}`)
+	require.NoError(t, err)
+	formatted := &State{addrOpts: AddrOptions{Format: format}}
+	require.NoError(t, formatted.assignOverlayAddr(prefix, "test", ""))
+	want := hashed.OverlayAddr.As4()
+	assert.Equal(t, netip.AddrFrom4([4]byte{10, 42, want[2], want[3]}), formatted.OverlayAddr)
+
+	format, err = ParseAddrFormat(`192.168.0.1`)
+	require.NoError(t, err)
+	outside := &State{addrOpts: AddrOptions{Format: format}}
+	assert.Error(t, outside.assignOverlayAddr(prefix, "test", ""), "addresses outside of the overlay network must be rejected")
+
+	format, err = ParseAddrFormat(`{{.HashHex}}`)
+	require.NoError(t, err)
+	invalid := &State{addrOpts: AddrOptions{Format: format}}
+	assert.Error(t, invalid.assignOverlayAddr(prefix, "test", ""))
+}
+
+func Test_State_AssignOverlayAddr_pubkey(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	privKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	otherPrivKey, err := wgtypes.GeneratePrivateKey()
+	require.NoError(t, err)
+	opts := AddrOptions{From: AddrFromPubKey}
+
+	s1 := &State{PubKey: privKey.PublicKey(), addrOpts: opts}
+	require.NoError(t, s1.assignOverlayAddr(prefix, "test1", ""))
+	s2 := &State{PubKey: privKey.PublicKey(), addrOpts: opts}
+	require.NoError(t, s2.assignOverlayAddr(prefix, "test2", ""))
+	assert.Equal(t, s1.OverlayAddr, s2.OverlayAddr)
+
+	s3 := &State{PubKey: otherPrivKey.PublicKey(), addrOpts: opts}
+	require.NoError(t, s3.assignOverlayAddr(prefix, "test1", ""))
+	assert.NotEqual(t, s1.OverlayAddr, s3.OverlayAddr)
+}
+
+func Test_State_resolveEndpointIP(t *testing.T) {
+	defer func(orig func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = orig }(lookupIPAddr)
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if host == "node.example.com" {
+			return []net.IPAddr{{IP: net.ParseIP("2001:db8::2")}, {IP: net.ParseIP("192.0.2.2")}}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	s := &State{}
+	node := common.Node{Name: "node", Addr: net.ParseIP("192.0.2.1")}
+	assert.Equal(t, "192.0.2.1", s.resolveEndpointIP(node).String())
+
+	node.FQDN = "node.example.com"
+	assert.Equal(t, "2001:db8::2", s.resolveEndpointIP(node).String(), "must use the first address without bind address")
+	s.BindAddr = netip.MustParseAddr("0.0.0.0")
+	assert.Equal(t, "192.0.2.2", s.resolveEndpointIP(node).String(), "must prefer the family of the bind address")
+	s.BindAddr = netip.MustParseAddr("2001:db8::1")
+	assert.Equal(t, "2001:db8::2", s.resolveEndpointIP(node).String())
+
+	node.FQDN = "unknown.example.com"
+	assert.Equal(t, "192.0.2.1", s.resolveEndpointIP(node).String(), "must fall back to the node address")
+}
+
+func Test_fqdnCache(t *testing.T) {
+	defer func(orig func(context.Context, string) ([]net.IPAddr, error)) { lookupIPAddr = orig }(lookupIPAddr)
+	var lookups int32
+	lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if atomic.AddInt32(&lookups, 1) > 1 {
+			return nil, errors.New("server failure")
+		}
+		return []net.IPAddr{{IP: net.ParseIP("192.0.2.2")}}, nil
+	}
+
+	var c fqdnCache
+	want := []net.IPAddr{{IP: net.ParseIP("192.0.2.2")}}
+	assert.Equal(t, want, c.lookup("node.example.com"))
+	assert.Equal(t, want, c.lookup("node.example.com"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups), "fresh entries must not be resolved again")
+
+	// expired entries are refreshed in the background, keeping the previous addresses on failure
+	c.mu.Lock()
+	c.entries["node.example.com"].expires = time.Now().Add(-time.Second)
+	c.mu.Unlock()
+	assert.Equal(t, want, c.lookup("node.example.com"))
+	assert.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return !c.entries["node.example.com"].refreshing
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+	assert.Equal(t, want, c.lookup("node.example.com"))
+}
+
+func Test_PrefixRange(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"10.0.0.0/26", "10.0.0.0-10.0.0.63"},
+		{"10.0.0.0/8", "10.0.0.0-10.255.255.255"},
+		{"10.0.0.5/32", "10.0.0.5-10.0.0.5"},
+		{"10.1.2.3/20", "10.1.0.0-10.1.15.255"},
+		{"2001:db8::/120", "2001:db8::-2001:db8::ff"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			assert.Equal(t, tt.want, PrefixRange(netip.MustParsePrefix(tt.prefix)).String())
+		})
+	}
+}
+
+func Test_State_AssignOverlayAddr_reserved_prefix(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	reserved := PrefixRange(netip.MustParsePrefix("10.0.0.128/25"))
+
+	for _, name := range []string{"test", "test1", "test2", "test3", "test4"} {
+		s := &State{addrOpts: AddrOptions{Reserved: []AddrRange{reserved}}}
+		require.NoError(t, s.assignOverlayAddr(prefix, name, ""))
+		assert.False(t, reserved.Contains(s.OverlayAddr), "assigned reserved address %s", s.OverlayAddr)
+	}
+}
+
+func Test_State_AssignOverlayAddr_infrastructure(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+	opts := AddrOptions{Infrastructure: []string{"gw1", "gw2"}, InfrastructureRange: 16}
+
+	for name, want := range map[string]string{"gw1": "10.0.0.1", "gw2": "10.0.0.2"} {
+		s := &State{addrOpts: opts}
+		require.NoError(t, s.assignOverlayAddr(prefix, name, ""))
+		assert.Equal(t, want, s.OverlayAddr.String())
+	}
+
+	infra := PrefixRange(netip.MustParsePrefix("10.0.0.0/28"))
+	for i := 0; i < 100; i++ {
+		s := &State{addrOpts: opts}
+		require.NoError(t, s.assignOverlayAddr(prefix, fmt.Sprintf("node%d", i), ""))
+		assert.False(t, infra.Contains(s.OverlayAddr), "hashed address %s must not be in the infrastructure range", s.OverlayAddr)
+	}
+
+	s := &State{addrOpts: AddrOptions{Infrastructure: make([]string, 300)}}
+	s.addrOpts.Infrastructure[299] = "gw300"
+	assert.Error(t, s.assignOverlayAddr(prefix, "gw300", ""), "infrastructure addresses must fit in the overlay network")
+}
+
+func Test_AddrOptions_infrastructureReserved(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+
+	assert.False(t, AddrOptions{}.infrastructureReserved(prefix, netip.MustParseAddr("10.0.0.1")))
+
+	opts := AddrOptions{Infrastructure: []string{"gw1", "gw2"}}
+	assert.True(t, opts.infrastructureReserved(prefix, netip.MustParseAddr("10.0.0.0")))
+	assert.True(t, opts.infrastructureReserved(prefix, netip.MustParseAddr("10.0.0.2")))
+	assert.False(t, opts.infrastructureReserved(prefix, netip.MustParseAddr("10.0.0.3")), "default range must only cover listed nodes")
+}